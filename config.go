@@ -0,0 +1,387 @@
+package surfkit
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helloink/surfkit/secrets"
+)
+
+// LoadConfig fills the struct cfg points to from the environment. Fields are described
+// by struct tags:
+//
+//	type Config struct {
+//		DatabaseURL *url.URL       `env:"DATABASE_URL" required:"true" desc:"Postgres to store orders in"`
+//		Workers     int            `env:"WORKERS" default:"4"`
+//		Timeout     time.Duration  `env:"TIMEOUT" default:"30s"`
+//		Countries   []string       `env:"COUNTRIES" default:"de,at,ch"`
+//		Limits      map[string]int `env:"LIMITS" desc:"Orders per country, e.g. de=10,at=5"`
+//	}
+//
+// The env tag names the variable. A variable which is missing or empty takes the value of
+// the default tag, if any, and fails if the required tag is true. Fields without env tag
+// are left as is, unless they are structs, whose fields are loaded as well.
+//
+// Besides strings, LoadConfig parses ints, uints, floats, bools, durations, absolute URLs
+// and types implementing encoding.TextUnmarshaler. Slices are read from comma separated
+// lists, maps from comma separated key=value pairs.
+//
+// Variables which aren't set are read from the file NAME_FILE points to, if any, e.g. a
+// secret mounted by Cloud Run. Values referring to secrets, e.g. sm://my-project/db-password,
+// are resolved as described in package secrets.
+//
+// Fields of type *secrets.Secret never reveal their value when printed or logged and are
+// refreshed by RefreshSecrets. Parsing errors of fields tagged with secret:"true" don't
+// reveal their value either.
+//
+// All missing and invalid variables are reported together in one error.
+func LoadConfig(cfg interface{}) error {
+	vars, err := configVars(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var errs []error
+	for _, v := range vars {
+		err := v.load(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration (%v)", joinErrors(errs))
+	}
+
+	return nil
+}
+
+// RefreshSecrets reads the *secrets.Secret fields of the config struct cfg points to again.
+// Secrets which fail to refresh keep their previous value.
+func RefreshSecrets(ctx context.Context, cfg interface{}) error {
+	vars, err := configVars(cfg)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, v := range vars {
+		secret, ok := v.field.Interface().(*secrets.Secret)
+		if !ok || secret == nil {
+			continue
+		}
+
+		err := secret.Refresh(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to refresh secrets (%v)", joinErrors(errs))
+	}
+
+	return nil
+}
+
+// A configVar is a field of a config struct read from the environment.
+type configVar struct {
+	Name        string
+	Default     string
+	Required    bool
+	Secret      bool
+	Description string
+
+	field reflect.Value
+}
+
+// configVars returns the variables of the config struct cfg points to.
+func configVars(cfg interface{}) ([]*configVar, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("config must be a pointer to a struct")
+	}
+
+	var vars []*configVar
+	err := collectConfigVars(v.Elem(), &vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+func collectConfigVars(v reflect.Value, vars *[]*configVar) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		name, ok := f.Tag.Lookup("env")
+		if !ok {
+			if field.Kind() == reflect.Struct && !isConfigValue(field) {
+				err := collectConfigVars(field, vars)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if name == "" {
+			return fmt.Errorf("empty env tag on %s.%s", t.Name(), f.Name)
+		}
+
+		required, err := strconv.ParseBool(withDefault(f.Tag.Get("required"), "false"))
+		if err != nil {
+			return fmt.Errorf("invalid required tag on %s.%s (%v)", t.Name(), f.Name, err)
+		}
+
+		secret, err := strconv.ParseBool(withDefault(f.Tag.Get("secret"), "false"))
+		if err != nil {
+			return fmt.Errorf("invalid secret tag on %s.%s (%v)", t.Name(), f.Name, err)
+		}
+
+		*vars = append(*vars, &configVar{
+			Name:        name,
+			Default:     f.Tag.Get("default"),
+			Required:    required,
+			Secret:      secret || field.Type() == secretType,
+			Description: f.Tag.Get("desc"),
+			field:       field,
+		})
+	}
+
+	return nil
+}
+
+// load reads the variable from the environment into its field.
+func (c *configVar) load(ctx context.Context) error {
+	if c.field.Type() == secretType {
+		secret := secrets.NewSecret(c.read)
+		err := secret.Refresh(ctx)
+		if err != nil {
+			return err
+		}
+
+		c.field.Set(reflect.ValueOf(secret))
+		return nil
+	}
+
+	raw, err := c.read(ctx)
+	if err != nil || raw == "" {
+		return err
+	}
+
+	err = parseConfigValue(c.field, raw)
+	if err != nil {
+		if c.isSecret() {
+			return fmt.Errorf("invalid %s", c.Name)
+		}
+		return fmt.Errorf("invalid %s (%v)", c.Name, err)
+	}
+
+	return nil
+}
+
+// read returns the value of the variable, falling back to its default. It fails if the
+// variable is required but missing.
+func (c *configVar) read(ctx context.Context) (string, error) {
+	raw, _, err := lookupEnv(ctx, c.Name)
+	if err != nil {
+		return "", err
+	}
+
+	if raw != "" {
+		return raw, nil
+	}
+	if c.Required {
+		return "", c.missing()
+	}
+
+	return c.Default, nil
+}
+
+// isSecret reports whether the variable is marked as secret or read from a secret's file
+// or reference.
+func (c *configVar) isSecret() bool {
+	if c.Secret {
+		return true
+	}
+
+	val := os.Getenv(c.Name)
+	if val == "" {
+		return os.Getenv(c.Name+"_FILE") != ""
+	}
+
+	return secrets.IsReference(val)
+}
+
+func (c *configVar) missing() error {
+	if c.Description != "" {
+		return fmt.Errorf("missing %s, %s", c.Name, c.Description)
+	}
+
+	return fmt.Errorf("missing %s", c.Name)
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	secretType          = reflect.TypeOf((*secrets.Secret)(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isConfigValue reports whether v is a struct parsed as a whole rather than field by field.
+func isConfigValue(v reflect.Value) bool {
+	return v.Type() == urlType || reflect.PtrTo(v.Type()).Implements(textUnmarshalerType)
+}
+
+// parseConfigValue parses raw into v.
+func parseConfigValue(v reflect.Value, raw string) error {
+	t := v.Type()
+
+	if t.Kind() == reflect.Ptr {
+		p := reflect.New(t.Elem())
+		err := parseConfigValue(p.Elem(), raw)
+		if err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+
+	switch t {
+	case durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+
+	case urlType:
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() {
+			return errors.New("URL must be absolute")
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, t.Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		items := splitList(raw)
+		s := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			err := parseConfigValue(s.Index(i), item)
+			if err != nil {
+				return fmt.Errorf("invalid item %d (%v)", i, err)
+			}
+		}
+		v.Set(s)
+
+	case reflect.Map:
+		m := reflect.MakeMap(t)
+		for _, pair := range splitList(raw) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%q is not a key=value pair", pair)
+			}
+
+			key := reflect.New(t.Key()).Elem()
+			err := parseConfigValue(key, strings.TrimSpace(kv[0]))
+			if err != nil {
+				return fmt.Errorf("invalid key %q (%v)", kv[0], err)
+			}
+
+			val := reflect.New(t.Elem()).Elem()
+			err = parseConfigValue(val, strings.TrimSpace(kv[1]))
+			if err != nil {
+				return fmt.Errorf("invalid value of %q (%v)", kv[0], err)
+			}
+
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+
+	default:
+		return fmt.Errorf("unsupported type %s", t)
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring blanks around its items.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// withDefault returns s, or def if s is empty.
+func withDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
@@ -0,0 +1,315 @@
+package surfkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit/secrets"
+)
+
+// level implements encoding.TextUnmarshaler.
+type level int
+
+func (l *level) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", b)
+	}
+
+	return nil
+}
+
+func mustParseURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestLoadConfig(t *testing.T) {
+	type scalars struct {
+		Name    string        `env:"SKTEST_NAME" default:"orders"`
+		Workers int           `env:"SKTEST_WORKERS" default:"4"`
+		Small   int8          `env:"SKTEST_SMALL"`
+		Size    uint          `env:"SKTEST_SIZE"`
+		Ratio   float64       `env:"SKTEST_RATIO"`
+		Debug   bool          `env:"SKTEST_DEBUG"`
+		Timeout time.Duration `env:"SKTEST_TIMEOUT" default:"30s"`
+		Ignored string
+	}
+
+	type lists struct {
+		Countries []string       `env:"SKTEST_COUNTRIES" default:"de,at,ch"`
+		Ports     []int          `env:"SKTEST_PORTS"`
+		Limits    map[string]int `env:"SKTEST_LIMITS"`
+	}
+
+	type pointers struct {
+		Workers *int     `env:"SKTEST_WORKERS"`
+		Unset   *int     `env:"SKTEST_UNSET"`
+		Target  *url.URL `env:"SKTEST_TARGET"`
+	}
+
+	type values struct {
+		Level   level   `env:"SKTEST_LEVEL" default:"low"`
+		Levels  []level `env:"SKTEST_LEVELS"`
+		Target  url.URL `env:"SKTEST_TARGET"`
+		Nothing url.URL `env:"SKTEST_NOTHING"`
+	}
+
+	type nested struct {
+		Server struct {
+			Port int `env:"SKTEST_PORT" default:"8080"`
+		}
+		Name string `env:"SKTEST_NAME"`
+	}
+
+	type required struct {
+		DatabaseURL *url.URL `env:"SKTEST_DATABASE_URL" required:"true" desc:"Postgres to store orders in"`
+		Region      string   `env:"SKTEST_REGION" required:"true"`
+		Workers     int      `env:"SKTEST_WORKERS" default:"4"`
+		Password    string   `env:"SKTEST_PASSWORD" secret:"true"`
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		cfg     interface{}
+		want    interface{}
+		wantErr []string
+	}{
+		{
+			name: "defaults",
+			cfg:  &scalars{Ignored: "kept"},
+			want: &scalars{Name: "orders", Workers: 4, Timeout: 30 * time.Second, Ignored: "kept"},
+		},
+		{
+			name: "scalars",
+			env: map[string]string{
+				"SKTEST_NAME":    "billing",
+				"SKTEST_WORKERS": "0x10",
+				"SKTEST_SMALL":   "-8",
+				"SKTEST_SIZE":    "42",
+				"SKTEST_RATIO":   "0.5",
+				"SKTEST_DEBUG":   "true",
+				"SKTEST_TIMEOUT": "1m30s",
+			},
+			cfg:  &scalars{},
+			want: &scalars{Name: "billing", Workers: 16, Small: -8, Size: 42, Ratio: 0.5, Debug: true, Timeout: 90 * time.Second},
+		},
+		{
+			name:    "out of range",
+			env:     map[string]string{"SKTEST_SMALL": "300"},
+			cfg:     &scalars{},
+			wantErr: []string{"invalid SKTEST_SMALL", "out of range"},
+		},
+		{
+			name:    "negative uint",
+			env:     map[string]string{"SKTEST_SIZE": "-1"},
+			cfg:     &scalars{},
+			wantErr: []string{"invalid SKTEST_SIZE"},
+		},
+		{
+			name:    "invalid duration",
+			env:     map[string]string{"SKTEST_TIMEOUT": "30"},
+			cfg:     &scalars{},
+			wantErr: []string{"invalid SKTEST_TIMEOUT"},
+		},
+		{
+			name: "lists",
+			env: map[string]string{
+				"SKTEST_PORTS":  " 80, 443 ,",
+				"SKTEST_LIMITS": "de=10, at = 5",
+			},
+			cfg: &lists{},
+			want: &lists{
+				Countries: []string{"de", "at", "ch"},
+				Ports:     []int{80, 443},
+				Limits:    map[string]int{"de": 10, "at": 5},
+			},
+		},
+		{
+			name:    "invalid list item",
+			env:     map[string]string{"SKTEST_PORTS": "80,http"},
+			cfg:     &lists{},
+			wantErr: []string{"invalid SKTEST_PORTS", "invalid item 1"},
+		},
+		{
+			name:    "invalid map pair",
+			env:     map[string]string{"SKTEST_LIMITS": "de=10,at"},
+			cfg:     &lists{},
+			wantErr: []string{"invalid SKTEST_LIMITS", `"at" is not a key=value pair`},
+		},
+		{
+			name:    "invalid map value",
+			env:     map[string]string{"SKTEST_LIMITS": "de=many"},
+			cfg:     &lists{},
+			wantErr: []string{"invalid SKTEST_LIMITS", `invalid value of "de"`},
+		},
+		{
+			name: "pointers",
+			env: map[string]string{
+				"SKTEST_WORKERS": "8",
+				"SKTEST_TARGET":  "https://example.com/orders",
+			},
+			cfg:  &pointers{},
+			want: &pointers{Workers: intPtr(8), Target: mustParseURL("https://example.com/orders")},
+		},
+		{
+			name: "text unmarshaler and URL",
+			env: map[string]string{
+				"SKTEST_LEVELS": "high,low",
+				"SKTEST_TARGET": "postgres://db:5432/orders?sslmode=disable",
+			},
+			cfg:  &values{},
+			want: &values{Level: 1, Levels: []level{2, 1}, Target: *mustParseURL("postgres://db:5432/orders?sslmode=disable")},
+		},
+		{
+			name:    "invalid text",
+			env:     map[string]string{"SKTEST_LEVEL": "medium"},
+			cfg:     &values{},
+			wantErr: []string{"invalid SKTEST_LEVEL", `unknown level "medium"`},
+		},
+		{
+			name:    "relative URL",
+			env:     map[string]string{"SKTEST_TARGET": "/orders"},
+			cfg:     &values{},
+			wantErr: []string{"invalid SKTEST_TARGET", "URL must be absolute"},
+		},
+		{
+			name:    "relative URL pointer",
+			env:     map[string]string{"SKTEST_TARGET": "example.com/orders"},
+			cfg:     &pointers{},
+			wantErr: []string{"invalid SKTEST_TARGET", "URL must be absolute"},
+		},
+		{
+			name: "nested structs",
+			env:  map[string]string{"SKTEST_NAME": "orders"},
+			cfg:  &nested{},
+			want: func() *nested {
+				n := &nested{Name: "orders"}
+				n.Server.Port = 8080
+				return n
+			}(),
+		},
+		{
+			name:    "required with description",
+			env:     map[string]string{"SKTEST_REGION": "eu"},
+			cfg:     &required{},
+			wantErr: []string{"missing SKTEST_DATABASE_URL, Postgres to store orders in"},
+		},
+		{
+			name: "aggregated errors",
+			env: map[string]string{
+				"SKTEST_WORKERS":  "many",
+				"SKTEST_PASSWORD": "hunter2",
+			},
+			cfg: &required{},
+			wantErr: []string{
+				"missing SKTEST_DATABASE_URL, Postgres to store orders in",
+				"missing SKTEST_REGION",
+				"invalid SKTEST_WORKERS",
+			},
+		},
+		{
+			name:    "not a pointer",
+			cfg:     scalars{},
+			wantErr: []string{"config must be a pointer to a struct"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			err := LoadConfig(tt.cfg)
+
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("LoadConfig succeeded with %+v", tt.cfg)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q doesn't contain %q", err, want)
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %v", err)
+			}
+			if !reflect.DeepEqual(tt.cfg, tt.want) {
+				t.Errorf("LoadConfig got %+v, want %+v", tt.cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	type config struct {
+		Password string          `env:"SKTEST_PASSWORD"`
+		Token    *secrets.Secret `env:"SKTEST_TOKEN"`
+		Port     int             `env:"SKTEST_PORT" secret:"true"`
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(path, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SKTEST_PASSWORD_FILE", path)
+	t.Setenv("SKTEST_TOKEN", "s3cr3t")
+
+	var cfg config
+	if err := LoadConfig(&cfg); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Password != "hunter2" {
+		t.Errorf("password = %q, want the content of SKTEST_PASSWORD_FILE", cfg.Password)
+	}
+	if cfg.Token.Value() != "s3cr3t" {
+		t.Errorf("token = %q, want s3cr3t", cfg.Token.Value())
+	}
+	if s := fmt.Sprintf("%v %+v %#v %s", cfg.Token, cfg, cfg, cfg.Token); strings.Contains(s, "s3cr3t") {
+		t.Errorf("secret revealed when printed: %s", s)
+	}
+	if b, err := json.Marshal(cfg); err != nil || strings.Contains(string(b), "s3cr3t") {
+		t.Errorf("json.Marshal = %s, %v, want the secret redacted", b, err)
+	}
+
+	// Invalid values of secrets aren't revealed either
+	t.Setenv("SKTEST_PORT", "s3cr3t")
+
+	err = LoadConfig(&cfg)
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") || !strings.Contains(err.Error(), "invalid SKTEST_PORT") {
+		t.Errorf("LoadConfig error = %v, want SKTEST_PORT invalid without its value", err)
+	}
+}
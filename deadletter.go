@@ -0,0 +1,70 @@
+package surfkit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/helloink/surfkit/transport"
+)
+
+// republishIdle ends RepublishDeadLetters once no dead letter arrived for this long.
+const republishIdle = 5 * time.Second
+
+// ensureSubscription creates or reconciles the subscription and, given it has a dead letter
+// policy, creates the dead letter topic including a subscription which keeps the dead letters
+// until they are republished. Several subscriptions may share a dead letter topic, the dead
+// letter subscription only receives those of the subscription it belongs to.
+func ensureSubscription(s *Service, name string, cfg transport.SubscriptionConfig) error {
+	ctx := s.baseContext()
+
+	if p := cfg.DeadLetterPolicy; p != nil {
+		err := s.Transport.EnsureTopic(ctx, p.Topic)
+		if err != nil {
+			return fmt.Errorf("failed to setup dead letter topic %s (%v)", p.Topic, err)
+		}
+
+		err = s.Transport.EnsureSubscription(ctx, deadLetterSubscription(name), transport.SubscriptionConfig{
+			Topic:       p.Topic,
+			AckDeadline: defaultAckDeadline,
+			Filter:      fmt.Sprintf("attributes.%s = %q", transport.DeadLetterSourceSubscriptionAttribute, name),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to setup dead letter subscription for %s (%v)", name, err)
+		}
+	}
+
+	return reconcileSubscription(s, name, cfg)
+}
+
+// deadLetterSubscription names the subscription keeping the dead letters of a subscription.
+func deadLetterSubscription(name string) string {
+	return name + "-dead-letters"
+}
+
+// RepublishDeadLetters moves the dead letters of the named subscription back to the
+// subscription's topic, e.g. to recover after an incident. It returns how many messages
+// were republished once no more dead letters arrive or ctx is done.
+func RepublishDeadLetters(ctx context.Context, s *Service, subscription string) (int, error) {
+	var topic string
+	var policy *transport.DeadLetterPolicy
+
+	for _, sub := range pubsubSubscriptions(s) {
+		if sub.GetName() != subscription {
+			continue
+		}
+
+		switch t := sub.(type) {
+		case *PushSubscription:
+			topic, policy = t.Topic, t.DeadLetterPolicy
+		case *PullSubscription:
+			topic, policy = t.Topic, t.DeadLetterPolicy
+		}
+	}
+
+	if policy == nil {
+		return 0, fmt.Errorf("subscription %s has no dead letter policy", subscription)
+	}
+
+	return transport.Republish(ctx, s.Transport, deadLetterSubscription(subscription), subscription, topic, republishIdle)
+}
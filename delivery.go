@@ -0,0 +1,46 @@
+package surfkit
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery holds the Pubsub metadata of the message a CloudEvent was received with.
+// Handlers can retrieve it using DeliveryFromContext.
+type Delivery struct {
+
+	// Subscription the message was received on.
+	Subscription string
+
+	// MessageID as assigned by the Pubsub server.
+	MessageID string
+
+	// Attributes the message was labelled with.
+	Attributes map[string]string
+
+	// PublishTime is the time the message was published.
+	PublishTime time.Time
+
+	// OrderingKey of the message, if any. It is only set for push subscriptions and
+	// the in-memory transport, the Pubsub client in use doesn't report it to pull
+	// subscriptions.
+	OrderingKey string
+
+	// DeliveryAttempt counts how often the message has been delivered. Pubsub only
+	// reports it to push subscriptions with dead lettering enabled, it is 0 otherwise.
+	// The in-memory transport always reports it.
+	DeliveryAttempt int
+}
+
+type deliveryKey struct{}
+
+// WithDelivery returns a copy of ctx that carries d.
+func WithDelivery(ctx context.Context, d *Delivery) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, d)
+}
+
+// DeliveryFromContext returns the Delivery stored in ctx or nil if there is none.
+func DeliveryFromContext(ctx context.Context) *Delivery {
+	d, _ := ctx.Value(deliveryKey{}).(*Delivery)
+	return d
+}
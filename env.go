@@ -1,8 +1,13 @@
 package surfkit
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/secrets"
 )
 
 // ServiceEnv contains configuration read from the environment.
@@ -13,24 +18,81 @@ type ServiceEnv struct {
 
 	// The ID of the Project this service is running on.
 	ProjectID string
+
+	// Host is the public base URL of the service, e.g. https://my-service.a.run.app.
+	// It is used to register push subscriptions.
+	Host string
+
+	// hostSet is true if HOST was present in the environment, even if empty.
+	hostSet bool
 }
 
 // Env reads a variable from ENV or fails fatal
 // TODO: - I think this method should totally be called EnvF [AW]
 func Env(s string) string {
-	val, ok := os.LookupEnv(s)
-	if !ok {
-		log.Fatalf("failed to read %s from env", s)
+	val, err := ReadEnv(s)
+	if err != nil {
+		logging.Default.Critical(err.Error(), logging.Fields{"variable": s})
+		os.Exit(1)
 	}
 
 	return val
 }
 
-// Read vital configuration from the environment and set fallbacks or fail
-func assertEnvironment(s *Service) {
+// ReadEnv reads a variable from ENV or returns an error if it is missing.
+//
+// Unless set, the variable is read from the file NAME_FILE points to. References to secrets
+// are resolved, see package secrets. Both happen on every call.
+func ReadEnv(s string) (string, error) {
+	val, ok, err := lookupEnv(context.Background(), s)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("failed to read %s from env", s)
+	}
+
+	return val, nil
+}
+
+// lookupEnv reads the variable name from the environment. If it isn't set or empty, it is
+// read from the file NAME_FILE points to, if any. References to secrets are resolved.
+// Errors never contain the value.
+func lookupEnv(ctx context.Context, name string) (string, bool, error) {
+	val, ok := os.LookupEnv(name)
+	if val == "" {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			return val, ok, nil
+		}
+
+		secret, err := secrets.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read %s_FILE (%v)", name, err)
+		}
+
+		return secret, true, nil
+	}
+
+	val, err := secrets.Resolve(ctx, val)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s (%v)", name, err)
+	}
+
+	return val, true, nil
+}
+
+// Read vital configuration from the environment and set fallbacks or fail.
+// An Env set beforehand is used as is.
+func assertEnvironment(s *Service) error {
+	if s.Env != nil {
+		return nil
+	}
 
 	s.Env = &ServiceEnv{}
 
+	s.Env.Host, s.Env.hostSet = os.LookupEnv("HOST")
+
 	port, ok := os.LookupEnv("PORT")
 	if !ok {
 		port = "3000"
@@ -38,13 +100,15 @@ func assertEnvironment(s *Service) {
 
 	s.Env.Port = port
 
-	// If a Pubsub Subscription is set, the project id must be set in ENV.
-	if s.Subscription != nil || len(s.Subscriptions) > 0 {
+	// If Pubsub is used, the project id must be set in ENV.
+	if s.Transport == nil && usesTransport(s) {
 		projectID, ok := os.LookupEnv("PUBSUB_PROJECT_ID")
 		if !ok {
-			log.Fatal("in order to use pubsub make sure PUBSUB_PROJECT_ID is available in ENV")
+			return errors.New("in order to use pubsub make sure PUBSUB_PROJECT_ID is available in ENV")
 		}
 
 		s.Env.ProjectID = projectID
 	}
+
+	return nil
 }
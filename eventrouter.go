@@ -0,0 +1,157 @@
+package surfkit
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// ErrNoRoute is returned by an EventRouter which has no handler for an event. The message is nacked.
+var ErrNoRoute = errors.New("no handler for event")
+
+// An EventRouter dispatches events to handlers by their type or source. It is the event
+// side equivalent of Service.Router and plugs into any Subscription as its handler:
+//
+//	r := surfkit.NewEventRouter()
+//	r.HandleType("storiesservice.api.getstories.success", storiesLoaded)
+//	r.HandleGlob("homepage.useraction.*.tapped", tapped)
+//	r.HandleDefault(ignore)
+//
+//	&surfkit.PullSubscription{Name: "my-service", Topic: "my.topic", Handler: r.HandleEvent}
+//
+// Types follow the dotted convention `controller.eventtype.component.action`. If several
+// routes match an event, the most specific one wins: exact types before globs, globs before
+// prefixes, the longest prefix first and types before sources. Routes have to be set up
+// before the service starts.
+type EventRouter struct {
+	types    map[string]EventHandler
+	globs    []eventRoute
+	prefixes []eventRoute
+	sources  []eventRoute
+	fallback EventHandler
+}
+
+type eventRoute struct {
+	pattern string
+	handler EventHandler
+}
+
+// NewEventRouter returns an EventRouter without any routes.
+func NewEventRouter() *EventRouter {
+	return &EventRouter{types: make(map[string]EventHandler)}
+}
+
+// HandleType routes events of exactly the given type to h.
+func (r *EventRouter) HandleType(eventType string, h EventHandler) {
+	r.types[eventType] = h
+}
+
+// HandleGlob routes events whose type matches pattern to h. Within the pattern, `*` stands
+// for exactly one segment of the dotted type and `**` for any number of segments,
+// e.g. `homepage.*.*.tapped` or `storiesservice.**.success`.
+// Globs are tried in the order they were added.
+func (r *EventRouter) HandleGlob(pattern string, h EventHandler) {
+	r.globs = append(r.globs, eventRoute{pattern, h})
+}
+
+// HandlePrefix routes events whose type starts with the given segments to h,
+// e.g. `storiesservice.api` covers `storiesservice.api.getstories.success`.
+func (r *EventRouter) HandlePrefix(prefix string, h EventHandler) {
+	r.prefixes = insertByLength(r.prefixes, eventRoute{strings.TrimSuffix(prefix, "."), h})
+}
+
+// HandleSource routes events whose source equals or starts with the given prefix to h,
+// e.g. `alfred.` covers events from every version of the alfred service.
+func (r *EventRouter) HandleSource(prefix string, h EventHandler) {
+	r.sources = insertByLength(r.sources, eventRoute{prefix, h})
+}
+
+// HandleDefault routes every event no other route matches to h. Without a default handler,
+// such events are logged as warning and nacked with ErrNoRoute, so Pubsub redelivers them
+// until a route is added or they end up in the dead letter topic. A default handler
+// returning nil or a Poison error acks them instead.
+func (r *EventRouter) HandleDefault(h EventHandler) {
+	r.fallback = h
+}
+
+// HandleEvent is an EventHandler dispatching e to the handler of the best matching route.
+func (r *EventRouter) HandleEvent(ctx context.Context, s *Service, e *events.CloudEvent) error {
+	h := r.match(e)
+	if h == nil {
+		logging.FromContext(ctx).Warning("No route for event", logging.Fields{"eventType": e.Type, "source": e.Source})
+		return ErrNoRoute
+	}
+
+	return h(ctx, s, e)
+}
+
+func (r *EventRouter) match(e *events.CloudEvent) EventHandler {
+	if h, ok := r.types[e.Type]; ok {
+		return h
+	}
+
+	segments := strings.Split(e.Type, ".")
+	for _, route := range r.globs {
+		if matchGlob(strings.Split(route.pattern, "."), segments) {
+			return route.handler
+		}
+	}
+
+	for _, route := range r.prefixes {
+		if e.Type == route.pattern || strings.HasPrefix(e.Type, route.pattern+".") {
+			return route.handler
+		}
+	}
+
+	for _, route := range r.sources {
+		if strings.HasPrefix(e.Source, route.pattern) {
+			return route.handler
+		}
+	}
+
+	return r.fallback
+}
+
+// matchGlob matches the segments of a dotted type against the segments of a pattern.
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	if pattern[0] != "*" && pattern[0] != segments[0] {
+		return false
+	}
+
+	return matchGlob(pattern[1:], segments[1:])
+}
+
+// insertByLength keeps routes ordered by descending pattern length, so the most specific
+// prefix is tried first.
+func insertByLength(routes []eventRoute, route eventRoute) []eventRoute {
+	i := 0
+	for i < len(routes) && len(routes[i].pattern) >= len(route.pattern) {
+		i++
+	}
+
+	routes = append(routes, eventRoute{})
+	copy(routes[i+1:], routes[i:])
+	routes[i] = route
+
+	return routes
+}
@@ -0,0 +1,149 @@
+package surfkit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// handledBy returns a handler failing with its name, to tell which route matched.
+func handledBy(name string) EventHandler {
+	return func(ctx context.Context, s *Service, e *events.CloudEvent) error {
+		return errors.New(name)
+	}
+}
+
+// recorder is a logging.Sink keeping all entries.
+type recorder struct {
+	mu      sync.Mutex
+	entries []*logging.Entry
+}
+
+func (r *recorder) Write(e *logging.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, e)
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		typ     string
+		want    bool
+	}{
+		{"homepage.*.*.tapped", "homepage.useraction.button.tapped", true},
+		{"homepage.*.*.tapped", "homepage.useraction.tapped", false},
+		{"homepage.*.*.tapped", "homepage.useraction.button.long.tapped", false},
+		{"homepage.*", "homepage", false},
+		{"*", "homepage", true},
+		{"*", "homepage.useraction", false},
+		{"storiesservice.**.success", "storiesservice.success", true},
+		{"storiesservice.**.success", "storiesservice.api.success", true},
+		{"storiesservice.**.success", "storiesservice.api.getstories.success", true},
+		{"storiesservice.**.success", "storiesservice.api.getstories.failure", false},
+		{"**", "anything.at.all", true},
+		{"**.tapped", "tapped", true},
+		{"homepage.**", "homepage", true},
+		{"homepage.**", "homepages.useraction", false},
+		{"a.**.b.**.c", "a.x.b.y.z.c", true},
+		{"a.**.b.**.c", "a.x.y.c", false},
+		{"homepage.useraction", "homepage.useraction", true},
+		{"homepage.useraction", "homepage.useractions", false},
+	}
+
+	for _, tt := range tests {
+		got := matchGlob(strings.Split(tt.pattern, "."), strings.Split(tt.typ, "."))
+		if got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestInsertByLength(t *testing.T) {
+	var routes []eventRoute
+	for _, pattern := range []string{"a.b", "a", "a.b.c.d", "x.y", "a.b.c"} {
+		routes = insertByLength(routes, eventRoute{pattern: pattern})
+	}
+
+	var got []string
+	for _, r := range routes {
+		got = append(got, r.pattern)
+	}
+
+	// Patterns of the same length keep the order they were added in
+	if want := "a.b.c.d a.b.c a.b x.y a"; strings.Join(got, " ") != want {
+		t.Errorf("routes ordered %v, want %s", got, want)
+	}
+}
+
+func TestEventRouterMatch(t *testing.T) {
+	r := NewEventRouter()
+	r.HandleType("storiesservice.api.getstories.success", handledBy("type"))
+	r.HandleGlob("storiesservice.**.success", handledBy("glob"))
+	r.HandleGlob("storiesservice.*.*.*", handledBy("later glob"))
+	r.HandlePrefix("storiesservice", handledBy("short prefix"))
+	r.HandlePrefix("storiesservice.api.", handledBy("long prefix"))
+	r.HandleSource("alfred.", handledBy("source"))
+	r.HandleSource("alfred.v2", handledBy("specific source"))
+
+	tests := []struct {
+		typ    string
+		source string
+		want   string
+	}{
+		{"storiesservice.api.getstories.success", "alfred.v1", "type"},
+		{"storiesservice.api.getfeed.success", "alfred.v1", "glob"},
+		{"storiesservice.api.getfeed.failure", "alfred.v1", "later glob"},
+		{"storiesservice.api.failure", "alfred.v1", "long prefix"},
+		{"storiesservice.api", "alfred.v1", "long prefix"},
+		{"storiesservice.admin.failure", "alfred.v1", "short prefix"},
+		{"storiesservicex.api.failure", "alfred.v1", "source"},
+		{"homepage.useraction.tapped", "alfred.v2.3", "specific source"},
+		{"homepage.useraction.tapped", "alfred", ""},
+		{"homepage.useraction.tapped", "batman.v1", ""},
+	}
+
+	for _, tt := range tests {
+		h := r.match(&events.CloudEvent{Type: tt.typ, Source: tt.source})
+
+		got := ""
+		if h != nil {
+			got = h(context.Background(), nil, nil).Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s from %s routed to %q, want %q", tt.typ, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestEventRouterNoRoute(t *testing.T) {
+	r := NewEventRouter()
+	r.HandleType("order.placed", handledBy("type"))
+
+	rec := &recorder{}
+	ctx := logging.NewContext(context.Background(), logging.New(rec, logging.Debug))
+	e := &events.CloudEvent{Type: "order.cancelled", Source: "shop"}
+
+	err := r.HandleEvent(ctx, nil, e)
+	if err != ErrNoRoute {
+		t.Fatalf("HandleEvent = %v, want ErrNoRoute", err)
+	}
+	if o := resolveOutcome(err); o.ack {
+		t.Error("event without route is acked, want a nack")
+	}
+	if len(rec.entries) != 1 || rec.entries[0].Severity != logging.Warning || rec.entries[0].Fields["eventType"] != "order.cancelled" {
+		t.Errorf("logged %+v, want a warning naming the event type", rec.entries)
+	}
+
+	// A default handler takes the events without route
+	r.HandleDefault(handledBy("default"))
+	if err := r.HandleEvent(ctx, nil, e); err == nil || err.Error() != "default" {
+		t.Errorf("HandleEvent = %v, want the default handler", err)
+	}
+}
@@ -1,58 +1,262 @@
 package events
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"os"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/helloink/surfkit/logging"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
-// Guidelines to construct a proper CloudEvent model: https://github.com/cloudevents/spec/blob/v0.3/spec.md#required-attributes
+// Guidelines to construct a proper CloudEvent model: https://github.com/cloudevents/spec/blob/v1.0/spec.md#required-attributes
 
 // Houserules:
 
 // Required:
 // ID => YearMonthDayHourMinuteSecondMilliSecond_RandomString	e.g. Y2019M08D23H19M20S14MS30_HerEcOmEsARaND0mstR1nG
 // source => service.version/UUID/SessionID/...    				e.g. alfred.1.0.0.a67d76776g7d67a
-// specversion => 0.3
+// specversion => 1.0
 // type => controller.eventtype.comoponent.action				e.g. homepage.useraction.donecta.tapped, storiesservice.api.getstories.success, etc..
 
-const specVersion = "0.3"
+// Supported CloudEvents spec versions.
+const (
+	SpecVersion10 = "1.0"
+	SpecVersion03 = "0.3"
+)
+
+const specVersion = SpecVersion10
 
-// CloudEvent represents an Event as described in https://github.com/cloudevents/spec/blob/v0.3/spec.md#event
+// CloudEvent represents an Event as described in https://github.com/cloudevents/spec/blob/v1.0/spec.md#event
+//
+// Events of spec version 0.3 are understood as well and keep their version when marshalled again.
 type CloudEvent struct {
-	ID          string      `json:"id"`
-	Source      string      `json:"source"`
-	Specversion string      `json:"specversion"`
-	Type        string      `json:"type"`
-	Time        time.Time   `json:"time"`
-	Data        interface{} `json:"data"`
+	ID          string
+	Source      string
+	Specversion string
+	Type        string
+
+	// Time is optional, the zero value is omitted.
+	Time time.Time
+
+	// DataContentType of Data, e.g. application/json. Optional.
+	DataContentType string
+
+	// DataSchema identifies the schema Data adheres to. Optional.
+	DataSchema string
+
+	// Subject of the event in the context of the source. Optional.
+	Subject string
+
+	// Data is marshalled as JSON, unless it is a []byte which is sent base64 encoded
+	// as data_base64. Unmarshalled binary data is a []byte as well.
+	Data interface{}
+
+	extensions map[string]interface{}
 }
 
 // NewCloudEvent returns a new and initialised CloudEvent
 func NewCloudEvent(source string, eventType string, payload interface{}) CloudEvent {
 	id, err := uuid.NewRandom()
 	if err != nil {
-		log.Fatalln("Failed to generate UUID:", err)
+		logging.Default.Critical("Failed to generate UUID", logging.Fields{"error": err})
+		os.Exit(1)
 	}
 
 	return CloudEvent{
-		ID:          id.String(),
-		Source:      source,
-		Specversion: specVersion,
-		Type:        eventType,
-		Time:        time.Now().UTC(),
-		Data:        payload,
+		ID:              id.String(),
+		Source:          source,
+		Specversion:     specVersion,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            payload,
+	}
+}
+
+// Validate checks that all required attributes are set and the spec version is supported.
+func (e *CloudEvent) Validate() error {
+	switch {
+	case e.Specversion != SpecVersion10 && e.Specversion != SpecVersion03:
+		return fmt.Errorf("unsupported specversion %q", e.Specversion)
+	case e.ID == "":
+		return errors.New("missing id")
+	case e.Source == "":
+		return errors.New("missing source")
+	case e.Type == "":
+		return errors.New("missing type")
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the event in the JSON format of its spec version.
+func (e CloudEvent) MarshalJSON() ([]byte, error) {
+	version := e.Specversion
+	if version == "" {
+		version = specVersion
+	}
+
+	m := make(map[string]interface{}, len(e.extensions)+9)
+	for name, v := range e.extensions {
+		switch v.(type) {
+		case string, bool, int32:
+			m[name] = v
+		default:
+			m[name] = formatExtension(v)
+		}
+	}
+
+	m["id"] = e.ID
+	m["source"] = e.Source
+	m["specversion"] = version
+	m["type"] = e.Type
+
+	if !e.Time.IsZero() {
+		m["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.DataContentType != "" {
+		m["datacontenttype"] = e.DataContentType
+	}
+	if e.Subject != "" {
+		m["subject"] = e.Subject
+	}
+
+	if e.DataSchema != "" {
+		if version == SpecVersion03 {
+			m["schemaurl"] = e.DataSchema
+		} else {
+			m["dataschema"] = e.DataSchema
+		}
+	}
+
+	switch d := e.Data.(type) {
+	case nil:
+	case json.RawMessage:
+		m["data"] = d
+	case []byte:
+		if version == SpecVersion03 {
+			m["datacontentencoding"] = "base64"
+			m["data"] = base64.StdEncoding.EncodeToString(d)
+		} else {
+			m["data_base64"] = base64.StdEncoding.EncodeToString(d)
+		}
+	default:
+		m["data"] = d
+	}
+
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes an event of spec version 1.0 or 0.3.
+// Attributes which are not part of the spec are kept as extensions.
+func (e *CloudEvent) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+
+	*e = CloudEvent{}
+
+	str := func(name string, dst *string) error {
+		v, ok := raw[name]
+		delete(raw, name)
+		if !ok {
+			return nil
+		}
+
+		err := json.Unmarshal(v, dst)
+		if err != nil {
+			return fmt.Errorf("invalid %s (%v)", name, err)
+		}
+		return nil
+	}
+
+	var t, schemaURL, encoding string
+	for name, dst := range map[string]*string{
+		"id":                  &e.ID,
+		"source":              &e.Source,
+		"specversion":         &e.Specversion,
+		"type":                &e.Type,
+		"time":                &t,
+		"datacontenttype":     &e.DataContentType,
+		"dataschema":          &e.DataSchema,
+		"subject":             &e.Subject,
+		"schemaurl":           &schemaURL,
+		"datacontentencoding": &encoding,
+	} {
+		err = str(name, dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t != "" {
+		e.Time, err = time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return fmt.Errorf("invalid time (%v)", err)
+		}
+	}
+
+	if e.DataSchema == "" {
+		e.DataSchema = schemaURL
+	}
+
+	// Data is either base64 encoded binary or any JSON value
+	var b64 string
+	err = str("data_base64", &b64)
+	if err != nil {
+		return err
+	}
+
+	data, ok := raw["data"]
+	delete(raw, "data")
+
+	switch {
+	case b64 != "":
+		e.Data, err = base64.StdEncoding.DecodeString(b64)
+	case ok && encoding == "base64":
+		err = json.Unmarshal(data, &b64)
+		if err == nil {
+			e.Data, err = base64.StdEncoding.DecodeString(b64)
+		}
+	case ok:
+		err = json.Unmarshal(data, &e.Data)
 	}
+	if err != nil {
+		return fmt.Errorf("invalid data (%v)", err)
+	}
+
+	// Everything left is an extension attribute
+	for name, v := range raw {
+		var ext interface{}
+		err = json.Unmarshal(v, &ext)
+		if err != nil {
+			return fmt.Errorf("invalid extension %s (%v)", name, err)
+		}
+
+		if ext == nil {
+			continue
+		}
+
+		if e.extensions == nil {
+			e.extensions = make(map[string]interface{})
+		}
+		e.extensions[name] = normalizeJSONExtension(ext)
+	}
+
+	return nil
 }
 
 // DataTo turns the Data field into the passed Type
 func (e *CloudEvent) DataTo(obj interface{}) error {
-	pb, err := json.Marshal(e.Data)
+	pb, err := e.dataBytes()
 	if err != nil {
 		return err
 	}
@@ -60,12 +264,22 @@ func (e *CloudEvent) DataTo(obj interface{}) error {
 	return json.Unmarshal(pb, obj)
 }
 
+// dataBytes returns Data as JSON. Binary data is expected to be JSON already.
+func (e *CloudEvent) dataBytes() ([]byte, error) {
+	if b, ok := e.Data.([]byte); ok {
+		return b, nil
+	}
+
+	return json.Marshal(e.Data)
+}
+
 // GetDataAt returns the json object at the specific path
 // Check https://github.com/tidwall/gjson for syntax
 func (e *CloudEvent) GetDataAt(path string) gjson.Result {
-	b, err := json.Marshal(e.Data)
+	b, err := e.dataBytes()
 	if err != nil {
-		log.Fatalln("Failed to Marshal interface:", err)
+		logging.Default.Critical("Failed to Marshal interface", logging.Fields{"error": err})
+		os.Exit(1)
 		return gjson.Result{}
 	}
 
@@ -78,7 +292,7 @@ func (e *CloudEvent) SetDataAt(path string, value interface{}) error {
 	var b []byte
 	var err error
 
-	b, err = json.Marshal(e.Data)
+	b, err = e.dataBytes()
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal (%v)", err)
 	}
@@ -0,0 +1,316 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readGolden returns the content of a file in testdata.
+func readGolden(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read golden file %s: %v", name, err)
+	}
+
+	return b
+}
+
+// assertJSONEqual fails unless got and want are the same JSON value.
+func assertJSONEqual(t *testing.T, got, want []byte) {
+	t.Helper()
+
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("got JSON\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCloudEventSpecExamples(t *testing.T) {
+	specTime := time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC)
+
+	tests := []struct {
+		golden     string
+		want       CloudEvent
+		extensions map[string]interface{}
+	}{
+		{
+			golden: "spec-v1.0-xml.json",
+			want: CloudEvent{
+				ID:              "A234-1234-1234",
+				Source:          "https://github.com/cloudevents/spec/pull",
+				Specversion:     SpecVersion10,
+				Type:            "com.github.pull_request.opened",
+				Time:            specTime,
+				DataContentType: "text/xml",
+				Subject:         "123",
+				Data:            `<much wow="xml"/>`,
+			},
+			extensions: map[string]interface{}{
+				"comexampleextension1": "value",
+				"comexampleothervalue": int32(5),
+			},
+		},
+		{
+			golden: "spec-v1.0-base64.json",
+			want: CloudEvent{
+				ID:              "A234-1234-1234",
+				Source:          "/mycontext",
+				Specversion:     SpecVersion10,
+				Type:            "com.example.someevent",
+				Time:            specTime,
+				DataContentType: "application/vnd.apache.thrift.binary",
+				Data:            []byte{0, 1, 2, 3, 4},
+			},
+			extensions: map[string]interface{}{
+				"comexampleextension1": "value",
+				"comexampleothervalue": int32(5),
+			},
+		},
+		{
+			golden: "spec-v1.0-json.json",
+			want: CloudEvent{
+				ID:              "C234-1234-1234",
+				Source:          "/mycontext",
+				Specversion:     SpecVersion10,
+				Type:            "com.example.someevent",
+				Time:            specTime,
+				DataContentType: "application/json",
+				DataSchema:      "https://example.com/schemas/someevent.json",
+				Data: map[string]interface{}{
+					"appinfoA": "abc",
+					"appinfoB": float64(123),
+					"appinfoC": true,
+				},
+			},
+			extensions: map[string]interface{}{
+				"comexampleextension1": "value",
+				"comexampleothervalue": int32(5),
+			},
+		},
+		{
+			golden: "spec-v0.3-base64.json",
+			want: CloudEvent{
+				ID:              "B234-1234-1234",
+				Source:          "/mycontext",
+				Specversion:     SpecVersion03,
+				Type:            "com.example.someevent",
+				Time:            specTime,
+				DataContentType: "application/vnd.apache.thrift.binary",
+				DataSchema:      "https://example.com/schemas/someevent.thrift",
+				Data:            []byte{0, 1, 2, 3, 4},
+			},
+			extensions: map[string]interface{}{
+				"comexampleextension1": "value",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			golden := readGolden(t, tt.golden)
+
+			var e CloudEvent
+			if err := json.Unmarshal(golden, &e); err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if err := e.Validate(); err != nil {
+				t.Errorf("Validate failed: %v", err)
+			}
+
+			want := tt.want
+			want.extensions = tt.extensions
+			if !reflect.DeepEqual(e, want) {
+				t.Errorf("Unmarshal got\n%#v\nwant\n%#v", e, want)
+			}
+
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			assertJSONEqual(t, b, golden)
+		})
+	}
+}
+
+func TestCloudEventMarshal(t *testing.T) {
+	link, _ := url.Parse("https://example.com/orders/42")
+	at := time.Date(2019, 8, 23, 19, 20, 14, 300000000, time.UTC)
+
+	tests := []struct {
+		name       string
+		event      CloudEvent
+		extensions map[string]interface{}
+		want       string
+	}{
+		{
+			name: "minimal",
+			event: CloudEvent{
+				ID:     "1",
+				Source: "orders",
+				Type:   "orders.placed",
+			},
+			want: `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed"}`,
+		},
+		{
+			name: "json data",
+			event: CloudEvent{
+				ID:              "1",
+				Source:          "orders",
+				Specversion:     SpecVersion10,
+				Type:            "orders.placed",
+				Time:            at,
+				DataContentType: "application/json",
+				Data:            map[string]int{"id": 42},
+			},
+			want: `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed",
+				"time":"2019-08-23T19:20:14.3Z","datacontenttype":"application/json","data":{"id":42}}`,
+		},
+		{
+			name: "raw json data",
+			event: CloudEvent{
+				ID:          "1",
+				Source:      "orders",
+				Specversion: SpecVersion10,
+				Type:        "orders.placed",
+				Data:        json.RawMessage(`[1,2,3]`),
+			},
+			want: `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed","data":[1,2,3]}`,
+		},
+		{
+			name: "binary data 1.0",
+			event: CloudEvent{
+				ID:          "1",
+				Source:      "orders",
+				Specversion: SpecVersion10,
+				Type:        "orders.placed",
+				DataSchema:  "https://example.com/schema",
+				Data:        []byte("hello"),
+			},
+			want: `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed",
+				"dataschema":"https://example.com/schema","data_base64":"aGVsbG8="}`,
+		},
+		{
+			name: "binary data 0.3",
+			event: CloudEvent{
+				ID:          "1",
+				Source:      "orders",
+				Specversion: SpecVersion03,
+				Type:        "orders.placed",
+				DataSchema:  "https://example.com/schema",
+				Data:        []byte("hello"),
+			},
+			want: `{"id":"1","source":"orders","specversion":"0.3","type":"orders.placed",
+				"schemaurl":"https://example.com/schema","datacontentencoding":"base64","data":"aGVsbG8="}`,
+		},
+		{
+			name: "extensions",
+			event: CloudEvent{
+				ID:          "1",
+				Source:      "orders",
+				Specversion: SpecVersion10,
+				Type:        "orders.placed",
+			},
+			extensions: map[string]interface{}{
+				"tenant":   "acme",
+				"priority": 5,
+				"urgent":   true,
+				"deadline": at,
+				"link":     link,
+				"sig":      []byte("hello"),
+			},
+			want: `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed",
+				"tenant":"acme","priority":5,"urgent":true,"deadline":"2019-08-23T19:20:14.3Z",
+				"link":"https://example.com/orders/42","sig":"aGVsbG8="}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.event
+			for name, v := range tt.extensions {
+				if err := e.SetExtension(name, v); err != nil {
+					t.Fatalf("SetExtension(%s) failed: %v", name, err)
+				}
+			}
+
+			b, err := json.Marshal(e)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			assertJSONEqual(t, b, []byte(tt.want))
+		})
+	}
+}
+
+func TestCloudEventExtensionRoundTrip(t *testing.T) {
+	at := time.Date(2019, 8, 23, 19, 20, 14, 0, time.UTC)
+
+	e := CloudEvent{ID: "1", Source: "orders", Specversion: SpecVersion10, Type: "orders.placed"}
+	for name, v := range map[string]interface{}{
+		"tenant":   "acme",
+		"priority": int32(-7),
+		"urgent":   true,
+		"deadline": at,
+	} {
+		if err := e.SetExtension(name, v); err != nil {
+			t.Fatalf("SetExtension(%s) failed: %v", name, err)
+		}
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var got CloudEvent
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if v, _ := got.ExtensionString("tenant"); v != "acme" {
+		t.Errorf("tenant = %q, want acme", v)
+	}
+	if v, err := got.ExtensionInt("priority"); err != nil || v != -7 {
+		t.Errorf("priority = %v, %v, want -7", v, err)
+	}
+	if v, err := got.ExtensionBool("urgent"); err != nil || !v {
+		t.Errorf("urgent = %v, %v, want true", v, err)
+	}
+	if v, err := got.ExtensionTime("deadline"); err != nil || !v.Equal(at) {
+		t.Errorf("deadline = %v, %v, want %v", v, err, at)
+	}
+}
+
+func TestCloudEventUnmarshalErrors(t *testing.T) {
+	tests := map[string]string{
+		"not an object":  `[]`,
+		"invalid id":     `{"id":1,"source":"orders","specversion":"1.0","type":"orders.placed"}`,
+		"invalid time":   `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed","time":"yesterday"}`,
+		"invalid base64": `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed","data_base64":"%%%"}`,
+		"invalid 0.3 base64": `{"id":"1","source":"orders","specversion":"0.3","type":"orders.placed",
+			"datacontentencoding":"base64","data":"%%%"}`,
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			var e CloudEvent
+			if err := json.Unmarshal([]byte(raw), &e); err == nil {
+				t.Errorf("Unmarshal(%s) succeeded", raw)
+			}
+		})
+	}
+}
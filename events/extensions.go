@@ -0,0 +1,182 @@
+package events
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Context attributes defined by the spec. They can't be used as extension names.
+var reservedAttributes = map[string]bool{
+	"id":                  true,
+	"source":              true,
+	"specversion":         true,
+	"type":                true,
+	"time":                true,
+	"datacontenttype":     true,
+	"dataschema":          true,
+	"subject":             true,
+	"data":                true,
+	"data_base64":         true,
+	"schemaurl":           true,
+	"datacontentencoding": true,
+}
+
+// SetExtension sets the extension attribute name to value.
+//
+// Names must consist of lower-case letters and digits only. Supported values are
+// the CloudEvents types: string, bool, int32 (int is accepted if in range),
+// time.Time, *url.URL and []byte. Setting a nil value removes the extension.
+func (e *CloudEvent) SetExtension(name string, value interface{}) error {
+	err := validateExtensionName(name)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		delete(e.extensions, name)
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string, bool, int32, time.Time, *url.URL, []byte:
+	case int:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return fmt.Errorf("extension %s: integer %d out of range", name, v)
+		}
+		value = int32(v)
+	default:
+		return fmt.Errorf("extension %s: unsupported type %T", name, value)
+	}
+
+	if e.extensions == nil {
+		e.extensions = make(map[string]interface{})
+	}
+	e.extensions[name] = value
+
+	return nil
+}
+
+// Extension returns the value of the extension attribute name.
+func (e *CloudEvent) Extension(name string) (interface{}, bool) {
+	v, ok := e.extensions[name]
+	return v, ok
+}
+
+// Extensions returns a copy of all extension attributes.
+func (e *CloudEvent) Extensions() map[string]interface{} {
+	c := make(map[string]interface{}, len(e.extensions))
+	for k, v := range e.extensions {
+		c[k] = v
+	}
+
+	return c
+}
+
+// ExtensionString returns the extension attribute name in its canonical string form.
+func (e *CloudEvent) ExtensionString(name string) (string, bool) {
+	v, ok := e.extensions[name]
+	if !ok {
+		return "", false
+	}
+
+	return formatExtension(v), true
+}
+
+// ExtensionBool returns the extension attribute name as bool.
+// String values, as received in binary mode, are parsed.
+func (e *CloudEvent) ExtensionBool(name string) (bool, error) {
+	switch v := e.extensions[name].(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	case nil:
+		return false, fmt.Errorf("extension %s not set", name)
+	default:
+		return false, fmt.Errorf("extension %s is a %T", name, v)
+	}
+}
+
+// ExtensionInt returns the extension attribute name as integer.
+// String values, as received in binary mode, are parsed.
+func (e *CloudEvent) ExtensionInt(name string) (int32, error) {
+	switch v := e.extensions[name].(type) {
+	case int32:
+		return v, nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 32)
+		return int32(i), err
+	case nil:
+		return 0, fmt.Errorf("extension %s not set", name)
+	default:
+		return 0, fmt.Errorf("extension %s is a %T", name, v)
+	}
+}
+
+// ExtensionTime returns the extension attribute name as time.
+// String values, as received in binary mode, are parsed.
+func (e *CloudEvent) ExtensionTime(name string) (time.Time, error) {
+	switch v := e.extensions[name].(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case nil:
+		return time.Time{}, fmt.Errorf("extension %s not set", name)
+	default:
+		return time.Time{}, fmt.Errorf("extension %s is a %T", name, v)
+	}
+}
+
+func validateExtensionName(name string) error {
+	if name == "" {
+		return errors.New("extension name must not be empty")
+	}
+
+	if reservedAttributes[name] {
+		return fmt.Errorf("%s is a reserved attribute", name)
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return fmt.Errorf("invalid extension name %q, only lower-case letters and digits are allowed", name)
+		}
+	}
+
+	return nil
+}
+
+// formatExtension returns the canonical string representation of an extension value.
+func formatExtension(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case int32:
+		return strconv.FormatInt(int64(t), 10)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case *url.URL:
+		return t.String()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// normalizeJSONExtension turns JSON decoded numbers into int32 where possible.
+func normalizeJSONExtension(v interface{}) interface{} {
+	f, ok := v.(float64)
+	if ok && f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+		return int32(f)
+	}
+
+	return v
+}
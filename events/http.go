@@ -0,0 +1,231 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/helloink/surfkit/tracing"
+	"github.com/helloink/surfkit/transport"
+)
+
+// BatchContentType is the content type of a batch of events in structured mode.
+const BatchContentType = "application/cloudevents-batch+json"
+
+// ErrNotCloudEvent is returned by ReadHTTP if a request doesn't carry CloudEvents.
+var ErrNotCloudEvent = errors.New("request holds no CloudEvent")
+
+// ReadHTTP returns the CloudEvents sent with an HTTP request. It understands the structured,
+// binary and batch modes of the CloudEvents HTTP binding. It fails unless every event has
+// the required attributes.
+// See https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md
+func ReadHTTP(r *http.Request) ([]*CloudEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body (%v)", err)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	mt, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mt == BatchContentType:
+		var evs []*CloudEvent
+		err = json.Unmarshal(body, &evs)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, e := range evs {
+			if e == nil {
+				return nil, ErrNotCloudEvent
+			}
+
+			err = e.Validate()
+			if err != nil {
+				return nil, fmt.Errorf("invalid event %d (%v)", i, err)
+			}
+		}
+		return evs, nil
+
+	case mt == StructuredContentType:
+		var e *CloudEvent
+		err = json.Unmarshal(body, &e)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return nil, ErrNotCloudEvent
+		}
+
+		err = e.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid event (%v)", err)
+		}
+		return []*CloudEvent{e}, nil
+
+	case r.Header.Get("Ce-Specversion") != "":
+		attrs := make(map[string]string)
+		for k, vs := range r.Header {
+			name := strings.ToLower(k)
+			if !strings.HasPrefix(name, attributePrefix) || len(vs) == 0 {
+				continue
+			}
+
+			v, err := url.PathUnescape(vs[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %s (%v)", k, err)
+			}
+			attrs[name] = v
+		}
+
+		e, err := decodeBinary(body, attrs, contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		err = e.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid event (%v)", err)
+		}
+		return []*CloudEvent{e}, nil
+	}
+
+	return nil, ErrNotCloudEvent
+}
+
+// NewHTTPRequest prepares a POST request carrying e to url in the given content mode.
+func NewHTTPRequest(url string, e CloudEvent, mode ContentMode) (*http.Request, error) {
+	m, err := EncodeMessage(e, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHTTPRequest(http.NewRequest, url, m)
+}
+
+// An HTTPSender delivers events to a webhook using the CloudEvents HTTP binding.
+// It implements transport.Sender so it can back a Publisher, topics are ignored.
+type HTTPSender struct {
+
+	// URL every event is posted to.
+	URL string
+
+	// Client executes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// NewRequest creates the requests, e.g. to authenticate them.
+	// Defaults to http.NewRequest.
+	NewRequest func(method, url string, body io.Reader) (*http.Request, error)
+
+	pending sync.WaitGroup
+}
+
+// Send posts e to the webhook in the given content mode and waits for the response.
+func (h *HTTPSender) Send(ctx context.Context, e CloudEvent, mode ContentMode) error {
+	m, err := EncodeMessage(e, mode)
+	if err != nil {
+		return err
+	}
+
+	return h.post(ctx, m)
+}
+
+// EnsureTopic is a noop.
+func (h *HTTPSender) EnsureTopic(ctx context.Context, topic string) error {
+	return nil
+}
+
+// Publish posts m to the webhook in the background. The result never holds a message ID.
+func (h *HTTPSender) Publish(ctx context.Context, topic string, m *transport.Message) transport.PublishResult {
+	r := transport.NewResult()
+
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		r.Set("", h.post(ctx, m))
+	}()
+
+	return r
+}
+
+// Flush waits for all pending deliveries.
+func (h *HTTPSender) Flush(topic string) {
+	h.pending.Wait()
+}
+
+func (h *HTTPSender) post(ctx context.Context, m *transport.Message) error {
+	newRequest := h.NewRequest
+	if newRequest == nil {
+		newRequest = http.NewRequest
+	}
+
+	req, err := newHTTPRequest(newRequest, h.URL, m)
+	if err != nil {
+		return err
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %d", h.URL, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// newHTTPRequest maps an encoded message onto an HTTP request. Message attributes
+// become headers, which makes binary mode messages valid binary mode requests.
+func newHTTPRequest(newRequest func(string, string, io.Reader) (*http.Request, error), url string, m *transport.Message) (*http.Request, error) {
+	req, err := newRequest(http.MethodPost, url, bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range m.Attributes {
+		if k == contentTypeAttribute {
+			req.Header.Set("Content-Type", v)
+			continue
+		}
+		if strings.HasPrefix(k, attributePrefix) {
+			req.Header.Set(k, encodeHeaderValue(v))
+		}
+	}
+
+	return req, nil
+}
+
+// encodeHeaderValue percent-encodes everything but printable ASCII, as required by the binding.
+func encodeHeaderValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
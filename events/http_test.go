@@ -0,0 +1,77 @@
+package events
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadHTTP(t *testing.T) {
+	valid := `{"id":"1","source":"orders","specversion":"1.0","type":"orders.placed"}`
+
+	tests := []struct {
+		name        string
+		contentType string
+		headers     map[string]string
+		body        string
+		want        int
+		wantErr     bool
+	}{
+		{name: "structured", contentType: StructuredContentType, body: valid, want: 1},
+		{name: "structured null", contentType: StructuredContentType, body: `null`, wantErr: true},
+		{name: "structured empty", contentType: StructuredContentType, body: `{}`, wantErr: true},
+		{name: "batch", contentType: BatchContentType, body: "[" + valid + "," + valid + "]", want: 2},
+		{name: "empty batch", contentType: BatchContentType, body: `[]`, want: 0},
+		{name: "batch null", contentType: BatchContentType, body: `[null]`, wantErr: true},
+		{name: "batch empty event", contentType: BatchContentType, body: `[{}]`, wantErr: true},
+		{name: "batch one invalid", contentType: BatchContentType, body: "[" + valid + `,{"id":"2"}]`, wantErr: true},
+		{
+			name:        "binary",
+			contentType: "application/json",
+			headers:     map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "1", "Ce-Source": "orders", "Ce-Type": "orders.placed"},
+			body:        `{"id":"42"}`,
+			want:        1,
+		},
+		{
+			name:        "binary missing id",
+			contentType: "application/json",
+			headers:     map[string]string{"Ce-Specversion": "1.0", "Ce-Source": "orders", "Ce-Type": "orders.placed"},
+			body:        `{"id":"42"}`,
+			wantErr:     true,
+		},
+		{name: "no event", contentType: "application/json", body: valid, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			evs, err := ReadHTTP(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadHTTP succeeded with %d events", len(evs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadHTTP failed: %v", err)
+			}
+			if len(evs) != tt.want {
+				t.Errorf("got %d events, want %d", len(evs), tt.want)
+			}
+		})
+	}
+}
+
+func TestReadHTTPNullInBatch(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`[null]`))
+	r.Header.Set("Content-Type", BatchContentType)
+
+	if _, err := ReadHTTP(r); err != ErrNotCloudEvent {
+		t.Errorf("ReadHTTP error = %v, want ErrNotCloudEvent", err)
+	}
+}
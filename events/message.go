@@ -0,0 +1,217 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/helloink/surfkit/transport"
+)
+
+// A ContentMode defines how a CloudEvent is mapped onto a message.
+// See https://github.com/google/knative-gcp/blob/master/docs/spec/pubsub-protocol-binding.md
+type ContentMode int
+
+const (
+	// StructuredMode puts the whole event, encoded as JSON, into the message data.
+	StructuredMode ContentMode = iota
+
+	// BinaryMode puts the event's attributes into `ce-` prefixed message attributes
+	// and its data, as is, into the message data.
+	BinaryMode
+)
+
+// StructuredContentType is the content type of events in structured mode.
+const StructuredContentType = "application/cloudevents+json"
+
+const (
+	attributePrefix      = "ce-"
+	contentTypeAttribute = "content-type"
+)
+
+// EncodeMessage turns e into a message using the given content mode.
+func EncodeMessage(e CloudEvent, mode ContentMode) (*transport.Message, error) {
+	if mode == BinaryMode {
+		data, attrs, err := encodeBinary(e)
+		if err != nil {
+			return nil, err
+		}
+
+		return &transport.Message{Data: data, Attributes: attrs}, nil
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport.Message{
+		Data:       data,
+		Attributes: map[string]string{contentTypeAttribute: StructuredContentType},
+	}, nil
+}
+
+// DecodeMessage returns the CloudEvent carried by a message. It detects whether the
+// message is in structured or binary mode.
+func DecodeMessage(data []byte, attributes map[string]string) (*CloudEvent, error) {
+	if _, ok := attributes[attributePrefix+"specversion"]; ok {
+		return decodeBinary(data, attributes, attributes[contentTypeAttribute])
+	}
+
+	var e *CloudEvent
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	if e == nil {
+		return nil, fmt.Errorf("message holds no event")
+	}
+
+	return e, nil
+}
+
+// encodeBinary returns the data and the `ce-` prefixed attributes of e.
+// The content type is returned as `content-type` attribute.
+func encodeBinary(e CloudEvent) ([]byte, map[string]string, error) {
+	version := e.Specversion
+	if version == "" {
+		version = specVersion
+	}
+
+	attrs := map[string]string{
+		attributePrefix + "id":          e.ID,
+		attributePrefix + "source":      e.Source,
+		attributePrefix + "specversion": version,
+		attributePrefix + "type":        e.Type,
+	}
+
+	if !e.Time.IsZero() {
+		attrs[attributePrefix+"time"] = e.Time.Format(time.RFC3339Nano)
+	}
+	if e.Subject != "" {
+		attrs[attributePrefix+"subject"] = e.Subject
+	}
+	if e.DataSchema != "" {
+		if version == SpecVersion03 {
+			attrs[attributePrefix+"schemaurl"] = e.DataSchema
+		} else {
+			attrs[attributePrefix+"dataschema"] = e.DataSchema
+		}
+	}
+	if e.DataContentType != "" {
+		attrs[contentTypeAttribute] = e.DataContentType
+	}
+
+	for name, v := range e.extensions {
+		attrs[attributePrefix+name] = formatExtension(v)
+	}
+
+	var data []byte
+	var err error
+
+	switch d := e.Data.(type) {
+	case nil:
+	case json.RawMessage:
+		data = d
+	case []byte:
+		data = d
+	case string:
+		if isJSON(e.DataContentType) {
+			data, err = json.Marshal(d)
+		} else {
+			data = []byte(d)
+		}
+	default:
+		data, err = json.Marshal(d)
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal data (%v)", err)
+	}
+
+	return data, attrs, nil
+}
+
+// decodeBinary builds an event from its data and `ce-` prefixed attributes.
+func decodeBinary(data []byte, attributes map[string]string, contentType string) (*CloudEvent, error) {
+	e := &CloudEvent{DataContentType: contentType}
+
+	var schemaURL string
+	for k, v := range attributes {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, attributePrefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, attributePrefix)
+
+		switch name {
+		case "id":
+			e.ID = v
+		case "source":
+			e.Source = v
+		case "specversion":
+			e.Specversion = v
+		case "type":
+			e.Type = v
+		case "subject":
+			e.Subject = v
+		case "dataschema":
+			e.DataSchema = v
+		case "schemaurl":
+			schemaURL = v
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid time (%v)", err)
+			}
+			e.Time = t
+		default:
+			if e.extensions == nil {
+				e.extensions = make(map[string]interface{})
+			}
+			e.extensions[name] = v
+		}
+	}
+
+	if e.DataSchema == "" {
+		e.DataSchema = schemaURL
+	}
+
+	if len(data) == 0 {
+		return e, nil
+	}
+
+	// JSON data is decoded so it looks the same as data received in structured mode.
+	// Without a content type, data which isn't JSON is kept as is.
+	if isJSON(contentType) {
+		var v interface{}
+		err := json.Unmarshal(data, &v)
+		if err == nil {
+			e.Data = v
+			return e, nil
+		}
+		if contentType != "" {
+			return nil, fmt.Errorf("invalid data (%v)", err)
+		}
+	}
+
+	e.Data = data
+	return e, nil
+}
+
+// isJSON reports whether contentType denotes JSON. An empty content type is considered JSON.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
@@ -0,0 +1,266 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodeMessageBinary(t *testing.T) {
+	e := CloudEvent{
+		ID:              "A234-1234-1234",
+		Source:          "/mycontext",
+		Specversion:     SpecVersion10,
+		Type:            "com.example.someevent",
+		Time:            time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC),
+		DataContentType: "application/json",
+		DataSchema:      "https://example.com/schemas/someevent.json",
+		Subject:         "123",
+		Data:            map[string]string{"appinfoA": "abc"},
+	}
+	e.SetExtension("comexampleextension1", "value")
+	e.SetExtension("comexampleothervalue", 5)
+
+	m, err := EncodeMessage(e, BinaryMode)
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+
+	wantAttrs := map[string]string{
+		"ce-id":                   "A234-1234-1234",
+		"ce-source":               "/mycontext",
+		"ce-specversion":          "1.0",
+		"ce-type":                 "com.example.someevent",
+		"ce-time":                 "2018-04-05T17:31:00Z",
+		"ce-dataschema":           "https://example.com/schemas/someevent.json",
+		"ce-subject":              "123",
+		"ce-comexampleextension1": "value",
+		"ce-comexampleothervalue": "5",
+		"content-type":            "application/json",
+	}
+	if !reflect.DeepEqual(m.Attributes, wantAttrs) {
+		t.Errorf("attributes = %v, want %v", m.Attributes, wantAttrs)
+	}
+	assertJSONEqual(t, m.Data, []byte(`{"appinfoA":"abc"}`))
+}
+
+func TestEncodeMessageBinaryData(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		contentType string
+		data        interface{}
+		want        string
+	}{
+		{"bytes", SpecVersion10, "application/octet-stream", []byte{0, 1, 2}, "\x00\x01\x02"},
+		{"raw json", SpecVersion10, "application/json", json.RawMessage(`{"a":1}`), `{"a":1}`},
+		{"text", SpecVersion10, "text/xml", `<much wow="xml"/>`, `<much wow="xml"/>`},
+		{"json string", SpecVersion10, "application/json", "hello", `"hello"`},
+		{"no data", SpecVersion10, "", nil, ""},
+		{"0.3", SpecVersion03, "text/plain", "hello", "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := CloudEvent{
+				ID:              "1",
+				Source:          "orders",
+				Specversion:     tt.version,
+				Type:            "orders.placed",
+				DataContentType: tt.contentType,
+				Data:            tt.data,
+			}
+
+			m, err := EncodeMessage(e, BinaryMode)
+			if err != nil {
+				t.Fatalf("EncodeMessage failed: %v", err)
+			}
+			if string(m.Data) != tt.want {
+				t.Errorf("data = %q, want %q", m.Data, tt.want)
+			}
+			if m.Attributes["ce-specversion"] != tt.version {
+				t.Errorf("ce-specversion = %q, want %q", m.Attributes["ce-specversion"], tt.version)
+			}
+		})
+	}
+}
+
+func TestEncodeMessageBinarySchemaURL(t *testing.T) {
+	e := CloudEvent{ID: "1", Source: "orders", Specversion: SpecVersion03, Type: "orders.placed", DataSchema: "https://example.com/schema"}
+
+	m, err := EncodeMessage(e, BinaryMode)
+	if err != nil {
+		t.Fatalf("EncodeMessage failed: %v", err)
+	}
+	if m.Attributes["ce-schemaurl"] != "https://example.com/schema" {
+		t.Errorf("ce-schemaurl = %q, want https://example.com/schema", m.Attributes["ce-schemaurl"])
+	}
+	if _, ok := m.Attributes["ce-dataschema"]; ok {
+		t.Error("0.3 event encoded with ce-dataschema")
+	}
+}
+
+func TestDecodeMessageBinary(t *testing.T) {
+	// Example of the Pub/Sub protocol binding
+	attrs := map[string]string{
+		"ce-specversion":          "1.0",
+		"ce-type":                 "com.example.someevent",
+		"ce-source":               "/mycontext/subcontext",
+		"ce-id":                   "1234-1234-1234",
+		"ce-time":                 "2018-04-05T03:56:24Z",
+		"ce-comexampleextension1": "value",
+		"content-type":            "application/json; charset=utf-8",
+	}
+	data := []byte(`{"appinfoA":"abc","appinfoB":123}`)
+
+	e, err := DecodeMessage(data, attrs)
+	if err != nil {
+		t.Fatalf("DecodeMessage failed: %v", err)
+	}
+
+	want := &CloudEvent{
+		ID:              "1234-1234-1234",
+		Source:          "/mycontext/subcontext",
+		Specversion:     SpecVersion10,
+		Type:            "com.example.someevent",
+		Time:            time.Date(2018, 4, 5, 3, 56, 24, 0, time.UTC),
+		DataContentType: "application/json; charset=utf-8",
+		Data:            map[string]interface{}{"appinfoA": "abc", "appinfoB": float64(123)},
+		extensions:      map[string]interface{}{"comexampleextension1": "value"},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("DecodeMessage got\n%#v\nwant\n%#v", e, want)
+	}
+}
+
+func TestDecodeMessageBinaryData(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        string
+		want        interface{}
+		wantErr     bool
+	}{
+		{"json", "application/json", `[1,2]`, []interface{}{float64(1), float64(2)}, false},
+		{"json suffix", "application/vnd.order+json", `{"id":"42"}`, map[string]interface{}{"id": "42"}, false},
+		{"invalid json", "application/json", `{`, nil, true},
+		{"text", "text/xml", `<much wow="xml"/>`, []byte(`<much wow="xml"/>`), false},
+		{"no content type, json", "", `"hello"`, "hello", false},
+		{"no content type, binary", "", "\x00\x01", []byte{0, 1}, false},
+		{"no data", "application/json", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string]string{"ce-specversion": "1.0", "ce-id": "1", "ce-source": "orders", "ce-type": "orders.placed"}
+			if tt.contentType != "" {
+				attrs["content-type"] = tt.contentType
+			}
+
+			e, err := DecodeMessage([]byte(tt.data), attrs)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("DecodeMessage succeeded with data %+v", e.Data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeMessage failed: %v", err)
+			}
+			if !reflect.DeepEqual(e.Data, tt.want) {
+				t.Errorf("data = %#v, want %#v", e.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	at := time.Date(2019, 8, 23, 19, 20, 14, 300000000, time.UTC)
+
+	tests := []struct {
+		name  string
+		event CloudEvent
+	}{
+		{
+			name: "1.0 json",
+			event: CloudEvent{
+				ID:              "1",
+				Source:          "orders",
+				Specversion:     SpecVersion10,
+				Type:            "orders.placed",
+				Time:            at,
+				DataContentType: "application/json",
+				DataSchema:      "https://example.com/schema",
+				Subject:         "42",
+				Data:            map[string]interface{}{"id": "42", "total": float64(12.5)},
+			},
+		},
+		{
+			name: "1.0 binary",
+			event: CloudEvent{
+				ID:              "1",
+				Source:          "orders",
+				Specversion:     SpecVersion10,
+				Type:            "orders.placed",
+				DataContentType: "application/octet-stream",
+				Data:            []byte{0, 1, 2},
+			},
+		},
+		{
+			name: "0.3 binary",
+			event: CloudEvent{
+				ID:              "1",
+				Source:          "orders",
+				Specversion:     SpecVersion03,
+				Type:            "orders.placed",
+				DataContentType: "application/octet-stream",
+				DataSchema:      "https://example.com/schema",
+				Data:            []byte{0, 1, 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for mode, name := range map[ContentMode]string{StructuredMode: "structured", BinaryMode: "binary"} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				e := tt.event
+				e.SetExtension("tenant", "acme")
+
+				m, err := EncodeMessage(e, mode)
+				if err != nil {
+					t.Fatalf("EncodeMessage failed: %v", err)
+				}
+				if mode == StructuredMode && m.Attributes["content-type"] != StructuredContentType {
+					t.Errorf("content-type = %q, want %s", m.Attributes["content-type"], StructuredContentType)
+				}
+
+				got, err := DecodeMessage(m.Data, m.Attributes)
+				if err != nil {
+					t.Fatalf("DecodeMessage failed: %v", err)
+				}
+				if !reflect.DeepEqual(*got, e) {
+					t.Errorf("round trip got\n%#v\nwant\n%#v", *got, e)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeMessageStructured(t *testing.T) {
+	golden := readGolden(t, "spec-v1.0-xml.json")
+
+	e, err := DecodeMessage(golden, map[string]string{"content-type": StructuredContentType})
+	if err != nil {
+		t.Fatalf("DecodeMessage failed: %v", err)
+	}
+	if e.ID != "A234-1234-1234" || e.Data != `<much wow="xml"/>` {
+		t.Errorf("DecodeMessage got %#v", e)
+	}
+
+	for _, data := range []string{`null`, `{`} {
+		if _, err := DecodeMessage([]byte(data), nil); err == nil {
+			t.Errorf("DecodeMessage(%s) succeeded", data)
+		}
+	}
+}
@@ -2,10 +2,11 @@ package events
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"sync"
 
-	"cloud.google.com/go/pubsub"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
 )
 
 // A Publisher is used to send event messages to a specific topic
@@ -13,9 +14,26 @@ type Publisher struct {
 	ProjectID string
 	Topic     string
 
-	client *pubsub.Client
-	ctx    context.Context
-	topic  *pubsub.Topic
+	// Transport the events are sent with. If not set, Setup connects
+	// to Google Cloud Pubsub in ProjectID.
+	Transport transport.Sender
+
+	// SpecVersion the events are sent with, e.g. SpecVersion03.
+	// Defaults to the version of each event.
+	SpecVersion string
+
+	// ContentMode the events are sent in. Defaults to StructuredMode.
+	ContentMode ContentMode
+
+	// Logger failed publishes are logged with. Defaults to logging.Default.
+	Logger *logging.Logger
+
+	ctx context.Context
+
+	// pending tracks asynchronous publishes until their result is known.
+	pending  sync.WaitGroup
+	mu       sync.Mutex
+	failures []error
 }
 
 // NewPublisher provides an initialised Publisher
@@ -30,47 +48,116 @@ func NewPublisher(projectID string, topic string) *Publisher {
 func (p *Publisher) Setup() error {
 	p.ctx = context.Background()
 
-	client, err := pubsub.NewClient(p.ctx, p.ProjectID)
-	if err != nil {
-		return fmt.Errorf("failed to setup pubsub client (%v)", err)
-	}
-
-	topic := client.Topic(p.Topic)
-	ok, err := topic.Exists(p.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to verify topic (%v)", err)
-	}
-
-	if !ok {
-		topic, err = client.CreateTopic(p.ctx, p.Topic)
+	if p.Transport == nil {
+		t, err := transport.NewPubsub(p.ctx, p.ProjectID)
 		if err != nil {
-			return fmt.Errorf("failed to create topic (%v)", err)
+			return fmt.Errorf("failed to setup pubsub client (%v)", err)
 		}
+		p.Transport = t
 	}
 
-	p.client = client
-	p.topic = topic
-
-	return nil
+	return p.Transport.EnsureTopic(p.ctx, p.Topic)
 }
 
 // Stop makes sure all messages are delivered before returning.
 // Use it before existing the programm.
-func (p *Publisher) Stop() {
-	if p.topic != nil {
-		p.topic.Stop()
+//
+// It reports all asynchronous publishes, see Send and PublishAsync, which failed
+// since the last call to Stop.
+func (p *Publisher) Stop() error {
+	if p.Transport != nil {
+		p.Transport.Flush(p.Topic)
+	}
+
+	p.pending.Wait()
+
+	p.mu.Lock()
+	failures := p.failures
+	p.failures = nil
+	p.mu.Unlock()
+
+	if len(failures) == 0 {
+		return nil
 	}
+
+	return fmt.Errorf("%d event(s) failed to publish to %s, first error (%v)", len(failures), p.Topic, failures[0])
 }
 
-// Send a CloudEvent messages to Pubsub
+// Send a CloudEvent messages to Pubsub without waiting for the outcome.
+// Failures are logged and reported by Stop.
 func (p *Publisher) Send(e CloudEvent) error {
 
-	pb, err := json.Marshal(e)
+	m, err := p.Encode(e)
 	if err != nil {
 		return err
 	}
 
-	p.topic.Publish(p.ctx, &pubsub.Message{Data: pb})
+	p.track(p.Transport.Publish(p.ctx, p.Topic, m))
 
 	return nil
 }
+
+// Publish sends a CloudEvent and blocks until it is published or ctx is done.
+// It returns the message ID assigned by the server.
+func (p *Publisher) Publish(ctx context.Context, e CloudEvent) (string, error) {
+	m, err := p.Encode(e)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Transport.Publish(ctx, p.Topic, m).Get(ctx)
+}
+
+// PublishAsync sends a CloudEvent without blocking. Use the returned result to wait for
+// the outcome. Failures are reported by Stop as well.
+func (p *Publisher) PublishAsync(ctx context.Context, e CloudEvent) transport.PublishResult {
+	m, err := p.Encode(e)
+	if err != nil {
+		r := transport.NewResult()
+		r.Set("", err)
+		return r
+	}
+
+	r := p.Transport.Publish(ctx, p.Topic, m)
+	p.track(r)
+
+	return r
+}
+
+// Encode turns e into a message using the Publisher's spec version and content mode.
+func (p *Publisher) Encode(e CloudEvent) (*transport.Message, error) {
+	if p.SpecVersion != "" {
+		e.Specversion = p.SpecVersion
+	}
+
+	return EncodeMessage(e, p.ContentMode)
+}
+
+// track records the outcome of r once it is ready.
+func (p *Publisher) track(r transport.PublishResult) {
+	p.pending.Add(1)
+
+	go func() {
+		defer p.pending.Done()
+
+		<-r.Ready()
+		_, err := r.Get(context.Background())
+		if err == nil {
+			return
+		}
+
+		p.logger().Error(fmt.Sprintf("Failed to publish event to %s", p.Topic), logging.Fields{"topic": p.Topic, "error": err})
+
+		p.mu.Lock()
+		p.failures = append(p.failures, err)
+		p.mu.Unlock()
+	}()
+}
+
+func (p *Publisher) logger() *logging.Logger {
+	if p.Logger == nil {
+		return logging.Default
+	}
+
+	return p.Logger
+}
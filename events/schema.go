@@ -0,0 +1,363 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Schema validates the data of CloudEvents. It understands the commonly used subset
+// of JSON Schema: type, enum, const, properties, required, additionalProperties, items,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern,
+// minItems, maxItems, allOf, anyOf, oneOf and not. Schemas using other keywords, e.g.
+// $ref or format, are refused rather than validated partially. Annotations like title
+// or description are allowed. See https://json-schema.org/
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Const                *interface{}       `json:"const"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+	Not                  *Schema            `json:"not"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaKeywords are the keywords a Schema may use.
+var schemaKeywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "properties": true, "required": true,
+	"additionalProperties": true, "items": true, "minimum": true, "maximum": true,
+	"exclusiveMinimum": true, "exclusiveMaximum": true, "minLength": true, "maxLength": true,
+	"pattern": true, "minItems": true, "maxItems": true, "allOf": true, "anyOf": true,
+	"oneOf": true, "not": true,
+
+	// Annotations which don't affect validation
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+// UnmarshalJSON reads a schema, refusing keywords it doesn't implement.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	var keywords map[string]json.RawMessage
+	err := json.Unmarshal(b, &keywords)
+	if err != nil {
+		return err
+	}
+
+	var unsupported []string
+	for k := range keywords {
+		if !schemaKeywords[k] {
+			unsupported = append(unsupported, k)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("unsupported keywords %s", strings.Join(unsupported, ", "))
+	}
+
+	type plain Schema
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+// schemaTypes holds the value of `type`, which is either a single type or a list of types.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var list []string
+	err := json.Unmarshal(b, &list)
+	if err != nil {
+		return err
+	}
+
+	*t = list
+	return nil
+}
+
+// ParseSchema reads a JSON Schema document.
+func ParseSchema(b []byte) (*Schema, error) {
+	var s Schema
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema (%v)", err)
+	}
+
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// ValidateData checks the data of e against s.
+func (s *Schema) ValidateData(e *CloudEvent) error {
+	var v interface{}
+	err := e.DataTo(&v)
+	if err != nil {
+		return fmt.Errorf("data is not JSON (%v)", err)
+	}
+
+	return s.Validate(v)
+}
+
+// Validate checks v, as decoded by encoding/json, against s.
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate("data", v)
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern (%v)", err)
+		}
+		s.pattern = re
+	}
+
+	var children []*Schema
+	for _, c := range s.Properties {
+		children = append(children, c)
+	}
+	children = append(children, s.Items, s.Not)
+	children = append(children, s.AllOf...)
+	children = append(children, s.AnyOf...)
+	children = append(children, s.OneOf...)
+
+	for _, c := range children {
+		if c == nil {
+			continue
+		}
+
+		err := c.compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if len(s.Type) > 0 && !s.hasType(v) {
+		return fmt.Errorf("%s must be of type %s", path, strings.Join(s.Type, " or "))
+	}
+
+	if s.Enum != nil {
+		found := false
+		for _, option := range s.Enum {
+			if reflect.DeepEqual(option, v) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, s.Enum)
+		}
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(*s.Const, v) {
+		return fmt.Errorf("%s must be %v", path, *s.Const)
+	}
+
+	var err error
+	switch t := v.(type) {
+	case map[string]interface{}:
+		err = s.validateObject(path, t)
+	case []interface{}:
+		err = s.validateArray(path, t)
+	case string:
+		err = s.validateString(path, t)
+	case float64:
+		err = s.validateNumber(path, t)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.validateCombinations(path, v)
+}
+
+func (s *Schema) validateObject(path string, o map[string]interface{}) error {
+	for _, name := range s.Required {
+		if _, ok := o[name]; !ok {
+			return fmt.Errorf("%s.%s is required", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			}
+			continue
+		}
+
+		err := p.validate(path+"."+name, o[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateArray(path string, a []interface{}) error {
+	if s.MinItems != nil && len(a) < *s.MinItems {
+		return fmt.Errorf("%s must have at least %d items", path, *s.MinItems)
+	}
+	if s.MaxItems != nil && len(a) > *s.MaxItems {
+		return fmt.Errorf("%s must have at most %d items", path, *s.MaxItems)
+	}
+
+	if s.Items == nil {
+		return nil
+	}
+
+	for i, item := range a {
+		err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateString(path, str string) error {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		return fmt.Errorf("%s must be at least %d characters long", path, *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return fmt.Errorf("%s must be at most %d characters long", path, *s.MaxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return fmt.Errorf("%s must match %s", path, s.Pattern)
+	}
+
+	return nil
+}
+
+func (s *Schema) validateNumber(path string, n float64) error {
+	if s.Minimum != nil && n < *s.Minimum {
+		return fmt.Errorf("%s must be at least %v", path, *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		return fmt.Errorf("%s must be at most %v", path, *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		return fmt.Errorf("%s must be greater than %v", path, *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		return fmt.Errorf("%s must be less than %v", path, *s.ExclusiveMaximum)
+	}
+
+	return nil
+}
+
+func (s *Schema) validateCombinations(path string, v interface{}) error {
+	for _, sub := range s.AllOf {
+		err := sub.validate(path, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.validate(path, v) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s matches none of the allowed schemas", path)
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.validate(path, v) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s must match exactly one schema, matches %d", path, matches)
+		}
+	}
+
+	if s.Not != nil && s.Not.validate(path, v) == nil {
+		return fmt.Errorf("%s matches a forbidden schema", path)
+	}
+
+	return nil
+}
+
+func (s *Schema) hasType(v interface{}) bool {
+	for _, t := range s.Type {
+		switch t {
+		case "object":
+			if _, ok := v.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if n, ok := v.(float64); ok && n == float64(int64(n)) {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "null":
+			if v == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const orderSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title": "Order",
+	"type": "object",
+	"required": ["id", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "string", "pattern": "^o-[0-9]+$"},
+		"status": {"enum": ["open", "paid"]},
+		"version": {"const": 1},
+		"note": {"type": ["string", "null"], "minLength": 2, "maxLength": 5},
+		"total": {"type": "number", "minimum": 0, "exclusiveMaximum": 1000},
+		"discount": {"type": "number", "exclusiveMinimum": 0, "maximum": 50},
+		"items": {
+			"type": "array",
+			"minItems": 1,
+			"maxItems": 2,
+			"items": {"type": "object", "required": ["sku"], "properties": {"quantity": {"type": "integer"}}}
+		},
+		"contact": {
+			"anyOf": [
+				{"type": "object", "required": ["email"]},
+				{"type": "object", "required": ["phone"]}
+			]
+		},
+		"payment": {
+			"oneOf": [
+				{"type": "object", "required": ["card"]},
+				{"type": "object", "required": ["invoice"]}
+			]
+		},
+		"code": {"allOf": [{"type": "string"}, {"maxLength": 3}], "not": {"const": "xxx"}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(orderSchema))
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", `{"id": "o-1", "items": [{"sku": "a", "quantity": 2}]}`, ""},
+		{"all set", `{"id": "o-1", "status": "paid", "version": 1, "note": null, "total": 0, "discount": 50, "items": [{"sku": "a"}, {"sku": "b"}], "contact": {"email": "a@example.com", "phone": "1"}, "payment": {"card": "x"}, "code": "abc"}`, ""},
+		{"no object", `[]`, "data must be of type object"},
+		{"missing property", `{"id": "o-1"}`, "data.items is required"},
+		{"additional property", `{"id": "o-1", "items": [{"sku": "a"}], "extra": 1}`, "data.extra is not allowed"},
+		{"pattern", `{"id": "order-1", "items": [{"sku": "a"}]}`, "data.id must match ^o-[0-9]+$"},
+		{"enum", `{"id": "o-1", "items": [{"sku": "a"}], "status": "closed"}`, "data.status must be one of"},
+		{"const", `{"id": "o-1", "items": [{"sku": "a"}], "version": 2}`, "data.version must be 1"},
+		{"type list", `{"id": "o-1", "items": [{"sku": "a"}], "note": 1}`, "data.note must be of type string or null"},
+		{"minLength", `{"id": "o-1", "items": [{"sku": "a"}], "note": "ä"}`, "at least 2 characters"},
+		{"maxLength counts runes", `{"id": "o-1", "items": [{"sku": "a"}], "note": "äöüäö"}`, ""},
+		{"maxLength", `{"id": "o-1", "items": [{"sku": "a"}], "note": "toolong"}`, "at most 5 characters"},
+		{"minimum", `{"id": "o-1", "items": [{"sku": "a"}], "total": -1}`, "data.total must be at least 0"},
+		{"exclusiveMaximum", `{"id": "o-1", "items": [{"sku": "a"}], "total": 1000}`, "data.total must be less than 1000"},
+		{"exclusiveMinimum", `{"id": "o-1", "items": [{"sku": "a"}], "discount": 0}`, "data.discount must be greater than 0"},
+		{"maximum", `{"id": "o-1", "items": [{"sku": "a"}], "discount": 51}`, "data.discount must be at most 50"},
+		{"minItems", `{"id": "o-1", "items": []}`, "data.items must have at least 1 items"},
+		{"maxItems", `{"id": "o-1", "items": [{"sku": "a"}, {"sku": "b"}, {"sku": "c"}]}`, "data.items must have at most 2 items"},
+		{"item", `{"id": "o-1", "items": [{"sku": "a"}, {"quantity": 1}]}`, "data.items[1].sku is required"},
+		{"integer", `{"id": "o-1", "items": [{"sku": "a", "quantity": 1.5}]}`, "data.items[0].quantity must be of type integer"},
+		{"anyOf", `{"id": "o-1", "items": [{"sku": "a"}], "contact": {}}`, "data.contact matches none of the allowed schemas"},
+		{"oneOf none", `{"id": "o-1", "items": [{"sku": "a"}], "payment": {}}`, "data.payment must match exactly one schema, matches 0"},
+		{"oneOf both", `{"id": "o-1", "items": [{"sku": "a"}], "payment": {"card": "x", "invoice": "y"}}`, "matches 2"},
+		{"allOf", `{"id": "o-1", "items": [{"sku": "a"}], "code": "abcd"}`, "data.code must be at most 3 characters long"},
+		{"not", `{"id": "o-1", "items": [{"sku": "a"}], "code": "xxx"}`, "data.code matches a forbidden schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tt.data), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			err := schema.Validate(v)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate failed: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaValidateData(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %v", err)
+	}
+
+	valid := NewCloudEvent("test", "order.placed", map[string]string{"id": "o-1"})
+	if err := schema.ValidateData(&valid); err != nil {
+		t.Errorf("ValidateData failed: %v", err)
+	}
+
+	invalid := NewCloudEvent("test", "order.placed", map[string]string{"name": "o-1"})
+	if err := schema.ValidateData(&invalid); err == nil {
+		t.Error("ValidateData succeeded for data without id")
+	}
+}
+
+func TestParseSchemaUnsupported(t *testing.T) {
+	tests := map[string]string{
+		"ref":             `{"$ref": "#/definitions/order"}`,
+		"format":          `{"type": "string", "format": "email"}`,
+		"nested":          `{"properties": {"id": {"type": "string", "format": "uuid"}}}`,
+		"in combinations": `{"anyOf": [{"type": "string"}, {"if": {"type": "number"}}]}`,
+		"items":           `{"items": {"uniqueItems": true}}`,
+		"invalid pattern": `{"pattern": "("}`,
+		"invalid type":    `{"type": 1}`,
+		"not an object":   `[]`,
+	}
+
+	for name, raw := range tests {
+		if _, err := ParseSchema([]byte(raw)); err == nil {
+			t.Errorf("%s: ParseSchema succeeded for %s", name, raw)
+		}
+	}
+
+	_, err := ParseSchema([]byte(`{"type": "string", "format": "email", "$ref": "#/x"}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported keywords $ref, format") {
+		t.Errorf("ParseSchema error = %v, want the unsupported keywords named", err)
+	}
+}
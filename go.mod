@@ -11,4 +11,5 @@ require (
 	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/tidwall/gjson v1.3.2
 	github.com/tidwall/sjson v1.0.4
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 )
@@ -0,0 +1,268 @@
+package surfkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/tracing"
+)
+
+// An EventHandler is called for every CloudEvent arriving on a Subscription.
+//
+// The passed context is cancelled as soon as the service shuts down and its deadline
+// is set to the Subscription's ack deadline.
+//
+// The returned error decides the fate of the underlying Pubsub message:
+// nil acknowledges (ack) the message, an error created by Poison acknowledges it as well
+// but marks it as never to be retried, any other error nacks it. An error created by
+// NackAfter asks for the redelivery to be delayed. Errors created by Poison and NackAfter
+// are recognized when wrapped by other errors, too, given these implement Unwrap or Cause.
+type EventHandler func(ctx context.Context, s *Service, e *events.CloudEvent) error
+
+// An EventMiddleware wraps an EventHandler to add behaviour around it, like logging or
+// validation. Middleware is attached to a single subscription or, via Service.EventMiddleware,
+// to all of them. See the middleware package for the built-in ones.
+type EventMiddleware func(next EventHandler) EventHandler
+
+// BoolHandler adapts a legacy handler, returning `true` for ack and `false` for nack,
+// to an EventHandler.
+func BoolHandler(fn func(s *Service, e *events.CloudEvent) bool) EventHandler {
+	return func(ctx context.Context, s *Service, e *events.CloudEvent) error {
+		if fn(s, e) {
+			return nil
+		}
+		return ErrNack
+	}
+}
+
+// ErrNack can be returned by an EventHandler to nack a message without giving any further reason.
+var ErrNack = errors.New("message not acknowledged")
+
+// A NackError requests the message to be redelivered, but not before Delay has passed.
+//
+// The message is nacked right away, Pubsub delays the redelivery according to the
+// subscription's RetryPolicy. Push and HTTP event subscriptions pass Delay on to the
+// sender in the Retry-After header.
+type NackError struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e *NackError) Error() string {
+	return fmt.Sprintf("nack after %s (%v)", e.Delay, e.Err)
+}
+
+// Unwrap returns the wrapped error.
+func (e *NackError) Unwrap() error {
+	return e.Err
+}
+
+// NackAfter wraps err so the message is nacked, asking for it not to be redelivered
+// before the given delay has passed. See NackError.
+func NackAfter(delay time.Duration, err error) error {
+	return &NackError{Delay: delay, Err: err}
+}
+
+// A PoisonError marks a message which can never be processed successfully.
+// The message is acknowledged so Pubsub stops redelivering it.
+type PoisonError struct {
+	Err error
+}
+
+func (e *PoisonError) Error() string {
+	return fmt.Sprintf("poison message (%v)", e.Err)
+}
+
+// Unwrap returns the wrapped error.
+func (e *PoisonError) Unwrap() error {
+	return e.Err
+}
+
+// Poison wraps err so the message is dropped and never retried.
+func Poison(err error) error {
+	return &PoisonError{Err: err}
+}
+
+// IsPoison reports whether err, or an error it wraps, has been created by Poison, i.e.
+// whether a message whose handler returned err is dropped.
+func IsPoison(err error) bool {
+	return resolveOutcome(err).poison
+}
+
+// unwrap returns the error err wraps, if any. Both the Unwrap method of Go 1.13 errors
+// and the Cause method of github.com/pkg/errors are understood.
+func unwrap(err error) error {
+	switch t := err.(type) {
+	case interface{ Unwrap() error }:
+		return t.Unwrap()
+	case interface{ Cause() error }:
+		return t.Cause()
+	default:
+		return nil
+	}
+}
+
+// outcome of handling a single message.
+type outcome struct {
+	ack    bool
+	poison bool
+	delay  time.Duration
+}
+
+// dispatch calls h for e within a context derived from parent, expiring after timeout,
+// and turns the handler's result into an outcome.
+func (s *Service) dispatch(parent context.Context, timeout time.Duration, h EventHandler, e *events.CloudEvent, d *Delivery) outcome {
+	s.inflight.add()
+	defer s.inflight.done()
+
+	parent, span := s.startEventSpan(parent, e, d)
+	defer span.End()
+
+	ctx, cancel := s.handlerContext(parent, timeout)
+	defer cancel()
+
+	logger := logging.FromContext(parent).With(logging.Fields{
+		"subscription": d.Subscription,
+		"messageId":    d.MessageID,
+		"eventId":      e.ID,
+		"eventType":    e.Type,
+	})
+	if sc := tracing.SpanContextFromContext(parent); sc.IsValid() {
+		logger = logger.WithTrace(sc.TraceID, sc.SpanID)
+	}
+
+	ctx = WithDelivery(ctx, d)
+	ctx = logging.NewContext(ctx, logger)
+
+	start := time.Now()
+	err := s.call(ctx, h, e, d)
+
+	o := resolveOutcome(err)
+	s.instruments.handled(d.Subscription, o, time.Since(start))
+	span.SetError(err)
+	if o.poison {
+		logger.Warning(fmt.Sprintf("Dropping poison message %s on %s", d.MessageID, d.Subscription), logging.Fields{"error": err})
+	}
+
+	return o
+}
+
+// call runs h, turning a panic into an error so the message gets nacked. Panics recovered
+// by middleware, see middleware.RecoverEvents, are reported as well.
+func (s *Service) call(ctx context.Context, h EventHandler, e *events.CloudEvent, d *Delivery) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			p := &Panic{Value: v, Stack: debug.Stack(), Event: e, Delivery: d}
+			s.reportPanic(ctx, p)
+			err = p
+		}
+	}()
+
+	err = h(ctx, s, e)
+	for cause := err; cause != nil; cause = unwrap(cause) {
+		if p, ok := cause.(*Panic); ok {
+			s.reportPanic(ctx, p)
+			break
+		}
+	}
+
+	return err
+}
+
+// resolveOutcome turns the error returned by an EventHandler into an ack decision.
+// The outermost PoisonError or NackError err wraps decides.
+func resolveOutcome(err error) outcome {
+	if err == nil {
+		return outcome{ack: true}
+	}
+
+	for cause := err; cause != nil; cause = unwrap(cause) {
+		switch t := cause.(type) {
+		case *PoisonError:
+			return outcome{ack: true, poison: true}
+		case *NackError:
+			return outcome{delay: t.Delay}
+		}
+	}
+
+	return outcome{}
+}
+
+// A tracker counts operations in flight.
+type tracker struct {
+	mu   sync.Mutex
+	n    int
+	zero chan struct{}
+}
+
+func (t *tracker) add() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.n == 0 {
+		t.zero = make(chan struct{})
+	}
+	t.n++
+}
+
+func (t *tracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.n--
+	if t.n == 0 {
+		close(t.zero)
+	}
+}
+
+func (t *tracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.n
+}
+
+// idle returns a channel which is closed once no operation is in flight.
+func (t *tracker) idle() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.n == 0 {
+		c := make(chan struct{})
+		close(c)
+		return c
+	}
+
+	return t.zero
+}
+
+// wrapHandler wraps h with the middleware of the service and the given subscription middleware.
+// The first middleware is the outermost, service middleware runs before subscription middleware.
+func (s *Service) wrapHandler(middleware []EventMiddleware, h EventHandler) EventHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	for i := len(s.EventMiddleware) - 1; i >= 0; i-- {
+		h = s.EventMiddleware[i](h)
+	}
+
+	return h
+}
+
+// resolveHandler picks the EventHandler to use, falling back to the legacy bool handler.
+func resolveHandler(h EventHandler, legacy func(s *Service, e *events.CloudEvent) bool) EventHandler {
+	if h != nil {
+		return h
+	}
+	if legacy != nil {
+		return BoolHandler(legacy)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package surfkit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// wrapped is an error wrapping another one, the way Go 1.13 errors do.
+type wrapped struct {
+	msg string
+	err error
+}
+
+func (w *wrapped) Error() string { return fmt.Sprintf("%s: %v", w.msg, w.err) }
+func (w *wrapped) Unwrap() error { return w.err }
+
+// caused is an error wrapping another one, the way github.com/pkg/errors does.
+type caused struct {
+	err error
+}
+
+func (c *caused) Error() string { return c.err.Error() }
+func (c *caused) Cause() error  { return c.err }
+
+func TestResolveOutcome(t *testing.T) {
+	poison := Poison(errors.New("invalid"))
+	nack := NackAfter(time.Minute, errors.New("busy"))
+
+	tests := []struct {
+		name string
+		err  error
+		want outcome
+	}{
+		{"nil", nil, outcome{ack: true}},
+		{"nack", ErrNack, outcome{}},
+		{"poison", poison, outcome{ack: true, poison: true}},
+		{"nack after", nack, outcome{delay: time.Minute}},
+		{"wrapped poison", &wrapped{"handling order", poison}, outcome{ack: true, poison: true}},
+		{"caused poison", &caused{poison}, outcome{ack: true, poison: true}},
+		{"deeply wrapped nack after", &caused{&wrapped{"handling order", &wrapped{"calling api", nack}}}, outcome{delay: time.Minute}},
+		{"poison wrapped by nack", NackAfter(time.Second, poison), outcome{delay: time.Second}},
+		{"nack wrapped by poison", Poison(&wrapped{"retry", nack}), outcome{ack: true, poison: true}},
+		{"wrapped other", &wrapped{"handling order", errors.New("failed")}, outcome{}},
+		{"panic", &Panic{Value: "boom"}, outcome{}},
+	}
+
+	for _, tt := range tests {
+		if got := resolveOutcome(tt.err); got != tt.want {
+			t.Errorf("%s: resolveOutcome = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got := IsPoison(tt.err); got != tt.want.poison {
+			t.Errorf("%s: IsPoison = %v, want %v", tt.name, got, tt.want.poison)
+		}
+	}
+}
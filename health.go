@@ -0,0 +1,280 @@
+package surfkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+// Paths the health endpoints are served on.
+const (
+	LivenessPath  = "/livez"
+	ReadinessPath = "/readyz"
+)
+
+const (
+	defaultHealthCheckTimeout = 5 * time.Second
+
+	// topicCheckTTL is how long a topic found to exist isn't checked again.
+	topicCheckTTL = time.Minute
+)
+
+// A HealthCheck probes something the service depends on, e.g. by pinging its database.
+type HealthCheck struct {
+
+	// Name the check is reported under.
+	Name string
+
+	// Check returns an error if the dependency is unhealthy.
+	Check func(ctx context.Context) error
+
+	// Timeout of a single run of Check, after which it is reported as failed.
+	// Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// Liveness adds the check to the liveness endpoint. Otherwise it only affects readiness.
+	// A failing liveness check gets the service restarted, so only use it for failures a
+	// restart fixes.
+	Liveness bool
+}
+
+func (c *HealthCheck) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultHealthCheckTimeout
+	}
+
+	return c.Timeout
+}
+
+// healthReport is the response of the health endpoints.
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Status of health reports and checks.
+const (
+	healthOK     = "ok"
+	healthFailed = "failed"
+)
+
+// setupHealth mounts the liveness and readiness endpoints.
+func setupHealth(s *Service) {
+	s.Router.HandleFunc(LivenessPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveHealth(w, r, s.livenessChecks())
+	}).Methods("GET")
+
+	s.Router.HandleFunc(ReadinessPath, func(w http.ResponseWriter, r *http.Request) {
+		s.serveHealth(w, r, s.readinessChecks())
+	}).Methods("GET")
+}
+
+// livenessChecks are the service's checks marked as Liveness.
+func (s *Service) livenessChecks() []HealthCheck {
+	var checks []HealthCheck
+	for _, c := range s.HealthChecks {
+		if c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+
+	return checks
+}
+
+// readinessChecks are the built-in checks followed by all of the service's checks.
+// The service is ready once it listens on all pull subscriptions and reaches the
+// topics of all outputs, until the shutdown starts. Topics found to exist aren't
+// checked again for topicCheckTTL.
+func (s *Service) readinessChecks() []HealthCheck {
+	checks := []HealthCheck{{
+		Name: "shutdown",
+		Check: func(ctx context.Context) error {
+			if s.isDraining() {
+				return errors.New("shutting down")
+			}
+			return nil
+		},
+	}}
+
+	for _, sub := range pubsubSubscriptions(s) {
+		if _, ok := sub.(*PullSubscription); !ok {
+			continue
+		}
+
+		name := sub.GetName()
+		checks = append(checks, HealthCheck{
+			Name: fmt.Sprintf("subscription/%s", name),
+			Check: func(ctx context.Context) error {
+				if !s.listeners.get(name) {
+					return fmt.Errorf("not listening on %s", name)
+				}
+				return nil
+			},
+		})
+	}
+
+	if t, ok := s.Transport.(transport.TopicChecker); ok {
+		for _, o := range serviceOutputs(s) {
+			if o.URL != "" {
+				continue
+			}
+
+			topic := o.EventType
+			checks = append(checks, HealthCheck{
+				Name: fmt.Sprintf("output/%s", topic),
+				Check: func(ctx context.Context) error {
+					if s.topics.exists(topic) {
+						return nil
+					}
+
+					ok, err := t.TopicExists(ctx, topic)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return fmt.Errorf("topic %s not found", topic)
+					}
+
+					s.topics.found(topic)
+					return nil
+				},
+			})
+		}
+	}
+
+	return append(checks, s.HealthChecks...)
+}
+
+// serveHealth runs checks concurrently and responds with their report. It fails with
+// 503 Service Unavailable unless all checks passed.
+func (s *Service) serveHealth(w http.ResponseWriter, r *http.Request, checks []HealthCheck) {
+	report := healthReport{
+		Status: healthOK,
+		Checks: make([]checkResult, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = runCheck(r.Context(), &checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, c := range report.Checks {
+		if c.Status != healthOK {
+			report.Status = healthFailed
+			status = http.StatusServiceUnavailable
+
+			logging.FromContext(r.Context()).Warning(fmt.Sprintf("Health check %s failed", c.Name), logging.Fields{
+				"check": c.Name,
+				"error": c.Error,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// runCheck runs c within its timeout. A check ignoring its context is abandoned once
+// the timeout passed.
+func runCheck(ctx context.Context, c *HealthCheck) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.timeout())
+	}
+
+	res := checkResult{
+		Name:      c.Name,
+		Status:    healthOK,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		res.Status = healthFailed
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// listenerStates tracks which subscriptions are listening.
+type listenerStates struct {
+	mu        sync.Mutex
+	listening map[string]bool
+}
+
+func (l *listenerStates) set(name string, listening bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.listening == nil {
+		l.listening = make(map[string]bool)
+	}
+	l.listening[name] = listening
+}
+
+func (l *listenerStates) get(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.listening[name]
+}
+
+// topicStates remembers when topics were last found to exist.
+type topicStates struct {
+	mu      sync.Mutex
+	checked map[string]time.Time
+}
+
+func (t *topicStates) found(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.checked == nil {
+		t.checked = make(map[string]time.Time)
+	}
+	t.checked[topic] = time.Now()
+}
+
+func (t *topicStates) exists(topic string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.checked[topic]
+	return ok && time.Since(at) < topicCheckTTL
+}
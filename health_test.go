@@ -0,0 +1,180 @@
+package surfkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+func TestRunCheck(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	tests := []struct {
+		name  string
+		check HealthCheck
+		want  string
+	}{
+		{"ok", HealthCheck{Check: func(ctx context.Context) error { return nil }}, ""},
+		{"failed", HealthCheck{Check: func(ctx context.Context) error { return errors.New("unreachable") }}, "unreachable"},
+		{"panic", HealthCheck{Check: func(ctx context.Context) error { panic("boom") }}, "panic: boom"},
+		{"timeout", HealthCheck{Timeout: 20 * time.Millisecond, Check: func(ctx context.Context) error {
+			<-hang
+			return nil
+		}}, "timed out after 20ms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check.Name = tt.name
+
+			start := time.Now()
+			res := runCheck(context.Background(), &tt.check)
+
+			if res.Name != tt.name || res.Error != tt.want {
+				t.Errorf("runCheck = %+v, want error %q", res, tt.want)
+			}
+			if (res.Status == healthOK) != (tt.want == "") {
+				t.Errorf("runCheck status = %s with error %q", res.Status, res.Error)
+			}
+			if took := time.Since(start); took > time.Second {
+				t.Errorf("runCheck took %v", took)
+			}
+		})
+	}
+}
+
+// countingTopics is a TopicChecker counting its calls.
+type countingTopics struct {
+	*transport.Memory
+	calls int32
+}
+
+func (c *countingTopics) TopicExists(ctx context.Context, topic string) (bool, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return c.Memory.TopicExists(ctx, topic)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	b := transport.NewMemory()
+	defer b.Close()
+
+	topics := &countingTopics{Memory: b}
+	var reachable int32
+
+	s := &Service{
+		Router:    mux.NewRouter(),
+		Logger:    logging.New(&recorder{}, logging.Debug),
+		Transport: topics,
+		Output:    &Output{EventType: "orders.placed"},
+		Subscriptions: []Subscription{
+			&PullSubscription{Name: "orders", Topic: "orders"},
+		},
+		HealthChecks: []HealthCheck{
+			{Name: "alive", Liveness: true, Check: func(ctx context.Context) error { return nil }},
+			{Name: "database", Check: func(ctx context.Context) error {
+				if atomic.LoadInt32(&reachable) == 0 {
+					return errors.New("database unreachable")
+				}
+				return nil
+			}},
+		},
+	}
+	setupHealth(s)
+
+	probe := func(path string) (int, healthReport) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		var report healthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("invalid report of %s: %v", path, err)
+		}
+
+		return w.Code, report
+	}
+
+	failed := func(report healthReport) []string {
+		var names []string
+		for _, c := range report.Checks {
+			if c.Status != healthOK {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+
+	// Liveness only runs the liveness checks
+	if code, report := probe(LivenessPath); code != http.StatusOK || report.Status != healthOK || len(report.Checks) != 1 {
+		t.Errorf("%s = %d %+v, want only the alive check to pass", LivenessPath, code, report)
+	}
+
+	code, report := probe(ReadinessPath)
+	if code != http.StatusServiceUnavailable || report.Status != healthFailed {
+		t.Errorf("%s = %d %s, want %d", ReadinessPath, code, report.Status, http.StatusServiceUnavailable)
+	}
+	if got := failed(report); len(got) != 3 || got[0] != "subscription/orders" || got[1] != "output/orders.placed" || got[2] != "database" {
+		t.Errorf("failed checks = %v, want the subscription, the output and the database", got)
+	}
+
+	// Missing topics are checked on every probe
+	if calls := atomic.LoadInt32(&topics.calls); calls != 1 {
+		t.Errorf("TopicExists called %d times, want 1", calls)
+	}
+
+	atomic.StoreInt32(&reachable, 1)
+	s.listeners.set("orders", true)
+	if err := b.EnsureTopic(context.Background(), "orders.placed"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if code, report := probe(ReadinessPath); code != http.StatusOK || report.Status != healthOK {
+			t.Errorf("%s = %d, failing %v, want %d", ReadinessPath, code, failed(report), http.StatusOK)
+		}
+	}
+
+	// Existing topics are cached
+	if calls := atomic.LoadInt32(&topics.calls); calls != 2 {
+		t.Errorf("TopicExists called %d times, want 2", calls)
+	}
+
+	// Draining fails readiness, but not liveness
+	atomic.StoreInt32(&s.draining, 1)
+
+	if code, report := probe(ReadinessPath); code != http.StatusServiceUnavailable || len(failed(report)) != 1 || failed(report)[0] != "shutdown" {
+		t.Errorf("%s while draining = %d, failing %v, want the shutdown check to fail", ReadinessPath, code, failed(report))
+	}
+	if code, _ := probe(LivenessPath); code != http.StatusOK {
+		t.Errorf("%s while draining = %d, want %d", LivenessPath, code, http.StatusOK)
+	}
+}
+
+func TestTopicStatesExpire(t *testing.T) {
+	var topics topicStates
+
+	if topics.exists("orders") {
+		t.Error("unchecked topic exists")
+	}
+
+	topics.found("orders")
+	if !topics.exists("orders") {
+		t.Error("topic found to exist isn't cached")
+	}
+
+	topics.checked["orders"] = time.Now().Add(-topicCheckTTL)
+	if topics.exists("orders") {
+		t.Error("topic is cached past its TTL")
+	}
+}
@@ -0,0 +1,119 @@
+package surfkit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// An HTTPEventSubscription receives CloudEvents sent directly via HTTP, e.g. by
+// Knative or Eventarc, without any Pubsub involved.
+//
+// Structured (application/cloudevents+json), binary (ce- headers) and batch
+// (application/cloudevents-batch+json) mode are supported.
+// See https://github.com/cloudevents/spec/blob/v1.0/http-protocol-binding.md
+type HTTPEventSubscription struct {
+
+	// Name this Subscription.
+	Name string
+
+	// Path the events are received on. Defaults to /sk/v1/events/{Name}.
+	Path string
+
+	// A func that will be called for every received event.
+	Handler EventHandler
+
+	// Middleware wrapping the handler of this subscription.
+	Middleware []EventMiddleware
+
+	// How long the Handler may take for a single event. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	service *Service
+	handler EventHandler
+}
+
+// Setup mounts the receiving route.
+func (h *HTTPEventSubscription) Setup(s *Service) error {
+	h.service = s
+
+	if h.Handler == nil {
+		return fmt.Errorf("subscription %s has no handler", h.Name)
+	}
+	h.handler = s.wrapHandler(h.Middleware, h.Handler)
+
+	if h.Path == "" {
+		h.Path = fmt.Sprintf("/sk/v1/events/%s", h.Name)
+	}
+
+	s.Router.HandleFunc(h.Path, h.incomingEvents).Methods("POST")
+
+	s.Logger.Info(fmt.Sprintf("HTTP: Subscription (%s) mounted at %s", h.Name, h.Path), logging.Fields{
+		"subscription": h.Name,
+		"path":         h.Path,
+	})
+	return nil
+}
+
+// Listen .. noop
+func (h *HTTPEventSubscription) Listen(s *Service) error {
+	return nil
+}
+
+// Teardown .. noop
+func (h *HTTPEventSubscription) Teardown(s *Service) error {
+	return nil
+}
+
+// GetName of this Subscription
+func (h *HTTPEventSubscription) GetName() string {
+	return h.Name
+}
+
+// incomingEvents handles every event of a request. The request only succeeds
+// if all events are acknowledged, so the sender retries the whole request otherwise.
+func (h *HTTPEventSubscription) incomingEvents(w http.ResponseWriter, r *http.Request) {
+	evs, err := events.ReadHTTP(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warning("Failed to read CloudEvents", logging.Fields{"subscription": h.Name, "error": err})
+		h.service.instruments.unmarshalFailed(h.Name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var delay time.Duration
+	acked := true
+
+	for _, e := range evs {
+		o := h.service.dispatch(r.Context(), ackDeadline(h.Timeout), h.handler, e, &Delivery{
+			Subscription: h.Name,
+			MessageID:    e.ID,
+		})
+
+		if !o.ack {
+			acked = false
+			if o.delay > delay {
+				delay = o.delay
+			}
+		}
+	}
+
+	switch {
+	case acked:
+		w.WriteHeader(http.StatusOK)
+	case delay > 0:
+		w.Header().Set("Retry-After", retryAfter(delay))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// retryAfter formats d as value of a Retry-After header, in seconds rounded up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
@@ -0,0 +1,255 @@
+package idempotency
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// minCompaction is the number of records a FileStore's log holds at least before it is compacted.
+const minCompaction = 1000
+
+// FileStore remembers processed keys in a local file, so they survive restarts of the
+// service. The file is an append-only log, which is compacted once it mostly holds
+// expired or superseded records. Claims of keys being processed are kept in memory only.
+//
+// A file must only be used by a single FileStore at a time.
+type FileStore struct {
+	path string
+
+	mu        sync.Mutex
+	f         *os.File
+	processed map[string]time.Time
+	expiries  expiryQueue
+	inFlight  map[string]fileClaim
+	records   int
+}
+
+type fileClaim struct {
+	owner   string
+	expires time.Time
+}
+
+type fileRecord struct {
+	Key     string `json:"key"`
+	Expires int64  `json:"expires"`
+}
+
+type expiry struct {
+	key     string
+	expires time.Time
+}
+
+// expiryQueue is a min-heap of processed keys, ordered by when they expire.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].expires.Before(q[j].expires) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiry)) }
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// OpenFileStore loads the keys recorded in the file at path, creating it if needed.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:      path,
+		processed: make(map[string]time.Time),
+		inFlight:  make(map[string]fileClaim),
+	}
+
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Begin claims key for processing, unless it has been processed or is claimed already.
+func (s *FileStore) Begin(ctx context.Context, key string, lease time.Duration) (State, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if expires, ok := s.processed[key]; ok && now.Before(expires) {
+		return Processed, "", nil
+	}
+
+	if claim, ok := s.inFlight[key]; ok && now.Before(claim.expires) {
+		return InFlight, "", nil
+	}
+
+	s.inFlight[key] = fileClaim{owner: token, expires: now.Add(lease)}
+	return New, token, nil
+}
+
+// Done records key as processed for ttl.
+func (s *FileStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(ttl)
+
+	delete(s.inFlight, key)
+	s.processed[key] = expires
+	heap.Push(&s.expiries, expiry{key: key, expires: expires})
+	s.expire(now)
+
+	b, err := json.Marshal(fileRecord{Key: key, Expires: expires.Unix()})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.f.Write(append(b, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write %s (%v)", s.path, err)
+	}
+	s.records++
+
+	// Records of expired keys and of keys which have been processed again are stale.
+	stale := s.records - len(s.processed)
+	if s.records > minCompaction && stale > len(s.processed) {
+		return s.compact()
+	}
+
+	return nil
+}
+
+// Release forgets the claim on key, if token still holds it.
+func (s *FileStore) Release(ctx context.Context, key string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if claim, ok := s.inFlight[key]; ok && claim.owner == token {
+		delete(s.inFlight, key)
+	}
+	return nil
+}
+
+// Close the underlying file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
+
+func (s *FileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s (%v)", s.path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r fileRecord
+
+		// A partially written last record, e.g. after a crash, is skipped.
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+
+		s.processed[r.Key] = time.Unix(r.Expires, 0)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s (%v)", s.path, err)
+	}
+
+	return nil
+}
+
+// expire forgets the keys which expired before now. Must be called with s.mu held.
+func (s *FileStore) expire(now time.Time) {
+	for len(s.expiries) > 0 && !now.Before(s.expiries[0].expires) {
+		e := heap.Pop(&s.expiries).(expiry)
+
+		// The key may have been processed again since, expiring later
+		if expires, ok := s.processed[e.key]; ok && expires.Equal(e.expires) {
+			delete(s.processed, e.key)
+		}
+	}
+}
+
+// compact rewrites the log with the keys which haven't expired yet. Must be called with
+// s.mu held, unless during OpenFileStore.
+func (s *FileStore) compact() error {
+	now := time.Now()
+	tmp := s.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to compact %s (%v)", s.path, err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	s.expiries = s.expiries[:0]
+	for key, expires := range s.processed {
+		if !now.Before(expires) {
+			delete(s.processed, key)
+			continue
+		}
+		s.expiries = append(s.expiries, expiry{key: key, expires: expires})
+
+		if err == nil {
+			err = enc.Encode(fileRecord{Key: key, Expires: expires.Unix()})
+		}
+	}
+
+	heap.Init(&s.expiries)
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact %s (%v)", s.path, err)
+	}
+
+	if s.f != nil {
+		s.f.Close()
+	}
+
+	s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s (%v)", s.path, err)
+	}
+	s.records = len(s.processed)
+
+	return nil
+}
@@ -0,0 +1,177 @@
+// Package idempotency skips events which have been handled already. Pubsub delivers
+// messages at least once, so a handler may see the same event several times.
+//
+//	store := idempotency.NewMemoryStore(10000)
+//
+//	&surfkit.PullSubscription{
+//		Name:       "my-service",
+//		Topic:      "my.topic",
+//		Handler:    handleEvent,
+//		Middleware: []surfkit.EventMiddleware{idempotency.Middleware(store, idempotency.Options{})},
+//	}
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// A State of an event key.
+type State int
+
+const (
+	// New keys have not been seen before, the caller holds the claim now.
+	New State = iota
+
+	// InFlight keys are being processed by someone else right now.
+	InFlight
+
+	// Processed keys have been handled successfully.
+	Processed
+)
+
+// A Store records which events have been processed.
+// Implementations must be safe for concurrent use.
+type Store interface {
+
+	// Begin claims key for processing for the duration of lease, unless the key has been
+	// processed already or is claimed by someone else. For New keys, it returns a token
+	// identifying the claim, which is passed to Release.
+	Begin(ctx context.Context, key string, lease time.Duration) (State, string, error)
+
+	// Done marks key as processed. It is remembered for ttl.
+	Done(ctx context.Context, key string, ttl time.Duration) error
+
+	// Release gives up the claim on key identified by token, so the event can be processed
+	// again. Once the lease expired and someone else claimed the key, their claim is kept.
+	Release(ctx context.Context, key string, token string) error
+}
+
+// ErrInFlight is returned, wrapped by surfkit.NackAfter, for events being processed concurrently.
+var ErrInFlight = errors.New("event is being processed already")
+
+const (
+	defaultTTL   = 24 * time.Hour
+	defaultLease = time.Minute
+
+	// inFlightRetry delays the redelivery of events being processed concurrently.
+	inFlightRetry = 5 * time.Second
+)
+
+// Options of the idempotency Middleware.
+type Options struct {
+
+	// TTL is how long processed events are remembered. Defaults to 24 hours.
+	TTL time.Duration
+
+	// Lease is how long an event is claimed while being processed. A claim outliving its lease,
+	// e.g. because the service crashed, is given up. Defaults to the handler's deadline or,
+	// without one, to one minute.
+	Lease time.Duration
+
+	// Key identifies an event. Defaults to SourceID.
+	Key func(e *events.CloudEvent) string
+}
+
+// SourceID identifies an event by its source and ID, which the CloudEvents spec requires
+// to be unique.
+func SourceID(e *events.CloudEvent) string {
+	return e.Source + "/" + e.ID
+}
+
+// ID identifies an event by its ID only, which is a UUID for events created by events.NewCloudEvent.
+func ID(e *events.CloudEvent) string {
+	return e.ID
+}
+
+// Middleware returns an event middleware which only calls the handler for events the
+// store doesn't know yet. Events processed before are acked right away, events processed
+// concurrently are nacked and retried later.
+//
+// An event counts as processed if the handler returns nil or a poison error. Otherwise,
+// also if the handler panics, the claim is released, so the redelivery can be processed.
+func Middleware(store Store, opts Options) surfkit.EventMiddleware {
+	if opts.TTL == 0 {
+		opts.TTL = defaultTTL
+	}
+	if opts.Key == nil {
+		opts.Key = SourceID
+	}
+
+	return func(next surfkit.EventHandler) surfkit.EventHandler {
+		return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			key := opts.Key(e)
+
+			state, token, err := store.Begin(ctx, key, lease(ctx, opts.Lease))
+			if err != nil {
+				return err
+			}
+
+			switch state {
+			case Processed:
+				logging.FromContext(ctx).Info(fmt.Sprintf("Skipping duplicate event %s", key), logging.Fields{"key": key})
+				return nil
+			case InFlight:
+				return surfkit.NackAfter(inFlightRetry, ErrInFlight)
+			}
+
+			processed := false
+			defer func() {
+				if processed {
+					return
+				}
+
+				// Runs while a panic unwinds as well, so the redelivery isn't blocked until the lease expires
+				rerr := store.Release(context.Background(), key, token)
+				if rerr != nil {
+					logging.FromContext(ctx).Error("Failed to release event", logging.Fields{"key": key, "error": rerr})
+				}
+			}()
+
+			err = next(ctx, s, e)
+
+			if err == nil || surfkit.IsPoison(err) {
+				processed = true
+
+				derr := store.Done(context.Background(), key, opts.TTL)
+				if derr != nil {
+					logging.FromContext(ctx).Error("Failed to record event as processed", logging.Fields{"key": key, "error": derr})
+				}
+			}
+
+			return err
+		}
+	}
+}
+
+// lease returns the configured lease or derives it from the deadline of ctx.
+func lease(ctx context.Context, configured time.Duration) time.Duration {
+	if configured != 0 {
+		return configured
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d > 0 {
+			return d
+		}
+	}
+
+	return defaultLease
+}
+
+// newToken returns a random token identifying a claim.
+func newToken() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate claim token (%v)", err)
+	}
+
+	return id.String(), nil
+}
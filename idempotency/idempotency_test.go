@@ -0,0 +1,245 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+)
+
+// wrapped wraps an error like github.com/pkg/errors does.
+type wrapped struct {
+	err error
+}
+
+func (w *wrapped) Error() string { return w.err.Error() }
+func (w *wrapped) Cause() error  { return w.err }
+
+func stores(t *testing.T) (map[string]Store, func()) {
+	dir, err := ioutil.TempDir("", "idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := OpenFileStore(filepath.Join(dir, "events"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+
+	return map[string]Store{"memory": NewMemoryStore(100), "file": file}, func() {
+		file.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestReleaseChecksOwner(t *testing.T) {
+	all, cleanup := stores(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	for name, store := range all {
+		t.Run(name, func(t *testing.T) {
+			state, stale, err := store.Begin(ctx, "k", time.Millisecond)
+			if err != nil || state != New || stale == "" {
+				t.Fatalf("Begin = %v, %q, %v, want New with a token", state, stale, err)
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			state, token, err := store.Begin(ctx, "k", time.Minute)
+			if err != nil || state != New || token == stale {
+				t.Fatalf("Begin after the lease expired = %v, %q, %v, want New with a new token", state, token, err)
+			}
+
+			// The first claim's lease expired, its release must not affect the second claim
+			if err := store.Release(ctx, "k", stale); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if state, _, _ := store.Begin(ctx, "k", time.Minute); state != InFlight {
+				t.Errorf("state after a stale release = %v, want InFlight", state)
+			}
+
+			if err := store.Release(ctx, "k", token); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+			if state, _, _ := store.Begin(ctx, "k", time.Minute); state != New {
+				t.Errorf("state after release = %v, want New", state)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	all, cleanup := stores(t)
+	defer cleanup()
+
+	for name, store := range all {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			h := Middleware(store, Options{})(func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+				calls++
+				switch e.ID {
+				case "panic":
+					if calls == 1 {
+						panic("boom")
+					}
+				case "nack":
+					if calls == 1 {
+						return surfkit.ErrNack
+					}
+				case "wrapped poison":
+					return &wrapped{surfkit.Poison(errors.New("invalid"))}
+				}
+				return nil
+			})
+
+			for _, id := range []string{"ok", "nack", "panic", "wrapped poison"} {
+				calls = 0
+				e := &events.CloudEvent{ID: id, Source: "test"}
+
+				func() {
+					defer func() { recover() }()
+					h(context.Background(), nil, e)
+				}()
+
+				// A redelivery is processed unless the first one succeeded or was poison
+				if err := h(context.Background(), nil, e); err != nil {
+					t.Errorf("%s: redelivery failed: %v", id, err)
+				}
+
+				want := 2
+				if id == "ok" || id == "wrapped poison" {
+					want = 1
+				}
+				if calls != want {
+					t.Errorf("%s: handler called %d times, want %d", id, calls, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareInFlight(t *testing.T) {
+	store := NewMemoryStore(100)
+	e := &events.CloudEvent{ID: "1", Source: "test"}
+
+	if _, _, err := store.Begin(context.Background(), SourceID(e), time.Minute); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+
+	h := Middleware(store, Options{})(func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+		t.Error("handler called for an event in flight")
+		return nil
+	})
+
+	err := h(context.Background(), nil, e)
+	nack, ok := err.(*surfkit.NackError)
+	if !ok || nack.Err != ErrInFlight {
+		t.Errorf("error = %v, want a NackError carrying ErrInFlight", err)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idempotency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for i := 0; i < minCompaction; i++ {
+		if err := store.Done(ctx, fmt.Sprintf("expired-%d", i), time.Millisecond); err != nil {
+			t.Fatalf("Done failed: %v", err)
+		}
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Exceeding minCompaction with mostly expired records compacts the log
+	if err := store.Done(ctx, "live", time.Hour); err != nil {
+		t.Fatalf("Done failed: %v", err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("log has %d bytes after compaction, want less than %d", after.Size(), before.Size())
+	}
+
+	if len(store.processed) != 1 {
+		t.Errorf("store holds %d keys, want 1", len(store.processed))
+	}
+	if state, _, _ := store.Begin(ctx, "expired-0", time.Minute); state != New {
+		t.Errorf("state of an expired key = %v, want New", state)
+	}
+
+	store.Close()
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	defer reopened.Close()
+
+	if len(reopened.processed) != 1 {
+		t.Errorf("reopened store holds %d keys, want 1", len(reopened.processed))
+	}
+	if state, _, _ := reopened.Begin(ctx, "live", time.Minute); state != Processed {
+		t.Errorf("state of a live key after reopening = %v, want Processed", state)
+	}
+}
+
+func TestMemoryStoreKeepsClaims(t *testing.T) {
+	store := NewMemoryStore(3)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b"} {
+		if state, _, err := store.Begin(ctx, key, time.Minute); err != nil || state != New {
+			t.Fatalf("Begin(%s) = %v, %v, want New", key, state, err)
+		}
+	}
+
+	for _, key := range []string{"c", "d", "e"} {
+		if err := store.Done(ctx, key, time.Hour); err != nil {
+			t.Fatalf("Done failed: %v", err)
+		}
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if state, _, _ := store.Begin(ctx, key, time.Minute); state != InFlight {
+			t.Errorf("state of claimed key %s = %v, want InFlight", key, state)
+		}
+	}
+
+	// Processed keys are evicted in LRU order, leaving the most recent one
+	if state, _, _ := store.Begin(ctx, "e", time.Minute); state != Processed {
+		t.Errorf("state of e = %v, want Processed", state)
+	}
+	if state, _, _ := store.Begin(ctx, "c", time.Minute); state != New {
+		t.Errorf("state of evicted key c = %v, want New", state)
+	}
+}
@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps keys in memory, evicting the least recently used ones once it holds
+// more than its capacity. Keys being processed aren't evicted until their lease expires,
+// so the store may exceed its capacity while many events are in flight. Keys are lost
+// when the process ends and aren't shared between instances of a service.
+type MemoryStore struct {
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type memoryEntry struct {
+	key     string
+	state   State
+	owner   string
+	expires time.Time
+}
+
+// NewMemoryStore returns a MemoryStore holding up to capacity keys.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Begin claims key for processing, unless it has been processed or is claimed already.
+func (m *MemoryStore) Begin(ctx context.Context, key string, lease time.Duration) (State, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := m.entries[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		if now.Before(entry.expires) {
+			m.lru.MoveToFront(el)
+			return entry.state, "", nil
+		}
+	}
+
+	m.set(key, InFlight, token, now.Add(lease))
+	return New, token, nil
+}
+
+// Done marks key as processed for ttl.
+func (m *MemoryStore) Done(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, Processed, "", time.Now().Add(ttl))
+	return nil
+}
+
+// Release forgets the claim on key, if token still holds it.
+func (m *MemoryStore) Release(ctx context.Context, key string, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*memoryEntry)
+	if entry.state == InFlight && entry.owner == token {
+		m.lru.Remove(el)
+		delete(m.entries, key)
+	}
+
+	return nil
+}
+
+// set must be called with m.mu held.
+func (m *MemoryStore) set(key string, state State, owner string, expires time.Time) {
+	if el, ok := m.entries[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.state = state
+		entry.owner = owner
+		entry.expires = expires
+		m.lru.MoveToFront(el)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryEntry{key: key, state: state, owner: owner, expires: expires})
+
+	if m.capacity <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for el := m.lru.Back(); el != nil && m.lru.Len() > m.capacity; {
+		prev := el.Prev()
+
+		// Evicting a claim would let a redelivery be processed concurrently
+		entry := el.Value.(*memoryEntry)
+		if entry.state != InFlight || !now.Before(entry.expires) {
+			m.lru.Remove(el)
+			delete(m.entries, entry.key)
+		}
+
+		el = prev
+	}
+}
@@ -0,0 +1,267 @@
+package surfkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/secrets"
+)
+
+// ConfigPath the effective configuration is served on, if Service.ConfigEndpoint is set.
+const ConfigPath = "/sk/v1/config"
+
+// redactedURLPart replaces sensitive parts of URLs in the configuration report.
+const redactedURLPart = "xxxxx"
+
+// configReport describes the configuration a service effectively runs with.
+// It never contains secrets.
+type configReport struct {
+	Service       string                 `json:"service"`
+	Version       string                 `json:"version"`
+	Surfkit       string                 `json:"surfkit"`
+	Env           envReport              `json:"env"`
+	Server        serverReport           `json:"server"`
+	Subscriptions []subscriptionReport   `json:"subscriptions"`
+	Outputs       []outputReport         `json:"outputs"`
+	Config        map[string]interface{} `json:"config,omitempty"`
+}
+
+type envReport struct {
+	Port      string `json:"port"`
+	ProjectID string `json:"projectId,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+type serverReport struct {
+	ReadTimeout  string `json:"readTimeout"`
+	WriteTimeout string `json:"writeTimeout"`
+	DrainTimeout string `json:"drainTimeout"`
+}
+
+type subscriptionReport struct {
+	Name         string `json:"name"`
+	Mode         string `json:"mode"`
+	Topic        string `json:"topic,omitempty"`
+	AckDeadline  string `json:"ackDeadline,omitempty"`
+	PushEndpoint string `json:"pushEndpoint,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Skipped      string `json:"skipped,omitempty"`
+}
+
+type outputReport struct {
+	EventType   string `json:"eventType"`
+	Topic       string `json:"topic,omitempty"`
+	URL         string `json:"url,omitempty"`
+	SpecVersion string `json:"specVersion,omitempty"`
+	ContentMode string `json:"contentMode"`
+	Outbox      bool   `json:"outbox,omitempty"`
+}
+
+// setupConfigEndpoint serves the configuration report to authenticated callers, if enabled.
+func setupConfigEndpoint(s *Service) error {
+	if s.ConfigEndpoint == nil {
+		return nil
+	}
+
+	v, err := s.ConfigEndpoint.verifier(s, ConfigPath)
+	if err != nil {
+		return fmt.Errorf("invalid auth for config endpoint (%v)", err)
+	}
+
+	s.Router.HandleFunc(ConfigPath, authenticate(v, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newConfigReport(s))
+	})).Methods("GET")
+
+	return nil
+}
+
+// logConfig logs the configuration the service boots with.
+func logConfig(s *Service) {
+	report := newConfigReport(s)
+
+	fields := logging.Fields{
+		"env":           report.Env,
+		"server":        report.Server,
+		"subscriptions": report.Subscriptions,
+		"outputs":       report.Outputs,
+		"version":       report.Version,
+		"surfkit":       report.Surfkit,
+	}
+	if report.Config != nil {
+		fields["config"] = report.Config
+	}
+
+	s.Logger.Info("Configuration", fields)
+}
+
+// newConfigReport describes the configuration of s, once it has been set up.
+func newConfigReport(s *Service) *configReport {
+	timeout := getTimeout(s)
+
+	report := &configReport{
+		Service: s.Name,
+		Version: s.Version,
+		Surfkit: version,
+		Server: serverReport{
+			ReadTimeout:  timeout.String(),
+			WriteTimeout: timeout.String(),
+			DrainTimeout: drainTimeout(s).String(),
+		},
+		Subscriptions: []subscriptionReport{},
+		Outputs:       []outputReport{},
+	}
+
+	if s.Env != nil {
+		report.Env = envReport{
+			Port:      s.Env.Port,
+			ProjectID: s.Env.ProjectID,
+			Host:      s.Env.Host,
+		}
+	}
+
+	for _, sub := range pubsubSubscriptions(s) {
+		report.Subscriptions = append(report.Subscriptions, newSubscriptionReport(sub))
+	}
+
+	outputs := make(map[string]*Output)
+	for _, o := range serviceOutputs(s) {
+		outputs[o.EventType] = o
+	}
+
+	eventTypes := make([]string, 0, len(s.Publishers))
+	for eventType := range s.Publishers {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	for _, eventType := range eventTypes {
+		p := s.Publishers[eventType]
+		o := outputReport{
+			EventType:   eventType,
+			Topic:       p.Topic,
+			SpecVersion: p.SpecVersion,
+			ContentMode: contentModeName(p.ContentMode),
+		}
+
+		if output, ok := outputs[eventType]; ok {
+			o.URL = redactURL(output.URL)
+			o.Outbox = output.Outbox != nil
+			if o.URL != "" {
+				o.Topic = ""
+			}
+		}
+
+		report.Outputs = append(report.Outputs, o)
+	}
+
+	if s.Config != nil {
+		vars, err := configVars(s.Config)
+		if err == nil {
+			report.Config = make(map[string]interface{}, len(vars))
+			for _, v := range vars {
+				report.Config[v.Name] = v.report()
+			}
+		}
+	}
+
+	return report
+}
+
+func newSubscriptionReport(sub Subscription) subscriptionReport {
+	switch sub := sub.(type) {
+	case *PushSubscription:
+		return subscriptionReport{
+			Name:         sub.Name,
+			Mode:         "push",
+			Topic:        sub.Topic,
+			AckDeadline:  ackDeadline(sub.AckDeadline).String(),
+			PushEndpoint: sub.endpoint,
+			Skipped:      sub.skipped,
+		}
+
+	case *PullSubscription:
+		return subscriptionReport{
+			Name:        sub.Name,
+			Mode:        "pull",
+			Topic:       sub.Topic,
+			AckDeadline: ackDeadline(sub.AckDeadline).String(),
+		}
+
+	case *HTTPEventSubscription:
+		return subscriptionReport{
+			Name: sub.Name,
+			Mode: "http",
+			Path: sub.Path,
+		}
+
+	default:
+		return subscriptionReport{
+			Name: sub.GetName(),
+			Mode: "custom",
+		}
+	}
+}
+
+// report returns the value of the variable as shown in the configuration report.
+// Secrets are redacted, as are passwords and query values of URLs.
+func (c *configVar) report() interface{} {
+	if c.isSecret() {
+		return secrets.Redacted
+	}
+
+	switch v := c.field.Interface().(type) {
+	case time.Duration:
+		return v.String()
+	case url.URL:
+		return redactURL(v.String())
+	case *url.URL:
+		if v == nil {
+			return nil
+		}
+		return redactURL(v.String())
+	default:
+		return v
+	}
+}
+
+// redactURL replaces the password and the query values of u, if any. Query values often
+// hold credentials, like API keys, tokens or signatures.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+
+	if parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), redactedURLPart)
+		}
+	}
+
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for _, values := range query {
+			for i := range values {
+				values[i] = redactedURLPart
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+
+	return parsed.String()
+}
+
+func contentModeName(m events.ContentMode) string {
+	if m == events.BinaryMode {
+		return "binary"
+	}
+
+	return "structured"
+}
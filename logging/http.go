@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPRequest describes a request served, as shown by Cloud Logging.
+// See https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+type HTTPRequest struct {
+	Method       string
+	URL          string
+	Status       int
+	RequestSize  int64
+	ResponseSize int64
+	UserAgent    string
+	RemoteIP     string
+	Referer      string
+	Protocol     string
+	Latency      time.Duration
+}
+
+// NewHTTPRequest takes the details of r which are known before it is served.
+// Status, ResponseSize and Latency are up to the caller.
+func NewHTTPRequest(r *http.Request) *HTTPRequest {
+	return &HTTPRequest{
+		Method:      r.Method,
+		URL:         r.URL.String(),
+		RequestSize: r.ContentLength,
+		UserAgent:   r.UserAgent(),
+		RemoteIP:    remoteIP(r.RemoteAddr),
+		Referer:     r.Referer(),
+		Protocol:    r.Proto,
+	}
+}
+
+// remoteIP strips the port from addr.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// payload returns r in the JSON format of Cloud Logging.
+func (r *HTTPRequest) payload() map[string]interface{} {
+	m := map[string]interface{}{
+		"requestMethod": r.Method,
+		"requestUrl":    r.URL,
+		"status":        r.Status,
+		"responseSize":  strconv.FormatInt(r.ResponseSize, 10),
+		"latency":       fmt.Sprintf("%.9fs", r.Latency.Seconds()),
+	}
+
+	if r.RequestSize > 0 {
+		m["requestSize"] = strconv.FormatInt(r.RequestSize, 10)
+	}
+	if r.UserAgent != "" {
+		m["userAgent"] = r.UserAgent
+	}
+	if r.RemoteIP != "" {
+		m["remoteIp"] = r.RemoteIP
+	}
+	if r.Referer != "" {
+		m["referer"] = r.Referer
+	}
+	if r.Protocol != "" {
+		m["protocol"] = r.Protocol
+	}
+
+	return m
+}
+
+// TraceFromRequest reads the trace and span ID from the X-Cloud-Trace-Context header set
+// by Google's load balancers or, if missing, from a W3C traceparent header. The span ID
+// is returned as 16 hex characters, as Cloud Logging expects it.
+func TraceFromRequest(r *http.Request) (trace, spanID string) {
+
+	// TRACE_ID/SPAN_ID;o=TRACE_TRUE, with a decimal span ID
+	if h := r.Header.Get("X-Cloud-Trace-Context"); h != "" {
+		parts := strings.SplitN(strings.SplitN(h, ";", 2)[0], "/", 2)
+		trace = parts[0]
+
+		if len(parts) == 2 {
+			if id, err := strconv.ParseUint(parts[1], 10, 64); err == nil {
+				spanID = fmt.Sprintf("%016x", id)
+			}
+		}
+
+		return trace, spanID
+	}
+
+	// VERSION-TRACE_ID-SPAN_ID-FLAGS
+	if h := r.Header.Get("traceparent"); h != "" {
+		parts := strings.Split(h, "-")
+		if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+			return parts[1], parts[2]
+		}
+	}
+
+	return "", ""
+}
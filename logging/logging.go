@@ -0,0 +1,208 @@
+// Package logging writes structured, leveled log entries. By default, entries are written
+// as JSON in the format understood by Google Cloud Logging, so severity, trace and HTTP
+// request details show up as such in the Cloud Console.
+// See https://cloud.google.com/logging/docs/structured-logging
+//
+// Every context surfkit hands to handlers carries a logger, which already knows about the
+// request or event being handled:
+//
+//	logging.FromContext(ctx).Info("Order placed", logging.Fields{"order": order.ID})
+package logging
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Severity of a log entry.
+type Severity int
+
+const (
+	// Debug or trace information.
+	Debug Severity = iota
+
+	// Info is routine information, such as ongoing status or performance.
+	Info
+
+	// Warning events might cause problems.
+	Warning
+
+	// Error events are likely to cause problems.
+	Error
+
+	// Critical events cause more severe problems or outages.
+	Critical
+)
+
+// String returns the name Cloud Logging uses for s.
+func (s Severity) String() string {
+	switch s {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warning:
+		return "WARNING"
+	case Error:
+		return "ERROR"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "DEFAULT"
+	}
+}
+
+// Fields hold structured data attached to log entries.
+type Fields map[string]interface{}
+
+// An Entry is a single log record.
+type Entry struct {
+	Time     time.Time
+	Severity Severity
+	Message  string
+	Fields   Fields
+
+	// Trace and SpanID link the entry to a trace, if any.
+	Trace  string
+	SpanID string
+
+	// HTTPRequest describes the request an entry is logged for, if any.
+	HTTPRequest *HTTPRequest
+}
+
+// A Sink writes log entries, e.g. to stdout or to a logging library of choice.
+// Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(e *Entry)
+}
+
+// A Logger passes entries of at least its level on to its sink. Loggers are immutable,
+// With and WithTrace derive new ones.
+type Logger struct {
+	sink   Sink
+	level  Severity
+	fields Fields
+	trace  string
+	spanID string
+}
+
+// Default is used wherever no other logger is available. It writes JSON to stdout.
+var Default = New(&JSONSink{W: os.Stdout}, Info)
+
+// New returns a Logger writing entries of at least the given level to sink.
+func New(sink Sink, level Severity) *Logger {
+	return &Logger{sink: sink, level: level}
+}
+
+// With returns a Logger adding fields to every entry.
+func (l *Logger) With(fields Fields) *Logger {
+	c := *l
+	c.fields = merge(l.fields, fields)
+	return &c
+}
+
+// WithTrace returns a Logger linking every entry to the given trace and span.
+func (l *Logger) WithTrace(trace, spanID string) *Logger {
+	c := *l
+	c.trace = trace
+	c.spanID = spanID
+	return &c
+}
+
+// Enabled reports whether entries of severity are written.
+func (l *Logger) Enabled(severity Severity) bool {
+	return severity >= l.level
+}
+
+// Log writes msg with the given severity and fields.
+func (l *Logger) Log(severity Severity, msg string, fields ...Fields) {
+	if !l.Enabled(severity) {
+		return
+	}
+
+	l.Write(&Entry{Severity: severity, Message: msg, Fields: merge(nil, fields...)})
+}
+
+// Debug writes msg with severity Debug.
+func (l *Logger) Debug(msg string, fields ...Fields) {
+	l.Log(Debug, msg, fields...)
+}
+
+// Info writes msg with severity Info.
+func (l *Logger) Info(msg string, fields ...Fields) {
+	l.Log(Info, msg, fields...)
+}
+
+// Warning writes msg with severity Warning.
+func (l *Logger) Warning(msg string, fields ...Fields) {
+	l.Log(Warning, msg, fields...)
+}
+
+// Error writes msg with severity Error.
+func (l *Logger) Error(msg string, fields ...Fields) {
+	l.Log(Error, msg, fields...)
+}
+
+// Critical writes msg with severity Critical.
+func (l *Logger) Critical(msg string, fields ...Fields) {
+	l.Log(Critical, msg, fields...)
+}
+
+// Write completes e with the logger's fields and trace and hands it to the sink,
+// unless its severity is below the logger's level.
+func (l *Logger) Write(e *Entry) {
+	if !l.Enabled(e.Severity) {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Trace == "" {
+		e.Trace = l.trace
+		e.SpanID = l.spanID
+	}
+	e.Fields = merge(l.fields, e.Fields)
+
+	l.sink.Write(e)
+}
+
+// merge returns a new map holding all fields, later ones taking precedence.
+func merge(base Fields, fields ...Fields) Fields {
+	n := len(base)
+	for _, f := range fields {
+		n += len(f)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	m := make(Fields, n)
+	for k, v := range base {
+		m[k] = v
+	}
+	for _, f := range fields {
+		for k, v := range f {
+			m[k] = v
+		}
+	}
+
+	return m
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger stored in ctx or Default if there is none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default
+}
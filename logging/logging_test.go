@@ -0,0 +1,233 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var at = time.Date(2020, 5, 21, 10, 32, 1, 500, time.UTC)
+
+func TestJSONSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		entry   Entry
+		want    string
+	}{
+		{
+			"plain",
+			"",
+			Entry{Time: at, Severity: Info, Message: "Booting"},
+			`{"message":"Booting","severity":"INFO","time":"2020-05-21T10:32:01.0000005Z"}`,
+		},
+		{
+			"fields",
+			"",
+			Entry{Time: at, Severity: Error, Message: "Failed", Fields: Fields{
+				"error":   errors.New("boom"),
+				"took":    1500 * time.Millisecond,
+				"order":   42,
+				"message": "overridden",
+			}},
+			`{"error":"boom","message":"Failed","order":42,"severity":"ERROR","time":"2020-05-21T10:32:01.0000005Z","took":"1.5s"}`,
+		},
+		{
+			"trace",
+			"",
+			Entry{Time: at, Severity: Debug, Message: "Traced", Trace: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"},
+			`{"logging.googleapis.com/spanId":"00f067aa0ba902b7","logging.googleapis.com/trace":"4bf92f3577b34da6a3ce929d0e0e4736","message":"Traced","severity":"DEBUG","time":"2020-05-21T10:32:01.0000005Z"}`,
+		},
+		{
+			"trace of project",
+			"my-project",
+			Entry{Time: at, Severity: Warning, Message: "Traced", Trace: "4bf92f3577b34da6a3ce929d0e0e4736"},
+			`{"logging.googleapis.com/trace":"projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736","message":"Traced","severity":"WARNING","time":"2020-05-21T10:32:01.0000005Z"}`,
+		},
+		{
+			"http request",
+			"",
+			Entry{Time: at, Severity: Info, Message: "GET /orders 200", HTTPRequest: &HTTPRequest{
+				Method:       "GET",
+				URL:          "/orders",
+				Status:       200,
+				ResponseSize: 12,
+				RemoteIP:     "10.0.0.1",
+				Latency:      25 * time.Millisecond,
+			}},
+			`{"httpRequest":{"latency":"0.025000000s","remoteIp":"10.0.0.1","requestMethod":"GET","requestUrl":"/orders","responseSize":"12","status":200},"message":"GET /orders 200","severity":"INFO","time":"2020-05-21T10:32:01.0000005Z"}`,
+		},
+		{
+			"unmarshallable fields",
+			"",
+			Entry{Time: at, Severity: Critical, Message: "Broken", Fields: Fields{"ch": make(chan int)}},
+			`{"loggingError":"failed to marshal fields (json: unsupported type: chan int)","message":"Broken","severity":"CRITICAL","time":"2020-05-21T10:32:01.0000005Z"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			sink := &JSONSink{W: &buf, ProjectID: tt.project}
+
+			sink.Write(&tt.entry)
+
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("wrote %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	tests := map[Severity]string{
+		Debug:        "DEBUG",
+		Info:         "INFO",
+		Warning:      "WARNING",
+		Error:        "ERROR",
+		Critical:     "CRITICAL",
+		Severity(42): "DEFAULT",
+	}
+
+	for s, want := range tests {
+		if s.String() != want {
+			t.Errorf("Severity(%d) = %s, want %s", s, s, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	l := New(&JSONSink{W: &buf}, Warning)
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Warning("warning")
+	l.Error("error")
+	l.Critical("critical")
+
+	var severities []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e struct{ Severity, Message string }
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid entry %s: %v", line, err)
+		}
+		if e.Severity != strings.ToUpper(e.Message) {
+			t.Errorf("%s logged as %s", e.Message, e.Severity)
+		}
+		severities = append(severities, e.Severity)
+	}
+
+	if got := strings.Join(severities, ","); got != "WARNING,ERROR,CRITICAL" {
+		t.Errorf("logged %s, want entries of at least WARNING", got)
+	}
+	if l.Enabled(Info) || !l.Enabled(Warning) {
+		t.Error("Enabled doesn't match the level")
+	}
+}
+
+// entries is a Sink keeping all entries.
+type entries []*Entry
+
+func (e *entries) Write(entry *Entry) {
+	*e = append(*e, entry)
+}
+
+func TestLoggerTraceAndFields(t *testing.T) {
+	var got entries
+	base := New(&got, Debug).With(Fields{"service": "orders", "order": 1})
+
+	traced := base.WithTrace("4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	traced.Info("Traced", Fields{"order": 2}, Fields{"step": "paid"})
+
+	// An entry's own trace wins over the logger's
+	traced.Write(&Entry{Severity: Info, Message: "Own trace", Trace: "other"})
+
+	base.Info("Untraced")
+
+	if len(got) != 3 {
+		t.Fatalf("logged %d entries, want 3", len(got))
+	}
+
+	e := got[0]
+	if e.Trace != "4bf92f3577b34da6a3ce929d0e0e4736" || e.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("entry linked to %s/%s, want the logger's trace", e.Trace, e.SpanID)
+	}
+	if e.Fields["service"] != "orders" || e.Fields["order"] != 2 || e.Fields["step"] != "paid" {
+		t.Errorf("entry fields = %v, want the logger's merged with its own", e.Fields)
+	}
+	if e.Time.IsZero() {
+		t.Error("entry has no time")
+	}
+
+	if got[1].Trace != "other" || got[1].SpanID != "" {
+		t.Errorf("entry with own trace linked to %s/%s", got[1].Trace, got[1].SpanID)
+	}
+	if got[2].Trace != "" {
+		t.Errorf("entry of untraced logger linked to %s", got[2].Trace)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Default {
+		t.Error("FromContext without logger isn't Default")
+	}
+
+	l := New(&entries{}, Debug)
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("FromContext doesn't return the stored logger")
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := &TextSink{W: &buf}
+
+	sink.Write(&Entry{
+		Time:     at,
+		Severity: Warning,
+		Message:  "Slow request",
+		Fields:   Fields{"service": "orders", "note": "took long", "empty": ""},
+		Trace:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		HTTPRequest: &HTTPRequest{
+			Method:  "GET",
+			URL:     "/orders",
+			Status:  200,
+			Latency: time.Second,
+		},
+	})
+
+	want := `2020/05/21 10:32:01 WARNING Slow request GET /orders 200 1s empty="" note="took long" service=orders trace=4bf92f3577b34da6a3ce929d0e0e4736` + "\n"
+	if buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestTraceFromRequest(t *testing.T) {
+	tests := []struct {
+		header, value string
+		trace, spanID string
+	}{
+		{"X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1", "105445aa7843bc8bf206b12000100000", "0000000000000001"},
+		{"X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/18446744073709551615", "105445aa7843bc8bf206b12000100000", "ffffffffffffffff"},
+		{"X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000", "105445aa7843bc8bf206b12000100000", ""},
+		{"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"traceparent", "00-4bf92f35-00f067aa0ba902b7-01", "", ""},
+		{"", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+
+		trace, spanID := TraceFromRequest(r)
+		if trace != tt.trace || spanID != tt.spanID {
+			t.Errorf("TraceFromRequest(%s: %s) = %s, %s, want %s, %s", tt.header, tt.value, trace, spanID, tt.trace, tt.spanID)
+		}
+	}
+}
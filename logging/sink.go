@@ -0,0 +1,138 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSONSink writes every entry as a single line of JSON in the format of Cloud Logging.
+// Fields become part of the entry's jsonPayload.
+type JSONSink struct {
+
+	// W the entries are written to, usually os.Stdout.
+	W io.Writer
+
+	// ProjectID qualifies trace IDs as Cloud Logging expects them. Traces are written
+	// as they are if it is not set.
+	ProjectID string
+
+	mu sync.Mutex
+}
+
+// Write e as JSON.
+func (s *JSONSink) Write(e *Entry) {
+	m := make(map[string]interface{}, len(e.Fields)+6)
+	for k, v := range e.Fields {
+		m[k] = jsonValue(v)
+	}
+
+	m["severity"] = e.Severity.String()
+	m["message"] = e.Message
+	m["time"] = e.Time.Format(time.RFC3339Nano)
+
+	if e.Trace != "" {
+		trace := e.Trace
+		if s.ProjectID != "" {
+			trace = fmt.Sprintf("projects/%s/traces/%s", s.ProjectID, e.Trace)
+		}
+		m["logging.googleapis.com/trace"] = trace
+	}
+	if e.SpanID != "" {
+		m["logging.googleapis.com/spanId"] = e.SpanID
+	}
+	if e.HTTPRequest != nil {
+		m["httpRequest"] = e.HTTPRequest.payload()
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+
+		// Fields which can't be marshalled must not swallow the entry.
+		b, _ = json.Marshal(map[string]interface{}{
+			"severity":     e.Severity.String(),
+			"message":      e.Message,
+			"time":         e.Time.Format(time.RFC3339Nano),
+			"loggingError": fmt.Sprintf("failed to marshal fields (%v)", err),
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.W.Write(append(b, '\n'))
+}
+
+// jsonValue turns values which don't marshal into something readable into strings.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	default:
+		return v
+	}
+}
+
+// TextSink writes every entry as a single line of text, for humans reading logs locally:
+//
+//	2020/05/21 10:32:01 INFO Booting my-service v1.0.0 (surfkit 1.10.0) service=my-service
+type TextSink struct {
+
+	// W the entries are written to, usually os.Stderr.
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// Write e as text.
+func (s *TextSink) Write(e *Entry) {
+	var b bytes.Buffer
+
+	b.WriteString(e.Time.Format("2006/01/02 15:04:05 "))
+	b.WriteString(e.Severity.String())
+	b.WriteByte(' ')
+	b.WriteString(e.Message)
+
+	if r := e.HTTPRequest; r != nil {
+		fmt.Fprintf(&b, " %s %s %d %s", r.Method, r.URL, r.Status, r.Latency)
+	}
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, textValue(e.Fields[k]))
+	}
+
+	if e.Trace != "" {
+		fmt.Fprintf(&b, " trace=%s", e.Trace)
+	}
+
+	b.WriteByte('\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.W.Write(b.Bytes())
+}
+
+// textValue formats v, quoting it if it would be ambiguous otherwise.
+func textValue(v interface{}) string {
+	str := fmt.Sprint(v)
+	if str == "" || strings.ContainsAny(str, " \t\n\"=") {
+		return strconv.Quote(str)
+	}
+
+	return str
+}
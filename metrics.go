@@ -0,0 +1,174 @@
+package surfkit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/helloink/surfkit/metrics"
+	"github.com/helloink/surfkit/transport"
+)
+
+// MetricsPath the metrics of a service are served on, if Service.Metrics is set.
+const MetricsPath = "/metrics"
+
+// unmatchedRoute labels requests to routes unknown to the service's router.
+const unmatchedRoute = "unmatched"
+
+// serviceMetrics are the metrics surfkit records on its own. A nil *serviceMetrics
+// records nothing, so callers don't need to check whether metrics are enabled.
+type serviceMetrics struct {
+	httpRequests *metrics.CounterVec
+	httpLatency  *metrics.HistogramVec
+
+	eventsReceived    *metrics.CounterVec
+	eventsAcked       *metrics.CounterVec
+	eventsNacked      *metrics.CounterVec
+	handlerLatency    *metrics.HistogramVec
+	unmarshalFailures *metrics.CounterVec
+
+	published      *metrics.CounterVec
+	publishErrors  *metrics.CounterVec
+	publishLatency *metrics.HistogramVec
+}
+
+func newServiceMetrics(r *metrics.Registry) *serviceMetrics {
+	return &serviceMetrics{
+		httpRequests: r.Counter("surfkit_http_requests_total", "HTTP requests served, by route template, method and status.", "route", "method", "status"),
+		httpLatency:  r.Histogram("surfkit_http_request_duration_seconds", "Time taken to serve HTTP requests.", nil, "route", "method"),
+
+		eventsReceived:    r.Counter("surfkit_events_received_total", "Events received, by subscription.", "subscription"),
+		eventsAcked:       r.Counter("surfkit_events_acked_total", "Events acknowledged, including poison messages.", "subscription"),
+		eventsNacked:      r.Counter("surfkit_events_nacked_total", "Events not acknowledged, to be redelivered.", "subscription"),
+		handlerLatency:    r.Histogram("surfkit_event_handler_duration_seconds", "Time taken by event handlers.", nil, "subscription"),
+		unmarshalFailures: r.Counter("surfkit_events_unmarshal_failures_total", "Messages which couldn't be decoded into CloudEvents.", "subscription"),
+
+		published:      r.Counter("surfkit_events_published_total", "Events sent to outputs, including failed ones.", "output"),
+		publishErrors:  r.Counter("surfkit_events_publish_errors_total", "Events which failed to be published, by output.", "output"),
+		publishLatency: r.Histogram("surfkit_events_publish_duration_seconds", "Time taken to publish events.", nil, "output"),
+	}
+}
+
+// setupMetrics records the built-in metrics and serves them, if the service has a registry.
+func setupMetrics(s *Service) {
+	if s.Metrics == nil {
+		return
+	}
+
+	s.instruments = newServiceMetrics(s.Metrics)
+	s.Router.Handle(MetricsPath, s.Metrics).Methods("GET")
+}
+
+// handled records an event handled on subscription.
+func (m *serviceMetrics) handled(subscription string, o outcome, took time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.eventsReceived.With(subscription).Inc()
+	m.handlerLatency.With(subscription).ObserveDuration(took)
+
+	if o.ack {
+		m.eventsAcked.With(subscription).Inc()
+	} else {
+		m.eventsNacked.With(subscription).Inc()
+	}
+}
+
+// unmarshalFailed records a message received on subscription which isn't a CloudEvent.
+func (m *serviceMetrics) unmarshalFailed(subscription string) {
+	if m == nil {
+		return
+	}
+
+	m.unmarshalFailures.With(subscription).Inc()
+}
+
+// instrumentHTTP records every request served by next, labelled with the template of the
+// route of s.Router it matches.
+func instrumentHTTP(s *Service, next http.Handler) http.Handler {
+	if s.instruments == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := routeTemplate(s, r)
+		s.instruments.httpRequests.With(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		s.instruments.httpLatency.With(route, r.Method).ObserveDuration(time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushing on to the underlying writer, if it supports it.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes hijacking on to the underlying writer, e.g. for websockets.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", r.ResponseWriter)
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
+
+// sender returns a Sender recording every publish to output.
+func (m *serviceMetrics) sender(next transport.Sender, output string) transport.Sender {
+	if m == nil {
+		return next
+	}
+
+	return &instrumentedSender{Sender: next, output: output, metrics: m}
+}
+
+type instrumentedSender struct {
+	transport.Sender
+	output  string
+	metrics *serviceMetrics
+}
+
+func (i *instrumentedSender) Publish(ctx context.Context, topic string, m *transport.Message) transport.PublishResult {
+	start := time.Now()
+	r := i.Sender.Publish(ctx, topic, m)
+
+	go func() {
+		<-r.Ready()
+		_, err := r.Get(context.Background())
+
+		i.metrics.published.With(i.output).Inc()
+		i.metrics.publishLatency.With(i.output).ObserveDuration(time.Since(start))
+		if err != nil {
+			i.metrics.publishErrors.With(i.output).Inc()
+		}
+	}()
+
+	return r
+}
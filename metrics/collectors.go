@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	f *family
+}
+
+// With returns the counter of the given label values, in the order the labels were registered.
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.f.with(values)}
+}
+
+// A Counter only goes up, e.g. to count requests.
+type Counter struct {
+	s *series
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter can't decrease by %v", v))
+	}
+
+	c.s.mu.Lock()
+	c.s.value += v
+	c.s.mu.Unlock()
+}
+
+// A GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	f *family
+}
+
+// With returns the gauge of the given label values, in the order the labels were registered.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.f.with(values)}
+}
+
+// A Gauge goes up and down, e.g. to track requests in flight.
+type Gauge struct {
+	s *series
+}
+
+// Set the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.s.mu.Lock()
+	g.s.value = v
+	g.s.mu.Unlock()
+}
+
+// Add v to the gauge, which may be negative.
+func (g *Gauge) Add(v float64) {
+	g.s.mu.Lock()
+	g.s.value += v
+	g.s.mu.Unlock()
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// A HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	f *family
+}
+
+// With returns the histogram of the given label values, in the order the labels were registered.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{s: v.f.with(values), buckets: v.f.buckets}
+}
+
+// A Histogram counts observations in buckets, e.g. to track latencies.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// Observe adds v to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.s.mu.Lock()
+	if i < len(h.buckets) {
+		h.s.counts[i]++
+	}
+	h.s.sum += v
+	h.s.count++
+	h.s.mu.Unlock()
+}
+
+// ObserveDuration adds the seconds d took to the histogram.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
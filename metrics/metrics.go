@@ -0,0 +1,297 @@
+// Package metrics records counters, gauges and histograms and exposes them in the
+// Prometheus text format. See https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+//	registry := metrics.NewRegistry()
+//	orders := registry.Counter("orders_total", "Orders placed.", "country")
+//
+//	orders.With("de").Inc()
+//
+// A Registry set as Service.Metrics records surfkit's built-in metrics as well and is
+// served on /metrics.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets suiting latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	validName  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	validLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+// A Registry holds metrics. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Counter returns the counter of the given name, registering it unless it exists already.
+// It panics if the name is taken by a different metric or if a name is invalid.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, counterKind, nil, labels)}
+}
+
+// Gauge returns the gauge of the given name, registering it unless it exists already.
+// It panics if the name is taken by a different metric or if a name is invalid.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, gaugeKind, nil, labels)}
+}
+
+// Histogram returns the histogram of the given name, registering it unless it exists already.
+// Buckets are the sorted upper bounds of the buckets, DefaultBuckets if nil.
+// It panics if the name is taken by a different metric or if a name is invalid.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	for _, l := range labels {
+		if l == "le" {
+			panic(fmt.Sprintf("metrics: histogram %s can't have a label le", name))
+		}
+	}
+
+	return &HistogramVec{r.register(name, help, histogramKind, buckets, labels)}
+}
+
+func (r *Registry) register(name, help string, k kind, buckets []float64, labels []string) *family {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labels {
+		if !validLabel.MatchString(l) || strings.HasPrefix(l, "__") {
+			panic(fmt.Sprintf("metrics: invalid label name %q of %s", l, name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s is registered as %s with labels %v already", name, f.kind, f.labels))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.families[name] = f
+
+	return f
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves all metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// A family is a metric with all its labelled series.
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// with returns the series of the given label values, creating it if needed.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == histogramKind {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+func (f *family) write(w *countingWriter) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	all := make([]*series, len(keys))
+	for i, k := range keys {
+		all[i] = f.series[k]
+	}
+	f.mu.Unlock()
+
+	if len(all) == 0 {
+		return
+	}
+
+	w.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+	w.printf("# TYPE %s %s\n", f.name, f.kind)
+
+	for _, s := range all {
+		s.mu.Lock()
+
+		if f.kind != histogramKind {
+			w.printf("%s%s %s\n", f.name, labelString(f.labels, s.values, "", ""), formatFloat(s.value))
+			s.mu.Unlock()
+			continue
+		}
+
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i]
+			w.printf("%s_bucket%s %d\n", f.name, labelString(f.labels, s.values, "le", formatFloat(upper)), cumulative)
+		}
+		w.printf("%s_bucket%s %d\n", f.name, labelString(f.labels, s.values, "le", "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", f.name, labelString(f.labels, s.values, "", ""), formatFloat(s.sum))
+		w.printf("%s_count%s %d\n", f.name, labelString(f.labels, s.values, "", ""), s.count)
+
+		s.mu.Unlock()
+	}
+}
+
+// A series is the state of a metric for a single set of label values.
+type series struct {
+	values []string
+
+	mu sync.Mutex
+
+	// value of counters and gauges.
+	value float64
+
+	// counts per bucket, sum and count of histograms.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// labelString formats the labels as {name="value",...}, adding extra if set.
+func labelString(names, values []string, extra, extraValue string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra, extraValue))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// countingWriter remembers the first error and how much has been written.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
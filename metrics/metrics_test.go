@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	orders := r.Counter("orders_total", "Orders placed.", "country", "note")
+	orders.With("de", "").Inc()
+	orders.With("de", "").Add(2)
+	orders.With("fr", `say "hi"`+"\n"+`C:\orders`).Inc()
+
+	inflight := r.Gauge("requests_in_flight", "Requests in flight,\nby \\ nothing.")
+	inflight.With().Inc()
+	inflight.With().Inc()
+	inflight.With().Dec()
+
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 0.5, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		latency.With("/orders").Observe(v)
+	}
+	latency.With("/health").ObserveDuration(250 * time.Millisecond)
+
+	// Families without series are left out
+	r.Counter("unused_total", "Never used.")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/health",le="0.1"} 0
+latency_seconds_bucket{route="/health",le="0.5"} 1
+latency_seconds_bucket{route="/health",le="1"} 1
+latency_seconds_bucket{route="/health",le="+Inf"} 1
+latency_seconds_sum{route="/health"} 0.25
+latency_seconds_count{route="/health"} 1
+latency_seconds_bucket{route="/orders",le="0.1"} 2
+latency_seconds_bucket{route="/orders",le="0.5"} 3
+latency_seconds_bucket{route="/orders",le="1"} 4
+latency_seconds_bucket{route="/orders",le="+Inf"} 5
+latency_seconds_sum{route="/orders"} 3.15
+latency_seconds_count{route="/orders"} 5
+# HELP orders_total Orders placed.
+# TYPE orders_total counter
+orders_total{country="de",note=""} 3
+orders_total{country="fr",note="say \"hi\"\nC:\\orders"} 1
+# HELP requests_in_flight Requests in flight,\nby \\ nothing.
+# TYPE requests_in_flight gauge
+requests_in_flight 1
+`
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if buf.String() != want {
+		t.Errorf("WriteTo wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("served as %s, want the Prometheus text format", ct)
+	}
+	if w.Body.String() != want {
+		t.Errorf("served\n%s\nwant\n%s", w.Body.String(), want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := map[float64]string{
+		0:            "0",
+		1:            "1",
+		0.005:        "0.005",
+		2.5:          "2.5",
+		1e21:         "1e+21",
+		-3:           "-3",
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+		math.NaN():   "NaN",
+		1.0 / 3.0:    "0.3333333333333333",
+	}
+
+	for f, want := range tests {
+		if got := formatFloat(f); got != want {
+			t.Errorf("formatFloat(%v) = %s, want %s", f, got, want)
+		}
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	tests := map[string]func(r *Registry){
+		"invalid name":       func(r *Registry) { r.Counter("orders-total", "") },
+		"invalid label":      func(r *Registry) { r.Counter("orders_total", "", "the country") },
+		"reserved label":     func(r *Registry) { r.Counter("orders_total", "", "__name") },
+		"le label":           func(r *Registry) { r.Histogram("latency_seconds", "", nil, "le") },
+		"unsorted buckets":   func(r *Registry) { r.Histogram("latency_seconds", "", []float64{1, 0.5}) },
+		"other kind":         func(r *Registry) { r.Counter("x", ""); r.Gauge("x", "") },
+		"other labels":       func(r *Registry) { r.Counter("x", "", "a"); r.Counter("x", "", "b") },
+		"missing values":     func(r *Registry) { r.Counter("x", "", "a", "b").With("1") },
+		"decreasing counter": func(r *Registry) { r.Counter("x", "").With().Add(-1) },
+	}
+
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("didn't panic")
+				}
+			}()
+
+			fn(NewRegistry())
+		})
+	}
+
+	// Registering the same metric again returns it
+	r := NewRegistry()
+	r.Counter("x", "", "a").With("1").Inc()
+	r.Counter("x", "", "a").With("1").Inc()
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `x{a="1"} 2`) {
+		t.Errorf("wrote %s, want the counter incremented twice", buf.String())
+	}
+}
@@ -0,0 +1,182 @@
+package surfkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/helloink/surfkit/metrics"
+	"github.com/helloink/surfkit/transport"
+)
+
+func TestInstrumentHTTP(t *testing.T) {
+	s := &Service{Router: mux.NewRouter(), Metrics: metrics.NewRegistry()}
+	setupMetrics(s)
+
+	s.Router.HandleFunc("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("response writer isn't a http.Flusher")
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		f.Flush()
+	})
+	s.Router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("response writer isn't a http.Hijacker")
+			return
+		}
+
+		conn, rw, err := h.Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})
+
+	srv := httptest.NewServer(instrumentHTTP(s, s.Router))
+	defer srv.Close()
+
+	for _, path := range []string{"/orders/1", "/orders/2", "/missing"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read the upgrade: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("upgrade = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	// The handler records its metrics after the hijacked connection is closed
+	time.Sleep(50 * time.Millisecond)
+
+	assertMetrics(t, s.Metrics,
+		`surfkit_http_requests_total{route="/orders/{id}",method="GET",status="202"} 2`,
+		`surfkit_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`surfkit_http_requests_total{route="/ws",method="GET",status="101"} 1`,
+		`surfkit_http_request_duration_seconds_count{route="/orders/{id}",method="GET"} 2`,
+		`surfkit_http_request_duration_seconds_bucket{route="unmatched",method="GET",le="+Inf"} 1`,
+	)
+}
+
+func TestServiceMetricsEvents(t *testing.T) {
+	r := metrics.NewRegistry()
+	m := newServiceMetrics(r)
+
+	m.handled("orders", outcome{ack: true}, 20*time.Millisecond)
+	m.handled("orders", outcome{ack: false}, 3*time.Second)
+	m.unmarshalFailed("orders")
+
+	b := transport.NewMemory()
+	defer b.Close()
+	if err := b.EnsureTopic(context.Background(), "orders.placed"); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := m.sender(b, "order.placed")
+	for _, topic := range []string{"orders.placed", "missing"} {
+		res := sender.Publish(context.Background(), topic, &transport.Message{})
+		<-res.Ready()
+	}
+
+	// The publish metrics are recorded in the background
+	for start := time.Now(); !strings.Contains(writeMetrics(t, r), `surfkit_events_publish_duration_seconds_count{output="order.placed"} 2`); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			break
+		}
+	}
+
+	assertMetrics(t, r,
+		`surfkit_events_received_total{subscription="orders"} 2`,
+		`surfkit_events_acked_total{subscription="orders"} 1`,
+		`surfkit_events_nacked_total{subscription="orders"} 1`,
+		`surfkit_events_unmarshal_failures_total{subscription="orders"} 1`,
+		`surfkit_event_handler_duration_seconds_bucket{subscription="orders",le="0.025"} 1`,
+		`surfkit_event_handler_duration_seconds_bucket{subscription="orders",le="2.5"} 1`,
+		`surfkit_event_handler_duration_seconds_bucket{subscription="orders",le="5"} 2`,
+		`surfkit_event_handler_duration_seconds_sum{subscription="orders"} 3.02`,
+		`surfkit_events_published_total{output="order.placed"} 2`,
+		`surfkit_events_publish_errors_total{output="order.placed"} 1`,
+		`# TYPE surfkit_events_publish_duration_seconds histogram`,
+	)
+
+	// Without registry, nothing is recorded
+	var none *serviceMetrics
+	none.handled("orders", outcome{}, time.Second)
+	none.unmarshalFailed("orders")
+	if none.sender(b, "order.placed") != transport.Sender(b) {
+		t.Error("sender is instrumented without registry")
+	}
+}
+
+func TestStatusRecorderHijackUnsupported(t *testing.T) {
+	rec := &statusRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+
+	if _, _, err := rec.Hijack(); err == nil {
+		t.Error("Hijack of a writer which doesn't support it succeeded")
+	}
+	if rec.status != http.StatusOK {
+		t.Errorf("status = %d after a failed hijack, want %d", rec.status, http.StatusOK)
+	}
+
+	// Flushing a writer which can't flush does nothing
+	rec.Flush()
+}
+
+func writeMetrics(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	return buf.String()
+}
+
+// assertMetrics fails unless the metrics of r contain all lines.
+func assertMetrics(t *testing.T, r *metrics.Registry, lines ...string) {
+	t.Helper()
+
+	out := writeMetrics(t, r)
+	have := make(map[string]bool)
+	for _, l := range strings.Split(out, "\n") {
+		have[l] = true
+	}
+
+	for _, l := range lines {
+		if !have[l] {
+			t.Errorf("metrics lack %s", l)
+		}
+	}
+	if t.Failed() {
+		t.Logf("metrics:\n%s", out)
+	}
+}
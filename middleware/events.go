@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// LogEvents is an event middleware logging every handled event together with its outcome
+// and how long the handler took.
+func LogEvents(next surfkit.EventHandler) surfkit.EventHandler {
+	return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+		start := time.Now()
+		err := next(ctx, s, e)
+		took := time.Since(start)
+
+		subscription := ""
+		if d := surfkit.DeliveryFromContext(ctx); d != nil {
+			subscription = d.Subscription
+		}
+
+		logger := logging.FromContext(ctx)
+		if err != nil {
+			logger.Warning(fmt.Sprintf("Event %s (%s) on %s failed after %s", e.ID, e.Type, subscription, took), logging.Fields{
+				"latency": took,
+				"error":   err,
+			})
+		} else {
+			logger.Info(fmt.Sprintf("Event %s (%s) on %s handled in %s", e.ID, e.Type, subscription, took), logging.Fields{
+				"latency": took,
+			})
+		}
+
+		return err
+	}
+}
+
+// RecoverEvents is an event middleware turning a panicking handler into a *surfkit.Panic
+// error, so the middleware around it sees the failure. Once the error is returned to surfkit,
+// the panic is logged and reported to Service.OnPanic, just like the panics surfkit recovers
+// on its own.
+func RecoverEvents(next surfkit.EventHandler) surfkit.EventHandler {
+	return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) (err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &surfkit.Panic{Value: v, Stack: debug.Stack(), Event: e, Delivery: surfkit.DeliveryFromContext(ctx)}
+			}
+		}()
+
+		return next(ctx, s, e)
+	}
+}
+
+// Timing returns an event middleware reporting how long the handler took for every event,
+// e.g. to record it as metric.
+func Timing(observe func(ctx context.Context, e *events.CloudEvent, took time.Duration, err error)) surfkit.EventMiddleware {
+	return func(next surfkit.EventHandler) surfkit.EventHandler {
+		return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			start := time.Now()
+			err := next(ctx, s, e)
+			observe(ctx, e, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// ValidateSchema returns an event middleware checking the data of every event against the
+// schema registered for the event's `dataschema` or, if there is none, for its type. Events
+// failing validation are dropped as poison messages, events without schema pass unchecked.
+func ValidateSchema(schemas map[string]*events.Schema) surfkit.EventMiddleware {
+	return func(next surfkit.EventHandler) surfkit.EventHandler {
+		return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			schema, ok := schemas[e.DataSchema]
+			if !ok || e.DataSchema == "" {
+				schema, ok = schemas[e.Type]
+			}
+
+			if ok {
+				err := schema.ValidateData(e)
+				if err != nil {
+					return surfkit.Poison(fmt.Errorf("invalid event %s (%v)", e.ID, err))
+				}
+			}
+
+			return next(ctx, s, e)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/middleware"
+	"github.com/helloink/surfkit/surfkittest"
+)
+
+// recorder is a logging.Sink keeping all entries.
+type recorder struct {
+	mu      sync.Mutex
+	entries []*logging.Entry
+}
+
+func (r *recorder) Write(e *logging.Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, e)
+}
+
+func (r *recorder) Entries() []*logging.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*logging.Entry(nil), r.entries...)
+}
+
+func handlerReturning(err error) surfkit.EventHandler {
+	return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+		return err
+	}
+}
+
+func TestLogEvents(t *testing.T) {
+	tests := []struct {
+		err      error
+		severity logging.Severity
+		message  string
+	}{
+		{nil, logging.Info, "Event 1 (order.placed) on orders handled in"},
+		{surfkit.ErrNack, logging.Warning, "Event 1 (order.placed) on orders failed after"},
+	}
+
+	for _, tt := range tests {
+		rec := &recorder{}
+		ctx := logging.NewContext(context.Background(), logging.New(rec, logging.Debug))
+		ctx = surfkit.WithDelivery(ctx, &surfkit.Delivery{Subscription: "orders"})
+
+		e := &events.CloudEvent{ID: "1", Type: "order.placed"}
+		if err := middleware.LogEvents(handlerReturning(tt.err))(ctx, nil, e); err != tt.err {
+			t.Errorf("LogEvents returned %v, want %v", err, tt.err)
+		}
+
+		entries := rec.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("%d entries logged, want 1", len(entries))
+		}
+		if entries[0].Severity != tt.severity || !strings.HasPrefix(entries[0].Message, tt.message) {
+			t.Errorf("logged %s %q, want %s %q", entries[0].Severity, entries[0].Message, tt.severity, tt.message)
+		}
+		if _, ok := entries[0].Fields["latency"]; !ok {
+			t.Error("entry lacks the latency")
+		}
+	}
+}
+
+func TestRecoverEvents(t *testing.T) {
+	var outer error
+	observe := func(next surfkit.EventHandler) surfkit.EventHandler {
+		return func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			outer = next(ctx, s, e)
+			return outer
+		}
+	}
+
+	var mu sync.Mutex
+	var reported []*surfkit.Panic
+
+	s := &surfkit.Service{
+		Name:   "orders",
+		Logger: logging.New(&logging.TextSink{W: ioutil.Discard}, logging.Critical),
+		Subscriptions: []surfkit.Subscription{&surfkit.PullSubscription{
+			Name:       "orders",
+			Topic:      "orders.placed",
+			Middleware: []surfkit.EventMiddleware{observe, middleware.RecoverEvents},
+			Handler: func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+				panic("boom")
+			},
+		}},
+		OnPanic: func(p *surfkit.Panic) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, p)
+		},
+	}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	acked, err := h.Inject("orders", events.NewCloudEvent("test", "order.placed", nil))
+	if err != nil || acked {
+		t.Fatalf("Inject = %v, %v, want a nack", acked, err)
+	}
+
+	p, ok := outer.(*surfkit.Panic)
+	if !ok || p.Value != "boom" {
+		t.Fatalf("outer middleware saw %v, want the panic", outer)
+	}
+	if !strings.Contains(string(p.Stack), "panic") || p.Event == nil || p.Delivery == nil || p.Delivery.Subscription != "orders" {
+		t.Errorf("panic lacks its stack, event or delivery: %+v", p)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(reported) != 1 || reported[0] != p {
+		t.Errorf("OnPanic got %v, want the recovered panic once", reported)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	var took time.Duration
+	var observed error
+
+	h := middleware.Timing(func(ctx context.Context, e *events.CloudEvent, d time.Duration, err error) {
+		took, observed = d, err
+	})(func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+		time.Sleep(5 * time.Millisecond)
+		return surfkit.ErrNack
+	})
+
+	if err := h(context.Background(), nil, &events.CloudEvent{}); err != surfkit.ErrNack {
+		t.Errorf("Timing returned %v, want ErrNack", err)
+	}
+	if observed != surfkit.ErrNack || took < 5*time.Millisecond {
+		t.Errorf("observed %v after %v, want ErrNack after at least 5ms", observed, took)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	byType, err := events.ParseSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bySchema, err := events.ParseSchema([]byte(`{"type": "object", "required": ["number"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := middleware.ValidateSchema(map[string]*events.Schema{
+		"order.placed":                      byType,
+		"https://example.com/order.v2.json": bySchema,
+	})(handlerReturning(nil))
+
+	tests := []struct {
+		name   string
+		typ    string
+		schema string
+		data   map[string]string
+		poison bool
+	}{
+		{name: "valid", typ: "order.placed", data: map[string]string{"id": "1"}},
+		{name: "invalid", typ: "order.placed", data: map[string]string{"number": "1"}, poison: true},
+		{name: "dataschema first", typ: "order.placed", schema: "https://example.com/order.v2.json", data: map[string]string{"number": "1"}},
+		{name: "dataschema invalid", typ: "order.placed", schema: "https://example.com/order.v2.json", data: map[string]string{"id": "1"}, poison: true},
+		{name: "unknown dataschema", typ: "order.placed", schema: "https://example.com/unknown.json", data: map[string]string{"number": "1"}, poison: true},
+		{name: "no schema", typ: "order.cancelled", data: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		e := events.NewCloudEvent("test", tt.typ, tt.data)
+		e.DataSchema = tt.schema
+
+		err := h(context.Background(), nil, &e)
+		_, poison := err.(*surfkit.PoisonError)
+		if poison != tt.poison || (!tt.poison && err != nil) {
+			t.Errorf("%s: error = %v, want poison %v", tt.name, err, tt.poison)
+		}
+	}
+}
+
+func TestValidateSchemaNotJSON(t *testing.T) {
+	schema, err := events.ParseSchema([]byte(`{"type": "object"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := middleware.ValidateSchema(map[string]*events.Schema{"order.placed": schema})(func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+		return errors.New("handler called")
+	})
+
+	e := events.NewCloudEvent("test", "order.placed", nil)
+	e.DataContentType = "text/plain"
+	e.Data = "not json"
+
+	if _, ok := h(context.Background(), nil, &e).(*surfkit.PoisonError); !ok {
+		t.Error("data which isn't JSON was not poisoned")
+	}
+}
@@ -1,14 +1,92 @@
 package middleware
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/helloink/surfkit/logging"
 )
 
 // Logging Middleware that uses the Apache Common Log Format.
 // Under the hood: https://godoc.org/github.com/gorilla/handlers#LoggingHandler
+//
+// Deprecated: Use LogRequests, which logs through the service's Logger.
 func Logging(next http.Handler) http.Handler {
 	return handlers.LoggingHandler(os.Stdout, next)
 }
+
+// LogRequests is a middleware logging every request with the request scoped logger surfkit
+// provides, see logging.FromContext. Entries carry the request details in Cloud Logging's
+// httpRequest format. Server errors are logged with severity Error, client errors with Warning.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		req := logging.NewHTTPRequest(r)
+		req.Status = rec.status
+		req.ResponseSize = rec.size
+		req.Latency = time.Since(start)
+
+		severity := logging.Info
+		switch {
+		case rec.status >= 500:
+			severity = logging.Error
+		case rec.status >= 400:
+			severity = logging.Warning
+		}
+
+		logging.FromContext(r.Context()).Write(&logging.Entry{
+			Severity:    severity,
+			Message:     fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.status),
+			HTTPRequest: req,
+		})
+	})
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush passes flushing on to the underlying writer, if it supports it.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes hijacking on to the underlying writer, e.g. for websockets.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T doesn't support hijacking", r.ResponseWriter)
+	}
+
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, err
+}
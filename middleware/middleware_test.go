@@ -0,0 +1,121 @@
+package middleware_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/middleware"
+)
+
+func TestLogRequests(t *testing.T) {
+	tests := []struct {
+		status   int
+		severity logging.Severity
+	}{
+		{http.StatusOK, logging.Info},
+		{http.StatusNotFound, logging.Warning},
+		{http.StatusBadGateway, logging.Error},
+	}
+
+	for _, tt := range tests {
+		rec := &recorder{}
+
+		h := middleware.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte("hello"))
+		}))
+
+		r := httptest.NewRequest("GET", "/orders?page=2", nil)
+		r = r.WithContext(logging.NewContext(context.Background(), logging.New(rec, logging.Debug)))
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status || w.Body.String() != "hello" {
+			t.Errorf("response = %d %q, want %d hello", w.Code, w.Body.String(), tt.status)
+		}
+
+		entries := rec.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("%d entries logged, want 1", len(entries))
+		}
+
+		e := entries[0]
+		if e.Severity != tt.severity {
+			t.Errorf("%d logged with %s, want %s", tt.status, e.Severity, tt.severity)
+		}
+		if e.HTTPRequest == nil || e.HTTPRequest.Status != tt.status || e.HTTPRequest.ResponseSize != 5 || e.HTTPRequest.Method != "GET" {
+			t.Errorf("request details = %+v", e.HTTPRequest)
+		}
+	}
+}
+
+func TestLogRequestsFlush(t *testing.T) {
+	h := middleware.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("response writer is no http.Flusher")
+		}
+		w.Write([]byte("chunk"))
+		f.Flush()
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(logging.NewContext(context.Background(), logging.New(&recorder{}, logging.Debug)))
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if !w.Flushed {
+		t.Error("flush was not passed on")
+	}
+}
+
+func TestLogRequestsHijack(t *testing.T) {
+	rec := &recorder{}
+	logged := logging.New(rec, logging.Debug)
+
+	h := middleware.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("response writer is no http.Hijacker")
+			return
+		}
+
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), logged)))
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read the upgrade: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("upgrade = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+}
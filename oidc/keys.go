@@ -0,0 +1,249 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultKeysMaxAge is used if the keys response doesn't tell how long to cache it.
+const defaultKeysMaxAge = time.Hour
+
+// minRefresh limits how often unknown key IDs cause the keys to be fetched again.
+const minRefresh = time.Minute
+
+// A KeySet provides the public keys tokens are verified with.
+type KeySet interface {
+
+	// Key returns the key with the given ID.
+	Key(ctx context.Context, keyID string) (*rsa.PublicKey, error)
+}
+
+// StaticKeys is a fixed KeySet. A key stored under the empty ID is used for
+// every ID not found otherwise.
+type StaticKeys map[string]*rsa.PublicKey
+
+// Key returns the key with the given ID.
+func (k StaticKeys) Key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	if key, ok := k[keyID]; ok {
+		return key, nil
+	}
+	if key, ok := k[""]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %q", keyID)
+}
+
+// ReadKeyFile loads keys from a local file, which allows to verify tokens offline.
+// The file holds either a JSON Web Key Set or a PEM encoded public key or certificate.
+// A PEM key is used regardless of the key ID of a token.
+func ReadKeyFile(path string) (StaticKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file (%v)", err)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(b)), "{") {
+		return parseJWKS(b)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+
+	key, err := parsePEMKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s (%v)", path, err)
+	}
+
+	return StaticKeys{"": key}, nil
+}
+
+// RemoteKeys fetches a JSON Web Key Set from URL and caches it as long as the response allows.
+type RemoteKeys struct {
+
+	// URL of the JSON Web Key Set.
+	URL string
+
+	// Client fetches the keys. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    StaticKeys
+	fetched time.Time
+	expires time.Time
+}
+
+var googleKeys = &RemoteKeys{URL: GoogleKeysURL}
+
+// Key returns the key with the given ID, fetching the keys if they are outdated or
+// the ID is unknown, as happens after Google rotated its keys.
+func (r *RemoteKeys) Key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	key, known := r.keys[keyID]
+	if known && now.Before(r.expires) {
+		return key, nil
+	}
+
+	if !known && r.keys != nil && now.Sub(r.fetched) < minRefresh && now.Before(r.expires) {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	err := r.fetch(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	key, known = r.keys[keyID]
+	if !known {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+
+	return key, nil
+}
+
+// fetch must be called with r.mu held.
+func (r *RemoteKeys) fetch(ctx context.Context, now time.Time) error {
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to fetch keys (%v)", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch keys (status %d)", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to fetch keys (%v)", err)
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return err
+	}
+
+	r.keys = keys
+	r.fetched = now
+	r.expires = now.Add(maxAge(resp.Header.Get("Cache-Control")))
+
+	return nil
+}
+
+// maxAge reads the max-age directive of a Cache-Control header.
+func maxAge(cacheControl string) time.Duration {
+	for _, d := range strings.Split(cacheControl, ",") {
+		d = strings.TrimSpace(d)
+		if !strings.HasPrefix(d, "max-age=") {
+			continue
+		}
+
+		s, err := strconv.Atoi(strings.TrimPrefix(d, "max-age="))
+		if err == nil && s > 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+
+	return defaultKeysMaxAge
+}
+
+func parseJWKS(b []byte) (StaticKeys, error) {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := json.Unmarshal(b, &set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key set (%v)", err)
+	}
+
+	keys := make(StaticKeys)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s (%v)", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.E, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s (%v)", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RSA keys in key set")
+	}
+
+	return keys, nil
+}
+
+func parsePEMKey(block *pem.Block) (*rsa.PublicKey, error) {
+	var pub interface{}
+	var err error
+
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+
+	return key, nil
+}
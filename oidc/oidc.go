@@ -0,0 +1,172 @@
+// Package oidc verifies the OpenID Connect tokens Google attaches to authenticated
+// Pubsub push requests.
+// See https://cloud.google.com/pubsub/docs/push#authentication_and_authorization
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GoogleKeysURL serves the keys Google signs its OIDC tokens with, as JSON Web Key Set.
+const GoogleKeysURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// GoogleIssuers are the issuers of tokens signed by Google.
+var GoogleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// leeway accepted for clock skew when checking token lifetimes.
+const leeway = time.Minute
+
+// Claims of a Google signed token as far as they are relevant to surfkit.
+type Claims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	IssuedAt      int64  `json:"iat"`
+	Expiry        int64  `json:"exp"`
+}
+
+// A Verifier checks tokens for a valid signature, issuer, audience and email.
+type Verifier struct {
+
+	// Audience the token must be issued for.
+	Audience string
+
+	// Email of the service account the token must be issued to. Not checked if empty.
+	Email string
+
+	// Issuers of which one must have issued the token. Defaults to GoogleIssuers.
+	Issuers []string
+
+	// Keys the token signature is checked against. Defaults to Google's keys.
+	Keys KeySet
+}
+
+// Verify checks the token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token header (%v)", err)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", header.Alg)
+	}
+
+	keys := v.Keys
+	if keys == nil {
+		keys = googleKeys
+	}
+
+	key, err := keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature (%v)", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var c Claims
+	err = decodeSegment(parts[1], &c)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims (%v)", err)
+	}
+
+	return &c, v.check(&c, time.Now())
+}
+
+func (v *Verifier) check(c *Claims, now time.Time) error {
+	issuers := v.Issuers
+	if issuers == nil {
+		issuers = GoogleIssuers
+	}
+
+	known := false
+	for _, iss := range issuers {
+		if c.Issuer == iss {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("unexpected issuer %q", c.Issuer)
+	}
+
+	if c.Audience != v.Audience {
+		return fmt.Errorf("unexpected audience %q", c.Audience)
+	}
+
+	if v.Email != "" && (c.Email != v.Email || !c.EmailVerified) {
+		return fmt.Errorf("unexpected email %q", c.Email)
+	}
+
+	if now.Add(-leeway).Unix() >= c.Expiry {
+		return errors.New("token expired")
+	}
+
+	if now.Add(leeway).Unix() < c.IssuedAt {
+		return errors.New("token issued in the future")
+	}
+
+	return nil
+}
+
+// Sign creates an RS256 signed token holding c. Verifiers find the key by keyID.
+// Google signs the tokens of push requests, Sign is meant for tests and local setups.
+func Sign(key *rsa.PrivateKey, keyID string, c Claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
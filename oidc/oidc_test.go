@@ -0,0 +1,390 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAudience = "https://orders.example.com/sk/v1/push/orders"
+	testEmail    = "pusher@my-project.iam.gserviceaccount.com"
+)
+
+var (
+	keysOnce          sync.Once
+	testKey, otherKey *rsa.PrivateKey
+	keysErr           error
+)
+
+// keys returns two RSA keys generated once per test run.
+func keys(t *testing.T) (*rsa.PrivateKey, *rsa.PrivateKey) {
+	t.Helper()
+
+	keysOnce.Do(func() {
+		testKey, keysErr = rsa.GenerateKey(rand.Reader, 2048)
+		if keysErr == nil {
+			otherKey, keysErr = rsa.GenerateKey(rand.Reader, 2048)
+		}
+	})
+	if keysErr != nil {
+		t.Fatalf("failed to generate keys: %v", keysErr)
+	}
+
+	return testKey, otherKey
+}
+
+func validClaims() Claims {
+	now := time.Now()
+
+	return Claims{
+		Issuer:        "https://accounts.google.com",
+		Audience:      testAudience,
+		Subject:       "1234567890",
+		Email:         testEmail,
+		EmailVerified: true,
+		IssuedAt:      now.Unix(),
+		Expiry:        now.Add(time.Hour).Unix(),
+	}
+}
+
+// unsignedToken returns a token with the given header and claims, signed by sign.
+func unsignedToken(t *testing.T, header map[string]string, c Claims, sign func(signed string) []byte) string {
+	t.Helper()
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(b)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func TestVerify(t *testing.T) {
+	key, other := keys(t)
+
+	sign := func(key *rsa.PrivateKey, keyID string, modify func(c *Claims)) func(t *testing.T) string {
+		return func(t *testing.T) string {
+			c := validClaims()
+			if modify != nil {
+				modify(&c)
+			}
+
+			token, err := Sign(key, keyID, c)
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+			return token
+		}
+	}
+
+	tests := []struct {
+		name     string
+		token    func(t *testing.T) string
+		verifier func(v *Verifier)
+		wantErr  string
+	}{
+		{
+			name:  "valid",
+			token: sign(key, "k1", nil),
+		},
+		{
+			name:    "signed with another key",
+			token:   sign(other, "k1", nil),
+			wantErr: "invalid token signature",
+		},
+		{
+			name: "tampered claims",
+			token: func(t *testing.T) string {
+				parts := strings.Split(sign(key, "k1", nil)(t), ".")
+
+				c := validClaims()
+				c.Email = "attacker@example.com"
+				b, _ := json.Marshal(c)
+				parts[1] = base64.RawURLEncoding.EncodeToString(b)
+
+				return strings.Join(parts, ".")
+			},
+			wantErr: "invalid token signature",
+		},
+		{
+			name:    "unknown kid",
+			token:   sign(key, "k2", nil),
+			wantErr: `unknown key "k2"`,
+		},
+		{
+			name:    "unexpected issuer",
+			token:   sign(key, "k1", func(c *Claims) { c.Issuer = "https://evil.example.com" }),
+			wantErr: "unexpected issuer",
+		},
+		{
+			name:     "custom issuer",
+			token:    sign(key, "k1", func(c *Claims) { c.Issuer = "https://issuer.example.com" }),
+			verifier: func(v *Verifier) { v.Issuers = []string{"https://issuer.example.com"} },
+		},
+		{
+			name:     "google issuer with custom issuers",
+			token:    sign(key, "k1", nil),
+			verifier: func(v *Verifier) { v.Issuers = []string{"https://issuer.example.com"} },
+			wantErr:  "unexpected issuer",
+		},
+		{
+			name:    "unexpected audience",
+			token:   sign(key, "k1", func(c *Claims) { c.Audience = "https://other.example.com" }),
+			wantErr: "unexpected audience",
+		},
+		{
+			name:    "unexpected email",
+			token:   sign(key, "k1", func(c *Claims) { c.Email = "other@my-project.iam.gserviceaccount.com" }),
+			wantErr: "unexpected email",
+		},
+		{
+			name:    "unverified email",
+			token:   sign(key, "k1", func(c *Claims) { c.EmailVerified = false }),
+			wantErr: "unexpected email",
+		},
+		{
+			name:     "email not checked",
+			token:    sign(key, "k1", func(c *Claims) { c.Email = "" }),
+			verifier: func(v *Verifier) { v.Email = "" },
+		},
+		{
+			name: "expired",
+			token: sign(key, "k1", func(c *Claims) {
+				c.IssuedAt = time.Now().Add(-2 * time.Hour).Unix()
+				c.Expiry = time.Now().Add(-time.Hour).Unix()
+			}),
+			wantErr: "token expired",
+		},
+		{
+			name:  "expired within leeway",
+			token: sign(key, "k1", func(c *Claims) { c.Expiry = time.Now().Add(-leeway / 2).Unix() }),
+		},
+		{
+			name:    "issued in the future",
+			token:   sign(key, "k1", func(c *Claims) { c.IssuedAt = time.Now().Add(time.Hour).Unix() }),
+			wantErr: "token issued in the future",
+		},
+		{
+			name: "alg none",
+			token: func(t *testing.T) string {
+				return unsignedToken(t, map[string]string{"alg": "none", "kid": "k1"}, validClaims(), func(string) []byte { return nil })
+			},
+			wantErr: `unsupported signing algorithm "none"`,
+		},
+		{
+			name: "HS256 with the public key as secret",
+			token: func(t *testing.T) string {
+				secret := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+
+				return unsignedToken(t, map[string]string{"alg": "HS256", "kid": "k1"}, validClaims(), func(signed string) []byte {
+					mac := hmac.New(sha256.New, secret)
+					mac.Write([]byte(signed))
+					return mac.Sum(nil)
+				})
+			},
+			wantErr: `unsupported signing algorithm "HS256"`,
+		},
+		{
+			name:    "malformed",
+			token:   func(t *testing.T) string { return "not-a-token" },
+			wantErr: "malformed token",
+		},
+		{
+			name: "malformed signature",
+			token: func(t *testing.T) string {
+				return sign(key, "k1", nil)(t) + "%"
+			},
+			wantErr: "malformed token signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &Verifier{Audience: testAudience, Email: testEmail, Keys: StaticKeys{"k1": &key.PublicKey}}
+			if tt.verifier != nil {
+				tt.verifier(v)
+			}
+
+			c, err := v.Verify(context.Background(), tt.token(t))
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Verify failed: %v", err)
+				}
+				if c.Audience != testAudience {
+					t.Errorf("audience = %q, want %q", c.Audience, testAudience)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Verify succeeded, want error %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify error = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func jwks(keys map[string]*rsa.PublicKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kid": kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func TestRemoteKeys(t *testing.T) {
+	key, other := keys(t)
+
+	var mu sync.Mutex
+	served := map[string]*rsa.PublicKey{"k1": &key.PublicKey}
+	fetches := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fetches++
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(jwks(served))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	r := &RemoteKeys{URL: srv.URL}
+
+	got, err := r.Key(ctx, "k1")
+	if err != nil {
+		t.Fatalf("Key failed: %v", err)
+	}
+	if got.N.Cmp(key.N) != 0 || got.E != key.E {
+		t.Error("Key returned a different key")
+	}
+
+	if _, err := r.Key(ctx, "k1"); err != nil || fetches != 1 {
+		t.Errorf("cached Key = %v, fetched %d times, want 1", err, fetches)
+	}
+
+	// Keys are rotated, but unknown IDs don't cause a fetch more than once a minute
+	mu.Lock()
+	served["k2"] = &other.PublicKey
+	mu.Unlock()
+
+	if _, err := r.Key(ctx, "k2"); err == nil || fetches != 1 {
+		t.Errorf("Key(k2) = %v, fetched %d times, want an error without fetching", err, fetches)
+	}
+
+	r.fetched = r.fetched.Add(-minRefresh)
+	if _, err := r.Key(ctx, "k2"); err != nil || fetches != 2 {
+		t.Errorf("Key(k2) = %v, fetched %d times, want 2", err, fetches)
+	}
+
+	if r.expires.Sub(r.fetched) != time.Hour {
+		t.Errorf("keys expire after %v, want 1h", r.expires.Sub(r.fetched))
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	key, _ := keys(t)
+
+	dir, err := ioutil.TempDir("", "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"jwks.json":  jwks(map[string]*rsa.PublicKey{"k1": &key.PublicKey}),
+		"pkix.pem":   pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}),
+		"pkcs1.pem":  pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}),
+		"empty.pem":  []byte("no keys here"),
+		"empty.json": []byte(`{"keys":[]}`),
+	}
+
+	for name, b := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := Sign(key, "k1", validClaims())
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	for name := range files {
+		t.Run(name, func(t *testing.T) {
+			keys, err := ReadKeyFile(filepath.Join(dir, name))
+			if strings.HasPrefix(name, "empty") {
+				if err == nil {
+					t.Error("ReadKeyFile succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadKeyFile failed: %v", err)
+			}
+
+			v := Verifier{Audience: testAudience, Email: testEmail, Keys: keys}
+			if _, err := v.Verify(context.Background(), token); err != nil {
+				t.Errorf("Verify failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"public, max-age=19800, must-revalidate": 19800 * time.Second,
+		"max-age=60":                             time.Minute,
+		"no-cache":                               defaultKeysMaxAge,
+		"max-age=-1":                             defaultKeysMaxAge,
+		"":                                       defaultKeysMaxAge,
+	}
+
+	for header, want := range tests {
+		if got := maxAge(header); got != want {
+			t.Errorf("maxAge(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package outbox makes publishing events part of a database transaction. Instead of being
+// published right away, events are added to an outbox table within the transaction that
+// changes the data they are about. A Relay publishes them once the transaction committed,
+// so either both the change and its events happen or neither of them.
+// See https://microservices.io/patterns/data/transactional-outbox.html
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A Record is an event waiting in the outbox, encoded as message for its topic.
+type Record struct {
+	ID          string
+	Topic       string
+	Data        []byte
+	Attributes  map[string]string
+	OrderingKey string
+
+	// Attempts counts the failed attempts to publish the record.
+	Attempts int
+}
+
+// Execer is implemented by *sql.Tx, *sql.DB and *sql.Conn.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// A Store keeps the records of an outbox. Implementations must be safe for concurrent use.
+type Store interface {
+
+	// Add stores r as part of the transaction tx.
+	Add(ctx context.Context, tx Execer, r *Record) error
+
+	// Pending claims up to limit records, which haven't been sent and are due for another
+	// attempt, oldest first. Claimed records must not be returned again, e.g. to the relay of
+	// another instance, until they are marked failed or the claim expired.
+	Pending(ctx context.Context, limit int) ([]*Record, error)
+
+	// MarkSent records that the record with the given ID has been published.
+	MarkSent(ctx context.Context, id string) error
+
+	// MarkFailed counts a failed attempt to publish the record with the given ID
+	// and postpones the next attempt until retryAt.
+	MarkFailed(ctx context.Context, id string, retryAt time.Time) error
+}
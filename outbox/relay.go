@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+const (
+	defaultInterval   = time.Second
+	defaultBatchSize  = 100
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+// A Relay publishes the records of an outbox and marks them sent. Records failing to be
+// published are retried with exponential backoff. Records are published at least once:
+// if the relay stops between publishing and marking a record, it is published again.
+type Relay struct {
+
+	// Store holding the outbox.
+	Store Store
+
+	// Sender publishing the records.
+	Sender transport.Sender
+
+	// Interval in which the outbox is checked for new records. Defaults to one second.
+	Interval time.Duration
+
+	// BatchSize is the number of records published at once. Defaults to 100.
+	BatchSize int
+
+	// MaxBackoff limits how long a failing record waits for its next attempt. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+
+	// Logger failures are logged with. Defaults to logging.Default.
+	Logger *logging.Logger
+
+	notify chan struct{}
+}
+
+// NewRelay returns a Relay publishing the records of store with sender.
+func NewRelay(store Store, sender transport.Sender) *Relay {
+	return &Relay{
+		Store:  store,
+		Sender: sender,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Run relays records until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+
+	for {
+		err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger().Error("Failed to relay outbox", logging.Fields{"error": err})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.notify:
+		}
+	}
+}
+
+// Notify makes a running relay check the outbox right away, e.g. after a transaction
+// adding records committed.
+func (r *Relay) Notify() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Flush publishes all records which are due and returns the first failure.
+func (r *Relay) Flush(ctx context.Context) error {
+	var failure error
+
+	for {
+		n, err := r.relay(ctx)
+		if err != nil && failure == nil {
+			failure = err
+		}
+
+		// Failed records are postponed, so a full batch always makes progress.
+		if n < r.batchSize() || ctx.Err() != nil {
+			return failure
+		}
+	}
+}
+
+// relay publishes a single batch of records and returns its size.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	records, err := r.Store.Pending(ctx, r.batchSize())
+	if err != nil {
+		return 0, err
+	}
+
+	results := make([]transport.PublishResult, len(records))
+	for i, rec := range records {
+		results[i] = r.Sender.Publish(ctx, rec.Topic, &transport.Message{
+			Data:        rec.Data,
+			Attributes:  rec.Attributes,
+			OrderingKey: rec.OrderingKey,
+		})
+	}
+
+	var failure error
+	for i, rec := range records {
+		_, err := results[i].Get(ctx)
+		if err != nil {
+			if failure == nil {
+				failure = fmt.Errorf("failed to publish outbox record %s to %s (%v)", rec.ID, rec.Topic, err)
+			}
+
+			err = r.Store.MarkFailed(context.Background(), rec.ID, time.Now().Add(r.backoff(rec.Attempts)))
+		} else {
+			err = r.Store.MarkSent(context.Background(), rec.ID)
+		}
+
+		if err != nil && failure == nil {
+			failure = err
+		}
+	}
+
+	return len(records), failure
+}
+
+// backoff before the next attempt after the given number of failed attempts.
+func (r *Relay) backoff(attempts int) time.Duration {
+	max := r.MaxBackoff
+	if max == 0 {
+		max = defaultMaxBackoff
+	}
+
+	d := r.interval()
+	for i := 0; i < attempts && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+func (r *Relay) logger() *logging.Logger {
+	if r.Logger == nil {
+		return logging.Default
+	}
+
+	return r.Logger
+}
+
+func (r *Relay) interval() time.Duration {
+	if r.Interval == 0 {
+		return defaultInterval
+	}
+
+	return r.Interval
+}
+
+func (r *Relay) batchSize() int {
+	if r.BatchSize == 0 {
+		return defaultBatchSize
+	}
+
+	return r.BatchSize
+}
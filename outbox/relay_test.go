@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+var quiet = logging.New(&logging.TextSink{W: ioutil.Discard}, logging.Critical)
+
+// memoryRecord is a record kept by memoryStore.
+type memoryRecord struct {
+	*Record
+	seq          int
+	sent         bool
+	claimedUntil time.Time
+	nextAttempt  time.Time
+}
+
+// memoryStore is a Store keeping its records in memory, ignoring transactions.
+type memoryStore struct {
+	mu      sync.Mutex
+	lease   time.Duration
+	records map[string]*memoryRecord
+}
+
+func newMemoryStore(lease time.Duration) *memoryStore {
+	return &memoryStore{lease: lease, records: make(map[string]*memoryRecord)}
+}
+
+func (s *memoryStore) Add(ctx context.Context, tx Execer, r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[r.ID] = &memoryRecord{Record: r, seq: len(s.records)}
+	return nil
+}
+
+func (s *memoryStore) Pending(ctx context.Context, limit int) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var due []*memoryRecord
+	for _, r := range s.records {
+		if !r.sent && now.After(r.claimedUntil) && !now.Before(r.nextAttempt) {
+			due = append(due, r)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].seq < due[j].seq })
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	records := make([]*Record, len(due))
+	for i, r := range due {
+		r.claimedUntil = now.Add(s.lease)
+		c := *r.Record
+		records[i] = &c
+	}
+
+	return records, nil
+}
+
+func (s *memoryStore) MarkSent(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[id].sent = true
+	return nil
+}
+
+func (s *memoryStore) MarkFailed(ctx context.Context, id string, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.records[id]
+	r.Attempts++
+	r.nextAttempt = retryAt
+	r.claimedUntil = time.Time{}
+	return nil
+}
+
+func (s *memoryStore) add(t *testing.T, topic string, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		r := &Record{ID: fmt.Sprintf("%s-%03d", topic, i), Topic: topic, Data: []byte(fmt.Sprintf(`{"id":%d}`, i))}
+		if err := s.Add(context.Background(), nil, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (s *memoryStore) record(id string) memoryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.records[id]
+}
+
+func newBroker(t *testing.T, topics ...string) *transport.Memory {
+	t.Helper()
+
+	b := transport.NewMemory()
+	for _, topic := range topics {
+		if err := b.EnsureTopic(context.Background(), topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return b
+}
+
+func TestRelayFlush(t *testing.T) {
+	b := newBroker(t, "orders")
+	defer b.Close()
+
+	store := newMemoryStore(time.Minute)
+	store.add(t, "orders", 5)
+	store.add(t, "missing", 1)
+
+	r := NewRelay(store, b)
+	r.BatchSize = 2
+	r.Interval = time.Second
+
+	err := r.Flush(context.Background())
+	if err == nil {
+		t.Error("Flush succeeded, want the failure to publish to missing")
+	}
+
+	published := b.Published("orders")
+	if len(published) != 5 {
+		t.Fatalf("published %d records, want all 5 in several batches", len(published))
+	}
+	for i, m := range published {
+		if want := fmt.Sprintf(`{"id":%d}`, i); string(m.Data) != want {
+			t.Errorf("published %s at %d, want %s", m.Data, i, want)
+		}
+		if !store.record(fmt.Sprintf("orders-%03d", i)).sent {
+			t.Errorf("record %d isn't marked sent", i)
+		}
+	}
+
+	// The failed record is postponed
+	failed := store.record("missing-000")
+	if failed.sent || failed.Attempts != 1 {
+		t.Errorf("failed record is sent %v after %d attempts, want unsent after 1", failed.sent, failed.Attempts)
+	}
+	if wait := time.Until(failed.nextAttempt); wait < 900*time.Millisecond || wait > time.Second {
+		t.Errorf("failed record is retried in %v, want one interval", wait)
+	}
+
+	// Postponed records aren't due yet
+	if err := r.Flush(context.Background()); err != nil {
+		t.Errorf("Flush = %v, want nothing to relay", err)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := &Relay{Interval: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := map[int]time.Duration{
+		0:  time.Second,
+		1:  2 * time.Second,
+		3:  8 * time.Second,
+		4:  10 * time.Second,
+		50: 10 * time.Second,
+	}
+
+	for attempts, want := range tests {
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	b := newBroker(t, "orders")
+	defer b.Close()
+
+	store := newMemoryStore(time.Minute)
+	store.add(t, "orders", 1)
+
+	// The interval is too long for records to be relayed by the ticker
+	r := NewRelay(store, b)
+	r.Interval = time.Hour
+	r.Logger = quiet
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(stopped)
+	}()
+
+	waitPublished := func(n int) {
+		t.Helper()
+
+		for start := time.Now(); len(b.Published("orders")) < n; time.Sleep(time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("published %d records, want %d", len(b.Published("orders")), n)
+			}
+		}
+	}
+
+	// Records are relayed once the relay starts and once it is notified
+	waitPublished(1)
+
+	store.add(t, "orders", 2)
+	r.Notify()
+	r.Notify()
+	waitPublished(2)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once its context was done")
+	}
+}
+
+func TestRelayReclaimsExpiredClaims(t *testing.T) {
+	b := newBroker(t, "orders")
+	defer b.Close()
+
+	store := newMemoryStore(20 * time.Millisecond)
+	store.add(t, "orders", 1)
+
+	// Another relay claimed the record and died before marking it
+	if records, err := store.Pending(context.Background(), 10); err != nil || len(records) != 1 {
+		t.Fatalf("Pending = %v, %v, want one record", records, err)
+	}
+
+	r := NewRelay(store, b)
+	if err := r.Flush(context.Background()); err != nil || len(b.Published("orders")) != 0 {
+		t.Fatalf("Flush = %v, published %d, want the claimed record to be skipped", err, len(b.Published("orders")))
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if err := r.Flush(context.Background()); err != nil || len(b.Published("orders")) != 1 {
+		t.Errorf("Flush = %v, published %d, want the record once its claim expired", err, len(b.Published("orders")))
+	}
+}
@@ -0,0 +1,234 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTable = "surfkit_outbox"
+	defaultLease = time.Minute
+)
+
+// SQLStore keeps the outbox in a table of a database/sql database. The statements stick to
+// portable SQL, so the store works with SQLite, PostgreSQL and MySQL alike.
+type SQLStore struct {
+
+	// DB the relay reads the outbox from.
+	DB *sql.DB
+
+	// Table holding the outbox. Defaults to surfkit_outbox.
+	Table string
+
+	// Placeholder returns the placeholder of the n-th (1-based) statement argument.
+	// Defaults to QuestionMark, use Dollar for PostgreSQL.
+	Placeholder func(n int) string
+
+	// Lease is how long records returned by Pending are withheld from other relays, e.g. of
+	// other instances of the service. Records which are neither marked sent nor failed
+	// within the lease are due again. Defaults to one minute.
+	Lease time.Duration
+}
+
+// QuestionMark placeholders as used by SQLite and MySQL.
+func QuestionMark(n int) string {
+	return "?"
+}
+
+// Dollar placeholders as used by PostgreSQL.
+func Dollar(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// CreateTable creates the outbox table unless it exists already.
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id VARCHAR(64) NOT NULL PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	data TEXT NOT NULL,
+	attributes TEXT NOT NULL,
+	ordering_key VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL,
+	attempts INTEGER NOT NULL,
+	next_attempt BIGINT NOT NULL,
+	sent_at BIGINT
+)`, s.table()))
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table (%v)", err)
+	}
+
+	return nil
+}
+
+// Add inserts r into the outbox as part of tx. A missing ID is generated.
+func (s *SQLStore) Add(ctx context.Context, tx Execer, r *Record) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+
+	attrs, err := json.Marshal(r.Attributes)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+
+	_, err = tx.ExecContext(ctx, s.query(
+		"INSERT INTO %s (id, topic, data, attributes, ordering_key, created_at, attempts, next_attempt) VALUES (%s, %s, %s, %s, %s, %s, 0, %s)"),
+		r.ID, r.Topic, base64.StdEncoding.EncodeToString(r.Data), string(attrs), r.OrderingKey, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to add event to outbox (%v)", err)
+	}
+
+	return nil
+}
+
+// Pending claims up to limit records due for publishing, oldest first.
+//
+// Each record is claimed by moving its next attempt to the end of the lease, provided it
+// hasn't changed since it was read. Records another relay claimed in the meantime are
+// skipped, so concurrent relays never publish a record at the same time.
+func (s *SQLStore) Pending(ctx context.Context, limit int) ([]*Record, error) {
+	candidates, err := s.due(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := time.Now().Add(s.lease()).UnixNano()
+
+	var records []*Record
+	for _, c := range candidates {
+		res, err := s.DB.ExecContext(ctx, s.query(
+			"UPDATE %s SET next_attempt = %s WHERE id = %s AND next_attempt = %s AND sent_at IS NULL"),
+			leased, c.ID, c.nextAttempt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim outbox record %s (%v)", c.ID, err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim outbox record %s (%v)", c.ID, err)
+		}
+
+		if n == 1 {
+			records = append(records, &c.Record)
+		}
+	}
+
+	return records, nil
+}
+
+// A candidate is a record due for publishing, which has yet to be claimed.
+type candidate struct {
+	Record
+	nextAttempt int64
+}
+
+// due reads up to limit records due for publishing, oldest first.
+func (s *SQLStore) due(ctx context.Context, limit int) ([]*candidate, error) {
+	rows, err := s.DB.QueryContext(ctx, s.query(
+		"SELECT id, topic, data, attributes, ordering_key, attempts, next_attempt FROM %s WHERE sent_at IS NULL AND next_attempt <= %s ORDER BY created_at LIMIT %s"),
+		time.Now().UnixNano(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox (%v)", err)
+	}
+	defer rows.Close()
+
+	var candidates []*candidate
+	for rows.Next() {
+		var c candidate
+		var data, attrs string
+
+		err = rows.Scan(&c.ID, &c.Topic, &data, &attrs, &c.OrderingKey, &c.Attempts, &c.nextAttempt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read outbox (%v)", err)
+		}
+
+		c.Data, err = base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data of outbox record %s (%v)", c.ID, err)
+		}
+
+		err = json.Unmarshal([]byte(attrs), &c.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attributes of outbox record %s (%v)", c.ID, err)
+		}
+
+		candidates = append(candidates, &c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox (%v)", err)
+	}
+
+	return candidates, nil
+}
+
+// MarkSent records that the record has been published.
+func (s *SQLStore) MarkSent(ctx context.Context, id string) error {
+	_, err := s.DB.ExecContext(ctx, s.query("UPDATE %s SET sent_at = %s WHERE id = %s"), time.Now().UnixNano(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox record %s sent (%v)", id, err)
+	}
+
+	return nil
+}
+
+// MarkFailed counts a failed attempt and postpones the next one until retryAt.
+func (s *SQLStore) MarkFailed(ctx context.Context, id string, retryAt time.Time) error {
+	_, err := s.DB.ExecContext(ctx, s.query("UPDATE %s SET attempts = attempts + 1, next_attempt = %s WHERE id = %s"), retryAt.UnixNano(), id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox record %s failed (%v)", id, err)
+	}
+
+	return nil
+}
+
+// DeleteSent removes the records sent before the given time, which otherwise stay in the table.
+func (s *SQLStore) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.DB.ExecContext(ctx, s.query("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s"), before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sent outbox records (%v)", err)
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *SQLStore) lease() time.Duration {
+	if s.Lease == 0 {
+		return defaultLease
+	}
+
+	return s.Lease
+}
+
+func (s *SQLStore) table() string {
+	if s.Table == "" {
+		return defaultTable
+	}
+
+	return s.Table
+}
+
+// query fills in the table name followed by as many placeholders as the format asks for.
+func (s *SQLStore) query(format string) string {
+	placeholder := s.Placeholder
+	if placeholder == nil {
+		placeholder = QuestionMark
+	}
+
+	n := strings.Count(format, "%s") - 1
+	args := []interface{}{s.table()}
+	for i := 1; i <= n; i++ {
+		args = append(args, placeholder(i))
+	}
+
+	return fmt.Sprintf(format, args...)
+}
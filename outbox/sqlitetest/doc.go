@@ -0,0 +1,3 @@
+// Package sqlitetest tests outbox.SQLStore against SQLite. It is a module of its own,
+// so the cgo based driver doesn't become a dependency of surfkit.
+package sqlitetest
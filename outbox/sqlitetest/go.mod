@@ -0,0 +1,10 @@
+module github.com/helloink/surfkit/outbox/sqlitetest
+
+go 1.12
+
+require (
+	github.com/helloink/surfkit v0.0.0
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
+replace github.com/helloink/surfkit => ../..
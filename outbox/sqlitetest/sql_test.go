@@ -0,0 +1,173 @@
+//go:build cgo
+// +build cgo
+
+package sqlitetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit/outbox"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite returns a store backed by a fresh SQLite database.
+func openSQLite(t *testing.T) (*outbox.SQLStore, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "outbox.db")+"?_busy_timeout=5000")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	store := &outbox.SQLStore{DB: db}
+	if err := store.CreateTable(context.Background()); err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("CreateTable failed: %v", err)
+	}
+
+	return store, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func addRecords(t *testing.T, store *outbox.SQLStore, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		err := store.Add(context.Background(), store.DB, &outbox.Record{
+			ID:         fmt.Sprintf("r%03d", i),
+			Topic:      "orders.placed",
+			Data:       []byte(fmt.Sprintf(`{"id":%d}`, i)),
+			Attributes: map[string]string{"content-type": "application/cloudevents+json"},
+		})
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+}
+
+func TestSQLStorePending(t *testing.T) {
+	store, cleanup := openSQLite(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	addRecords(t, store, 3)
+
+	records, err := store.Pending(ctx, 2)
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(records) != 2 || records[0].ID != "r000" || records[1].ID != "r001" {
+		t.Fatalf("Pending = %v, want r000 and r001", records)
+	}
+	if string(records[0].Data) != `{"id":0}` || records[0].Attributes["content-type"] != "application/cloudevents+json" {
+		t.Errorf("Pending returned %+v", records[0])
+	}
+
+	// Claimed records aren't returned again
+	records, err = store.Pending(ctx, 10)
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "r002" {
+		t.Fatalf("Pending = %v, want r002 only", records)
+	}
+
+	// Failed records are due again at their next attempt
+	if err := store.MarkSent(ctx, "r000"); err != nil {
+		t.Fatalf("MarkSent failed: %v", err)
+	}
+	if err := store.MarkFailed(ctx, "r001", time.Now()); err != nil {
+		t.Fatalf("MarkFailed failed: %v", err)
+	}
+
+	records, err = store.Pending(ctx, 10)
+	if err != nil {
+		t.Fatalf("Pending failed: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "r001" || records[0].Attempts != 1 {
+		t.Fatalf("Pending = %v, want r001 after one attempt", records)
+	}
+}
+
+func TestSQLStoreLeaseExpires(t *testing.T) {
+	store, cleanup := openSQLite(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	store.Lease = 10 * time.Millisecond
+	addRecords(t, store, 1)
+
+	if records, err := store.Pending(ctx, 10); err != nil || len(records) != 1 {
+		t.Fatalf("Pending = %v, %v, want one record", records, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The relay holding the claim died, the record is due again
+	if records, err := store.Pending(ctx, 10); err != nil || len(records) != 1 {
+		t.Errorf("Pending after the lease expired = %v, %v, want one record", records, err)
+	}
+}
+
+func TestSQLStoreConcurrentPending(t *testing.T) {
+	store, cleanup := openSQLite(t)
+	defer cleanup()
+
+	const total = 200
+	addRecords(t, store, total)
+
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				records, err := store.Pending(context.Background(), 10)
+				if err != nil {
+					t.Errorf("Pending failed: %v", err)
+					return
+				}
+				if len(records) == 0 {
+					return
+				}
+
+				mu.Lock()
+				for _, r := range records {
+					claimed[r.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != total {
+		t.Errorf("claimed %d records, want %d", len(claimed), total)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("record %s claimed %d times", id, n)
+		}
+	}
+}
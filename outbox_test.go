@@ -0,0 +1,133 @@
+package surfkit_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/outbox"
+	"github.com/helloink/surfkit/surfkittest"
+	"github.com/helloink/surfkit/transport"
+)
+
+// heldStore is an outbox.Store in memory which holds its records back until released.
+type heldStore struct {
+	mu       sync.Mutex
+	released bool
+	checks   int
+	records  []*outbox.Record
+}
+
+func (s *heldStore) Add(ctx context.Context, tx outbox.Execer, r *outbox.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r.ID = strconv.Itoa(len(s.records))
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *heldStore) Pending(ctx context.Context, limit int) ([]*outbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks++
+	if !s.released {
+		return nil, nil
+	}
+
+	records := s.records
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	s.records = s.records[len(records):]
+
+	return records, nil
+}
+
+func (s *heldStore) MarkSent(ctx context.Context, id string) error { return nil }
+
+func (s *heldStore) MarkFailed(ctx context.Context, id string, retryAt time.Time) error { return nil }
+
+func (s *heldStore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released = true
+}
+
+func (s *heldStore) checked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checks
+}
+
+func startOutbox(t *testing.T, store outbox.Store) (*surfkittest.Harness, *transport.Memory) {
+	t.Helper()
+
+	broker := transport.NewMemory()
+	s := &surfkit.Service{
+		Name:      "orders",
+		Version:   "1.0.0",
+		Logger:    quiet,
+		Transport: broker,
+		Output:    &surfkit.Output{EventType: "order.placed", Outbox: store},
+	}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		broker.Close()
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	return h, broker
+}
+
+func TestOutboxFlushedOnTeardown(t *testing.T) {
+	store := &heldStore{}
+	h, broker := startOutbox(t, store)
+	defer broker.Close()
+
+	if err := surfkit.PublishEventTx(context.Background(), h.Service, nil, "order.placed", map[string]int{"id": 1}); err != nil {
+		t.Fatalf("PublishEventTx failed: %v", err)
+	}
+	store.release()
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if n := len(broker.Published("order.placed")); n != 1 {
+		t.Errorf("published %d events on teardown, want 1", n)
+	}
+}
+
+func TestPublishEventTxNotifiesRelay(t *testing.T) {
+	store := &heldStore{released: true}
+	h, broker := startOutbox(t, store)
+	defer broker.Close()
+	defer h.Close()
+
+	// Wait for the relay's first check, the next one is due after a second
+	for start := time.Now(); store.checked() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("relay didn't check the outbox")
+		}
+	}
+
+	start := time.Now()
+	if err := surfkit.PublishEventTx(context.Background(), h.Service, nil, "order.placed", map[string]int{"id": 1}); err != nil {
+		t.Fatalf("PublishEventTx failed: %v", err)
+	}
+
+	for len(broker.Published("order.placed")) == 0 {
+		if time.Since(start) > 500*time.Millisecond {
+			t.Fatal("event wasn't relayed right away")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -6,26 +6,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
 )
 
+const defaultAckDeadline = 10 * time.Second
+
 // PubsubPushMessageEnvelope as received via an http endpoint from a pubsub server
 type PubsubPushMessageEnvelope struct {
 	Subscription string            `json:"subscription"`
 	Message      PubsubPushMessage `json:"message"`
+
+	// DeliveryAttempt is only set if the subscription has a dead letter policy.
+	DeliveryAttempt int `json:"deliveryAttempt"`
 }
 
 // PubsubPushMessage as contained in the payload of PubsubPushMessageEnvelope
 type PubsubPushMessage struct {
-	MessageID  string            `json:"messageId"`
-	Attributes map[string]string `json:"attributes"`
+	MessageID   string            `json:"messageId"`
+	Attributes  map[string]string `json:"attributes"`
+	PublishTime time.Time         `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey"`
 
 	// Data holds the pubsub message payload encoded as base64
 	Data string `json:"data"`
@@ -76,9 +83,16 @@ type PushSubscription struct {
 	Topic string
 
 	// A func that will be called as soon as a new message arrives on the attached `Topic`.
+	Handler EventHandler
+
+	// Legacy form of Handler, returning `true` for ack and `false` for nack.
+	// It is only used if Handler is not set.
 	HandleFunc func(s *Service, e *events.CloudEvent) bool
 
-	// See https://godoc.org/cloud.google.com/go/pubsub#ReceiveSettings
+	// Middleware wrapping the handler of this subscription.
+	Middleware []EventMiddleware
+
+	// Deprecated: Push subscriptions receive messages over HTTP, ReceiveSettings has no effect.
 	ReceiveSettings *pubsub.ReceiveSettings
 
 	// How long Pub/Sub waits for the subscriber to acknowledge receipt before resending the message
@@ -87,18 +101,47 @@ type PushSubscription struct {
 	// Experimential. Delete the Subscription on shutdown of the service.
 	DeleteOnShutdown bool
 
-	// Experimental. Delete the subscription after time.Duration (min 1day) of subscriber inactivity
+	// Experimental. Delete the subscription after time.Duration (min 1day) of subscriber inactivity.
+	// Defaults to 31 days, transport.NeverExpire keeps the subscription forever.
 	ExpirationPolicy time.Duration
 
+	// Forward messages which repeatedly failed to be delivered to a dead letter topic instead
+	// of redelivering them forever. The topic is created if missing. See RepublishDeadLetters.
+	DeadLetterPolicy *transport.DeadLetterPolicy
+
+	// Back off from redelivering nacked messages. Otherwise they are redelivered right away.
+	RetryPolicy *transport.RetryPolicy
+
+	// Labels attached to the subscription.
+	Labels map[string]string
+
+	// Only receive messages whose attributes match this filter. A filter can't be changed
+	// once the subscription exists. See https://cloud.google.com/pubsub/docs/filtering
+	Filter string
+
+	// Authenticate push requests. If not set, the push endpoint accepts any request.
+	Auth *PushAuth
+
 	service *Service
+	handler EventHandler
+
+	// endpoint messages are pushed to, unless the setup was skipped for the reason in skipped.
+	endpoint string
+	skipped  string
 }
 
 // Setup receive routes and the subscription
 func (p *PushSubscription) Setup(s *Service) error {
 	p.service = s
 
-	host, ok := os.LookupEnv("HOST")
-	if ok {
+	handler := resolveHandler(p.Handler, p.HandleFunc)
+	if handler == nil {
+		return fmt.Errorf("subscription %s has no handler", p.Name)
+	}
+	p.handler = s.wrapHandler(p.Middleware, handler)
+
+	host := s.Env.Host
+	if host != "" || s.Env.hostSet {
 
 		// This is a special mechanism built to make it easier to deploy Surfkit Services on Cloud Run.
 		// When a service is freshly launched, its own URL is still unknown - Google assigns it after
@@ -109,7 +152,8 @@ func (p *PushSubscription) Setup(s *Service) error {
 		// retrieved and correctly set as the HOST env with the next deploy. Only when the URL is correct,
 		// a Subscription is created.
 		if strings.HasPrefix(host, "http") == false {
-			log.Println("WARN: HOST not valid. Skipping Pubsub Push Activation")
+			s.Logger.Warning("HOST not valid. Skipping Pubsub Push Activation", logging.Fields{"subscription": p.Name, "host": host})
+			p.skipped = fmt.Sprintf("HOST %q not valid", host)
 			return nil
 		}
 
@@ -120,58 +164,47 @@ func (p *PushSubscription) Setup(s *Service) error {
 	path := fmt.Sprintf("/sk/v1/messages/%s", p.Name)
 	endpoint := fmt.Sprintf("%s%s", host, path)
 
-	s.Router.HandleFunc(path, p.incomingPubsubMessages).Methods("POST")
-
-	ctx := context.Background()
-
-	client, err := pubsub.NewClient(ctx, s.Env.ProjectID)
-	if err != nil {
-		return fmt.Errorf("failed to setup pubsub (%v)", err)
+	cfg := transport.SubscriptionConfig{
+		Topic:            p.Topic,
+		AckDeadline:      ackDeadline(p.AckDeadline),
+		PushEndpoint:     endpoint,
+		ExpirationPolicy: p.ExpirationPolicy,
+		DeadLetterPolicy: p.DeadLetterPolicy,
+		RetryPolicy:      p.RetryPolicy,
+		Labels:           p.Labels,
+		Filter:           p.Filter,
 	}
 
-	// Setup and configure the subscription object
-	sub := client.Subscription(p.Name)
-
-	if p.ReceiveSettings != nil {
-		sub.ReceiveSettings = *p.ReceiveSettings
-	}
-
-	// Check if the subscription exists already
-	ok, err = sub.Exists(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to check subscription %s (%v)", p.Name, err)
-	}
-
-	// If it doesn't exists, well...
-	if !ok {
-
-		ackTime := 10 * time.Second
-		if p.AckDeadline != 0 {
-			ackTime = p.AckDeadline
+	route := p.incomingPubsubMessages
+	if p.Auth != nil {
+		audience := p.Auth.Audience
+		if audience == "" {
+			audience = endpoint
 		}
 
-		cfg := pubsub.SubscriptionConfig{
-			Topic:       client.Topic(p.Topic),
-			AckDeadline: ackTime,
-
-			PushConfig: pubsub.PushConfig{
-				Endpoint: endpoint,
-			},
+		v, err := p.Auth.verifier(audience)
+		if err != nil {
+			return fmt.Errorf("invalid auth for subscription %s (%v)", p.Name, err)
 		}
 
-		// Experimental.
-		if p.ExpirationPolicy != 0 {
-			cfg.ExpirationPolicy = p.ExpirationPolicy
-		}
+		route = authenticate(v, route)
+		cfg.PushServiceAccount = p.Auth.ServiceAccount
+		cfg.PushAudience = p.Auth.Audience
+	}
 
-		_, err := client.CreateSubscription(ctx, p.Name, cfg)
+	s.Router.HandleFunc(path, route).Methods("POST")
 
-		if err != nil {
-			return fmt.Errorf("failed to create subscription %s on %s (%v)", p.Name, p.Topic, err)
-		}
+	err := ensureSubscription(s, p.Name, cfg)
+	if err != nil {
+		return err
 	}
+	p.endpoint = endpoint
 
-	log.Printf("Pubsub: Subscription (%s) endpoint to %s mounted at %s", p.Name, p.Topic, endpoint)
+	s.Logger.Info(fmt.Sprintf("Pubsub: Subscription (%s) endpoint to %s mounted at %s", p.Name, p.Topic, endpoint), logging.Fields{
+		"subscription": p.Name,
+		"topic":        p.Topic,
+		"endpoint":     endpoint,
+	})
 	return nil
 }
 
@@ -200,41 +233,59 @@ func (p *PushSubscription) incomingPubsubMessages(w http.ResponseWriter, r *http
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		p.respondWithError(w, "Failed to read body", err)
+		p.respondWithError(w, r, "", "Failed to read body", err)
 		return
 	}
 
 	var ev PubsubPushMessageEnvelope
 	err = json.Unmarshal(body, &ev)
 	if err != nil {
-		p.respondWithError(w, "Failed to decode json body", err)
+		p.respondWithError(w, r, "", "Failed to decode json body", err)
 		return
 	}
 
 	data, err := ev.Message.DecodeData()
 	if err != nil {
-		p.respondWithError(w, "Failed to decode message data", err)
+		p.service.instruments.unmarshalFailed(p.Name)
+		p.respondWithError(w, r, ev.Message.MessageID, "Failed to decode message data", err)
 		return
 	}
 
-	var e *events.CloudEvent
-	err = json.Unmarshal(data, &e)
+	e, err := events.DecodeMessage(data, ev.Message.Attributes)
 	if err != nil {
-		p.respondWithError(w, "Failed to unmarshal message data", err)
+		p.service.instruments.unmarshalFailed(p.Name)
+		p.respondWithError(w, r, ev.Message.MessageID, "Failed to unmarshal message data", err)
 		return
 	}
 
-	ack := p.HandleFunc(p.service, e)
-	if ack {
+	o := p.service.dispatch(r.Context(), ackDeadline(p.AckDeadline), p.handler, e, &Delivery{
+		Subscription:    p.Name,
+		MessageID:       ev.Message.MessageID,
+		Attributes:      ev.Message.Attributes,
+		PublishTime:     ev.Message.PublishTime,
+		OrderingKey:     ev.Message.OrderingKey,
+		DeliveryAttempt: ev.DeliveryAttempt,
+	})
+
+	if o.ack {
 		w.WriteHeader(http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusNotAcceptable)
+		return
 	}
 
+	if o.delay > 0 {
+		w.Header().Set("Retry-After", retryAfter(o.delay))
+	}
+	w.WriteHeader(http.StatusNotAcceptable)
 }
 
-func (p *PushSubscription) respondWithError(w http.ResponseWriter, m string, err error) {
-	log.Printf("%s (%v)", m, err)
+// respondWithError logs the failed message, if its ID is known, and refuses it.
+func (p *PushSubscription) respondWithError(w http.ResponseWriter, r *http.Request, messageID, m string, err error) {
+	fields := logging.Fields{"subscription": p.Name, "error": err}
+	if messageID != "" {
+		fields["messageId"] = messageID
+	}
+
+	logging.FromContext(r.Context()).Error(m, fields)
 	w.WriteHeader(http.StatusNotAcceptable)
 }
 
@@ -246,8 +297,15 @@ type PullSubscription struct {
 	Topic string
 
 	// A func that will be called as soon as a new message arrives on the attached `Topic`.
+	Handler EventHandler
+
+	// Legacy form of Handler, returning `true` for ack and `false` for nack.
+	// It is only used if Handler is not set.
 	HandleFunc func(s *Service, e *events.CloudEvent) bool
 
+	// Middleware wrapping the handler of this subscription.
+	Middleware []EventMiddleware
+
 	// The name of this Subscription. This is by default the name of the Service and you should
 	// probably keep it this way as you'll otherwise break the built-in load balancing.
 	//
@@ -260,82 +318,95 @@ type PullSubscription struct {
 	// Experimential. Delete the Subscription on shutdown of the service.
 	DeleteOnShutdown bool
 
-	// Experimental. Delete the subscription after time.Duration (min 1day) of subscriber inactivity
+	// Experimental. Delete the subscription after time.Duration (min 1day) of subscriber inactivity.
+	// Defaults to 31 days, transport.NeverExpire keeps the subscription forever.
 	ExpirationPolicy time.Duration
 
+	// Forward messages which repeatedly failed to be delivered to a dead letter topic instead
+	// of redelivering them forever. The topic is created if missing. See RepublishDeadLetters.
+	DeadLetterPolicy *transport.DeadLetterPolicy
+
+	// Back off from redelivering nacked messages. Otherwise they are redelivered right away.
+	RetryPolicy *transport.RetryPolicy
+
+	// Labels attached to the subscription.
+	Labels map[string]string
+
+	// Only receive messages whose attributes match this filter. A filter can't be changed
+	// once the subscription exists. See https://cloud.google.com/pubsub/docs/filtering
+	Filter string
+
 	service *Service
+	handler EventHandler
 }
 
 // Setup Subscription
 func (p *PullSubscription) Setup(s *Service) error {
 	p.service = s
 
-	return nil
+	handler := resolveHandler(p.Handler, p.HandleFunc)
+	if handler == nil {
+		return fmt.Errorf("subscription %s has no handler", p.Name)
+	}
+	p.handler = s.wrapHandler(p.Middleware, handler)
+
+	return ensureSubscription(s, p.Name, transport.SubscriptionConfig{
+		Topic:            p.Topic,
+		AckDeadline:      ackDeadline(p.AckDeadline),
+		ExpirationPolicy: p.ExpirationPolicy,
+		DeadLetterPolicy: p.DeadLetterPolicy,
+		RetryPolicy:      p.RetryPolicy,
+		Labels:           p.Labels,
+		Filter:           p.Filter,
+	})
 }
 
 // Listen for new messages on Pubsub
 func (p *PullSubscription) Listen(s *Service) error {
 
-	ctx := context.Background()
-
-	client, err := pubsub.NewClient(ctx, s.Env.ProjectID)
-	if err != nil {
-		return fmt.Errorf("failed to setup pubsub (%v)", err)
-	}
-
-	// Check if the subscription exists already
-	sub := client.Subscription(p.Name)
-	ok, err := sub.Exists(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to check subscription %s (%v)", p.Name, err)
-	}
-
-	// If it doesn't exists, well...
-	if !ok {
-
-		ackTime := 10 * time.Second
-		if p.AckDeadline != 0 {
-			ackTime = p.AckDeadline
-		}
+	ctx := s.receiveContext()
 
-		cfg := pubsub.SubscriptionConfig{
-			Topic:       client.Topic(p.Topic),
-			AckDeadline: ackTime,
-		}
-
-		// Experimental.
-		if p.ExpirationPolicy != 0 {
-			cfg.ExpirationPolicy = p.ExpirationPolicy
-		}
-
-		sub, err = client.CreateSubscription(ctx, p.Name, cfg)
-
-		if err != nil {
-			return fmt.Errorf("failed to create subscription %s (%v)", p.Name, err)
-		}
-	}
+	s.Logger.Info(fmt.Sprintf("Pubsub: Subscription (%s) listening to %s", p.Name, p.Topic), logging.Fields{
+		"subscription": p.Name,
+		"topic":        p.Topic,
+	})
 
-	err = sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
-		var e *events.CloudEvent
-		err = json.Unmarshal(m.Data, &e)
+	err := s.Transport.Receive(ctx, p.Name, func(ctx context.Context, m *transport.Message) {
+		e, err := events.DecodeMessage(m.Data, m.Attributes)
 		if err != nil {
-			log.Printf("Failed to unmarshal pubsub message (%v)", err)
+			s.Logger.Error("Failed to unmarshal pubsub message", logging.Fields{
+				"subscription": p.Name,
+				"messageId":    m.ID,
+				"error":        err,
+			})
+			s.instruments.unmarshalFailed(p.Name)
 			m.Nack()
 			return
 		}
 
-		if p.HandleFunc(p.service, e) {
+		// Handlers may finish while receiving already stopped, see Shutdown.
+		o := p.service.dispatch(s.baseContext(), ackDeadline(p.AckDeadline), p.handler, e, &Delivery{
+			Subscription:    p.Name,
+			MessageID:       m.ID,
+			Attributes:      m.Attributes,
+			PublishTime:     m.PublishTime,
+			OrderingKey:     m.OrderingKey,
+			DeliveryAttempt: m.DeliveryAttempt,
+		})
+
+		if o.ack {
 			m.Ack()
-		} else {
-			m.Nack()
+			return
 		}
+
+		// A delay requested by NackAfter is up to the subscription's RetryPolicy
+		m.Nack()
 	})
 
 	if err != nil {
 		return fmt.Errorf("failed to listen for new messages (%v)", err)
 	}
 
-	log.Printf("Pubsub: Subscription (%s) listening to %s", p.Name, p.Topic)
 	return nil
 }
 
@@ -353,14 +424,15 @@ func (p *PullSubscription) GetName() string {
 	return p.Name
 }
 
-func deleteSubscription(s *Service, name string) error {
-	ctx := context.Background()
-
-	client, err := pubsub.NewClient(ctx, s.Env.ProjectID)
-	if err != nil {
-		return fmt.Errorf("failed to setup pubsub (%v)", err)
+// ackDeadline returns the configured deadline or the default one.
+func ackDeadline(d time.Duration) time.Duration {
+	if d == 0 {
+		return defaultAckDeadline
 	}
 
-	sub := client.Subscription(name)
-	return sub.Delete(ctx)
+	return d
+}
+
+func deleteSubscription(s *Service, name string) error {
+	return s.Transport.DeleteSubscription(context.Background(), name)
 }
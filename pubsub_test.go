@@ -0,0 +1,154 @@
+package surfkit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/surfkittest"
+)
+
+var quiet = logging.New(&logging.TextSink{W: ioutil.Discard}, logging.Critical)
+
+func nackAfterHour(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+	return surfkit.NackAfter(time.Hour, errors.New("busy"))
+}
+
+func TestPullNackAfterNacksRightAway(t *testing.T) {
+	s := &surfkit.Service{
+		Name:   "orders",
+		Logger: quiet,
+		Subscriptions: []surfkit.Subscription{
+			&surfkit.PullSubscription{Name: "orders", Topic: "orders.placed", Handler: nackAfterHour},
+		},
+	}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	start := time.Now()
+	acked, err := h.Inject("orders", events.NewCloudEvent("test", "order.placed", nil))
+	if err != nil || acked {
+		t.Fatalf("Inject = %v, %v, want a nack", acked, err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("nack took %v, want it right away", took)
+	}
+}
+
+func TestPushNackAfterRetryAfter(t *testing.T) {
+	s := &surfkit.Service{
+		Name:   "orders",
+		Logger: quiet,
+		Subscriptions: []surfkit.Subscription{
+			&surfkit.PushSubscription{Name: "orders", Topic: "orders.placed", Handler: nackAfterHour},
+		},
+	}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	m, err := events.EncodeMessage(events.NewCloudEvent("test", "order.placed", nil), events.StructuredMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var env surfkit.PubsubPushMessageEnvelope
+	env.Subscription = "projects/memory/subscriptions/orders"
+	env.Message.MessageID = "1"
+	env.Message.Attributes = m.Attributes
+	env.Message.Data = base64.StdEncoding.EncodeToString(m.Data)
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(h.URL+"/sk/v1/messages/orders", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotAcceptable || resp.Header.Get("Retry-After") != "3600" {
+		t.Errorf("response = %d, Retry-After %q, want %d with 3600", resp.StatusCode, resp.Header.Get("Retry-After"), http.StatusNotAcceptable)
+	}
+}
+
+// lockedBuffer is an io.Writer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestPushInvalidMessageLogsMessageID(t *testing.T) {
+	var logs lockedBuffer
+
+	s := &surfkit.Service{
+		Name:   "orders",
+		Logger: logging.New(&logging.JSONSink{W: &logs}, logging.Error),
+		Subscriptions: []surfkit.Subscription{
+			&surfkit.PushSubscription{Name: "orders", Topic: "orders.placed", Handler: nackAfterHour},
+		},
+	}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	var env surfkit.PubsubPushMessageEnvelope
+	env.Subscription = "projects/memory/subscriptions/orders"
+	env.Message.MessageID = "42"
+	env.Message.Data = "not base64"
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(h.URL+"/sk/v1/messages/orders", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("response = %d, want %d", resp.StatusCode, http.StatusNotAcceptable)
+	}
+	if !strings.Contains(logs.String(), `"messageId":"42"`) {
+		t.Errorf("logged %s, want the message ID", logs.String())
+	}
+}
@@ -0,0 +1,110 @@
+package surfkit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/oidc"
+)
+
+// PushAuth makes Pubsub sign its push requests with an OIDC token and rejects every
+// request to the push endpoint which doesn't carry a valid one.
+//
+// Learn more about this here
+// https://cloud.google.com/pubsub/docs/push#authentication_and_authorization
+type PushAuth struct {
+
+	// ServiceAccount email Pubsub issues the tokens to. Only tokens issued to it are accepted.
+	ServiceAccount string
+
+	// Audience the tokens are issued for. Defaults to the URL of the push endpoint.
+	Audience string
+
+	// KeyFile holds the keys tokens are verified with, either as JSON Web Key Set or PEM.
+	// Defaults to Google's keys, which are fetched and cached. Setting it allows to verify
+	// tokens offline, e.g. in tests.
+	KeyFile string
+}
+
+// verifier returns the oidc.Verifier checking tokens issued for audience.
+func (a *PushAuth) verifier(audience string) (*oidc.Verifier, error) {
+	if a.ServiceAccount == "" {
+		return nil, fmt.Errorf("push auth requires a service account")
+	}
+
+	return newVerifier(a.ServiceAccount, audience, a.KeyFile)
+}
+
+// EndpointAuth rejects every request to an endpoint which doesn't carry a Google signed
+// OIDC token issued to ServiceAccount, as NewAuthenticateableRequest sends them when
+// running as that service account.
+type EndpointAuth struct {
+
+	// ServiceAccount email the tokens must be issued to.
+	ServiceAccount string
+
+	// Audience the tokens are issued for. Defaults to the URL of the endpoint, which
+	// requires HOST to be set.
+	Audience string
+
+	// KeyFile holds the keys tokens are verified with, either as JSON Web Key Set or PEM.
+	// Defaults to Google's keys.
+	KeyFile string
+}
+
+// verifier returns the oidc.Verifier checking tokens for the endpoint at path.
+func (a *EndpointAuth) verifier(s *Service, path string) (*oidc.Verifier, error) {
+	if a.ServiceAccount == "" {
+		return nil, fmt.Errorf("endpoint auth requires a service account")
+	}
+
+	audience := a.Audience
+	if audience == "" {
+		if !strings.HasPrefix(s.Env.Host, "http") {
+			return nil, fmt.Errorf("endpoint auth requires an audience unless HOST is set")
+		}
+		audience = s.Env.Host + path
+	}
+
+	return newVerifier(a.ServiceAccount, audience, a.KeyFile)
+}
+
+func newVerifier(serviceAccount, audience, keyFile string) (*oidc.Verifier, error) {
+	v := &oidc.Verifier{
+		Audience: audience,
+		Email:    serviceAccount,
+	}
+
+	if keyFile != "" {
+		keys, err := oidc.ReadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.Keys = keys
+	}
+
+	return v, nil
+}
+
+// authenticate only passes requests with a valid bearer token on to next.
+func authenticate(v *oidc.Verifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			logging.FromContext(r.Context()).Warning("Rejecting request without token", logging.Fields{"path": r.URL.Path})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_, err := v.Verify(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			logging.FromContext(r.Context()).Warning("Rejecting request", logging.Fields{"path": r.URL.Path, "error": err})
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,55 @@
+package surfkit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+// reconcileSubscription creates the subscription if it doesn't exist. Otherwise, every setting
+// of the existing subscription which differs from cfg is updated. Differences which can't be
+// updated are logged, or, with Service.StrictSubscriptions, fail the setup.
+func reconcileSubscription(s *Service, name string, cfg transport.SubscriptionConfig) error {
+	ctx := s.baseContext()
+
+	existing, err := s.Transport.Subscription(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		return s.Transport.EnsureSubscription(ctx, name, cfg)
+	}
+
+	var fields []string
+	var drift []string
+
+	for _, c := range transport.Diff(*existing, cfg) {
+		if !c.Fixed {
+			s.Logger.Warning(fmt.Sprintf("Pubsub: Subscription (%s) %s differs and can't be changed", name, c), logging.Fields{
+				"subscription": name,
+				"field":        c.Field,
+			})
+			drift = append(drift, c.Field)
+			continue
+		}
+
+		s.Logger.Info(fmt.Sprintf("Pubsub: Subscription (%s) changing %s", name, c), logging.Fields{
+			"subscription": name,
+			"field":        c.Field,
+		})
+		fields = append(fields, c.Field)
+	}
+
+	if len(drift) > 0 && s.StrictSubscriptions {
+		return fmt.Errorf("subscription %s differs in %s", name, strings.Join(drift, ", "))
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return s.Transport.UpdateSubscription(ctx, name, cfg, fields)
+}
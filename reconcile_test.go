@@ -0,0 +1,72 @@
+package surfkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/transport"
+)
+
+func TestReconcileSubscription(t *testing.T) {
+	ctx := context.Background()
+	declared := transport.SubscriptionConfig{Topic: "orders", AckDeadline: time.Minute}
+
+	tests := []struct {
+		name     string
+		existing *transport.SubscriptionConfig
+		strict   bool
+		logged   []logging.Severity
+		fails    bool
+	}{
+		{"missing", nil, false, nil, false},
+		{"unchanged", &transport.SubscriptionConfig{Topic: "orders", AckDeadline: time.Minute}, false, nil, false},
+		{"changed", &transport.SubscriptionConfig{Topic: "orders", AckDeadline: time.Second}, false, []logging.Severity{logging.Info}, false},
+		{"drifted", &transport.SubscriptionConfig{Topic: "orders", Filter: `attributes:region`}, false, []logging.Severity{logging.Info, logging.Warning}, false},
+		{"strict", &transport.SubscriptionConfig{Topic: "orders", Filter: `attributes:region`}, true, []logging.Severity{logging.Info, logging.Warning}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := transport.NewMemory()
+			defer b.Close()
+
+			if tt.existing != nil {
+				if err := b.EnsureSubscription(ctx, "orders", *tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := &recorder{}
+			s := &Service{Transport: b, Logger: logging.New(rec, logging.Debug), StrictSubscriptions: tt.strict}
+
+			err := reconcileSubscription(s, "orders", declared)
+			if (err != nil) != tt.fails {
+				t.Fatalf("reconcileSubscription = %v, want failure %v", err, tt.fails)
+			}
+
+			var logged []logging.Severity
+			for _, e := range rec.entries {
+				logged = append(logged, e.Severity)
+			}
+			if len(logged) != len(tt.logged) {
+				t.Fatalf("logged %v, want %v", logged, tt.logged)
+			}
+			for i := range logged {
+				if logged[i] != tt.logged[i] {
+					t.Errorf("logged %v, want %v", logged, tt.logged)
+				}
+			}
+
+			// Changes are applied unless the subscription fails strictly
+			cfg, err := b.Subscription(ctx, "orders")
+			if err != nil || cfg == nil {
+				t.Fatalf("Subscription = %v, %v", cfg, err)
+			}
+			if want := tt.fails && tt.existing.AckDeadline != time.Minute; (cfg.AckDeadline != time.Minute) != want {
+				t.Errorf("ack deadline is %v after reconciling", cfg.AckDeadline)
+			}
+		})
+	}
+}
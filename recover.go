@@ -0,0 +1,65 @@
+package surfkit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// A Panic describes a panic surfkit recovered from, either in an event handler or in an
+// HTTP handler. See Service.OnPanic.
+type Panic struct {
+
+	// Value passed to panic.
+	Value interface{}
+
+	// Stack trace of the panicking goroutine.
+	Stack []byte
+
+	// Event and Delivery being handled, if the panic occurred in an event handler.
+	Event    *events.CloudEvent
+	Delivery *Delivery
+
+	// Request being served, if the panic occurred in an HTTP handler.
+	Request *http.Request
+}
+
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// reportPanic logs the stack trace of p and hands it to the service's OnPanic hook.
+// The entry looks like the output of an unrecovered panic, so Cloud Error Reporting picks it up.
+func (s *Service) reportPanic(ctx context.Context, p *Panic) {
+	logging.FromContext(ctx).Error(fmt.Sprintf("%v\n\n%s", p, p.Stack))
+
+	if s.OnPanic != nil {
+		s.OnPanic(p)
+	}
+}
+
+// recoverHTTP answers requests whose handler panics with 500 instead of dropping the connection.
+func recoverHTTP(s *Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			// Used by handlers to abort a response on purpose.
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			s.reportPanic(r.Context(), &Panic{Value: v, Stack: debug.Stack(), Request: r})
+			w.WriteHeader(http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
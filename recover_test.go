@@ -0,0 +1,144 @@
+package surfkit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+)
+
+// panicService returns a service collecting the panics it recovers from.
+func panicService() (*Service, *recorder, *[]*Panic) {
+	rec := &recorder{}
+	var panics []*Panic
+
+	s := &Service{
+		Logger:  logging.New(rec, logging.Debug),
+		OnPanic: func(p *Panic) { panics = append(panics, p) },
+	}
+
+	return s, rec, &panics
+}
+
+func TestRecoverHTTP(t *testing.T) {
+	s, rec, panics := panicService()
+
+	h := withLogger(s, recoverHTTP(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	r := httptest.NewRequest("GET", "/orders", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("panicking handler responded %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	if len(*panics) != 1 {
+		t.Fatalf("OnPanic called %d times, want once", len(*panics))
+	}
+	p := (*panics)[0]
+	if p.Value != "boom" || p.Request == nil || p.Request.URL.Path != "/orders" || p.Event != nil {
+		t.Errorf("OnPanic got %+v, want the value and the request", p)
+	}
+	if !bytes.Contains(p.Stack, []byte("recover_test.go")) {
+		t.Errorf("stack doesn't lead to the panicking handler:\n%s", p.Stack)
+	}
+
+	if len(rec.entries) != 1 || rec.entries[0].Severity != logging.Error || !strings.HasPrefix(rec.entries[0].Message, "panic: boom\n\ngoroutine ") {
+		t.Errorf("logged %+v, want the panic with its stack", rec.entries)
+	}
+}
+
+func TestRecoverHTTPAbortHandler(t *testing.T) {
+	s, _, panics := panicService()
+
+	h := recoverHTTP(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler to be passed on", v)
+		}
+		if len(*panics) != 0 {
+			t.Errorf("aborting a response reported %d panics", len(*panics))
+		}
+	}()
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestRecoverEventHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler EventHandler
+		value   interface{}
+		event   bool
+	}{
+		{
+			"panic",
+			func(ctx context.Context, s *Service, e *events.CloudEvent) error { panic("boom") },
+			"boom",
+			true,
+		},
+		{
+			"formatted panic",
+			func(ctx context.Context, s *Service, e *events.CloudEvent) error {
+				return fmt.Errorf("handler failed (%v)", &Panic{Value: "caught", Stack: []byte("recover_test.go")})
+			},
+			nil,
+			false,
+		},
+		{
+			"wrapped by middleware",
+			func(ctx context.Context, s *Service, e *events.CloudEvent) error {
+				return &caused{&Panic{Value: "caught", Stack: []byte("recover_test.go")}}
+			},
+			"caught",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, panics := panicService()
+
+			e := events.NewCloudEvent("test", "order.placed", nil)
+			d := &Delivery{Subscription: "orders", MessageID: "1"}
+			ctx := logging.NewContext(context.Background(), s.Logger)
+
+			o := s.dispatch(ctx, time.Second, tt.handler, &e, d)
+			if o.ack {
+				t.Error("panicking handler acked, want a nack")
+			}
+
+			// Errors only formatting a panic don't carry it
+			if tt.value == nil {
+				if len(*panics) != 0 {
+					t.Errorf("OnPanic called %d times, want never", len(*panics))
+				}
+				return
+			}
+
+			if len(*panics) != 1 {
+				t.Fatalf("OnPanic called %d times, want once", len(*panics))
+			}
+			p := (*panics)[0]
+			if p.Value != tt.value || !bytes.Contains(p.Stack, []byte("recover_test.go")) {
+				t.Errorf("OnPanic got value %v and stack\n%s", p.Value, p.Stack)
+			}
+			if tt.event && (p.Event != &e || p.Delivery != d) {
+				t.Errorf("OnPanic got event %v of %v, want %s", p.Event, p.Delivery, e.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// SecretManager resolves references to secrets in Google Secret Manager, either as
+// sm://PROJECT/SECRET, optionally followed by #VERSION, or by their resource name as
+// sm://projects/PROJECT/secrets/SECRET/versions/VERSION. The version defaults to latest.
+type SecretManager struct {
+
+	// Client the API is called with. Defaults to a client using the default credentials.
+	Client *http.Client
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+// Resolve accesses the secret version ref points to.
+func (m *SecretManager) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	name, err := secretVersionName(ref)
+	if err != nil {
+		return "", err
+	}
+
+	client, err := m.httpClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://secretmanager.googleapis.com/v1/%s:access", name), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("accessing %s responded with %d: %s", name, resp.StatusCode, b)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	err = json.Unmarshal(b, &version)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret version %s (%v)", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret version %s (%v)", name, err)
+	}
+
+	return string(data), nil
+}
+
+func (m *SecretManager) httpClient(ctx context.Context) (*http.Client, error) {
+	if m.Client != nil {
+		return m.Client, nil
+	}
+
+	m.once.Do(func() {
+		m.client, m.err = google.DefaultClient(context.Background(), cloudPlatformScope)
+	})
+
+	return m.client, m.err
+}
+
+// secretVersionName returns the resource name of the secret version ref points to.
+func secretVersionName(ref *url.URL) (string, error) {
+	parts := strings.Split(strings.Trim(ref.Host+ref.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 2:
+		version := ref.Fragment
+		if version == "" {
+			version = "latest"
+		}
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", parts[0], parts[1], version), nil
+
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		return strings.Join(parts, "/"), nil
+
+	default:
+		return "", fmt.Errorf("invalid secret reference %s", ref)
+	}
+}
+
+// FileResolver is a local stand-in for a secret store, reading the referenced secrets from
+// files below Dir: sm://my-project/db-password is read from Dir/my-project/db-password.
+type FileResolver struct {
+	Dir string
+}
+
+// Resolve reads the file ref points to.
+func (f *FileResolver) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	rel := filepath.FromSlash(strings.Trim(ref.Host+ref.Path, "/"))
+	if rel == "" || rel != filepath.Clean(rel) || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid secret reference %s", ref)
+	}
+
+	return ReadFile(filepath.Join(f.Dir, rel))
+}
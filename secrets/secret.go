@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Redacted is shown instead of the value of a secret.
+const Redacted = "[REDACTED]"
+
+// A Secret holds a sensitive value which can be refreshed from its source. Other than by
+// Value, it never reveals the value: it is redacted when printed, logged or marshalled.
+type Secret struct {
+	source func(ctx context.Context) (string, error)
+
+	mu    sync.RWMutex
+	value string
+}
+
+// NewSecret returns a Secret reading its value from source. It is empty until refreshed.
+func NewSecret(source func(ctx context.Context) (string, error)) *Secret {
+	return &Secret{source: source}
+}
+
+// Value returns the secret value.
+func (s *Secret) Value() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.value
+}
+
+// Refresh reads the value from the source again. The previous value is kept if this fails.
+func (s *Secret) Refresh(ctx context.Context) error {
+	if s.source == nil {
+		return nil
+	}
+
+	value, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.value = value
+	return nil
+}
+
+// String returns Redacted.
+func (s *Secret) String() string {
+	return Redacted
+}
+
+// GoString returns Redacted.
+func (s *Secret) GoString() string {
+	return Redacted
+}
+
+// MarshalText returns Redacted.
+func (s *Secret) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// MarshalJSON returns Redacted as JSON string.
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
@@ -0,0 +1,95 @@
+// Package secrets resolves references to secrets and keeps their values out of logs.
+//
+// A configuration value like sm://my-project/db-password is a reference, which is resolved
+// by the Resolver registered for its scheme. Secret Manager is registered for sm:// by
+// default. To develop locally, read the secrets from files instead:
+//
+//	secrets.Register("sm", &secrets.FileResolver{Dir: "./secrets"})
+//
+// Values of other schemes or without scheme are no references and used as they are.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A Resolver looks up the value of a secret reference.
+// Implementations must be safe for concurrent use.
+type Resolver interface {
+	Resolve(ctx context.Context, ref *url.URL) (string, error)
+}
+
+var (
+	mu        sync.RWMutex
+	resolvers = map[string]Resolver{
+		"sm": &SecretManager{},
+	}
+)
+
+// Register makes r resolve references of scheme, replacing any resolver registered before.
+func Register(scheme string, r Resolver) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	resolvers[strings.ToLower(scheme)] = r
+}
+
+// resolver returns the resolver for the reference value is, if any.
+func resolver(value string) (Resolver, *url.URL) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return nil, nil
+	}
+
+	mu.RLock()
+	r, ok := resolvers[strings.ToLower(value[:i])]
+	mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	ref, err := url.Parse(value)
+	if err != nil {
+		return nil, nil
+	}
+
+	return r, ref
+}
+
+// IsReference reports whether value refers to a secret of a registered scheme.
+func IsReference(value string) bool {
+	r, _ := resolver(value)
+	return r != nil
+}
+
+// Resolve returns the secret value refers to, or value as is if it is no reference.
+func Resolve(ctx context.Context, value string) (string, error) {
+	r, ref := resolver(value)
+	if r == nil {
+		return value, nil
+	}
+
+	secret, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s (%v)", value, err)
+	}
+
+	return secret, nil
+}
+
+// ReadFile reads a secret from a file, e.g. one mounted by Cloud Run, without the line
+// break the file might end with.
+func ReadFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
@@ -0,0 +1,288 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticSource(value string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return value, nil
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	secret := NewSecret(staticSource("s3cr3t"))
+	if err := secret.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if secret.Value() != "s3cr3t" {
+		t.Fatalf("Value = %q, want s3cr3t", secret.Value())
+	}
+
+	config := struct {
+		Token *Secret `json:"token"`
+		Name  string  `json:"name"`
+	}{secret, "orders"}
+
+	text, err := secret.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText failed: %v", err)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	if want := `{"token":"[REDACTED]","name":"orders"}`; string(b) != want {
+		t.Errorf("json.Marshal = %s, want %s", b, want)
+	}
+
+	// Secrets as map keys are marshalled as text
+	keys, err := json.Marshal(map[*Secret]int{secret: 1})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+
+	outputs := map[string]string{
+		"MarshalText":  string(text),
+		"map key":      string(keys),
+		"%v":           fmt.Sprintf("%v", secret),
+		"%s":           fmt.Sprintf("%s", secret),
+		"%#v":          fmt.Sprintf("%#v", secret),
+		"%v struct":    fmt.Sprintf("%v", config),
+		"%+v struct":   fmt.Sprintf("%+v", config),
+		"%#v struct":   fmt.Sprintf("%#v", config),
+		"%v in a list": fmt.Sprintf("%v", []*Secret{secret}),
+	}
+
+	for name, out := range outputs {
+		if strings.Contains(out, "s3cr3t") {
+			t.Errorf("%s reveals the secret: %s", name, out)
+		}
+		if !strings.Contains(out, Redacted) {
+			t.Errorf("%s = %s, want it redacted", name, out)
+		}
+	}
+}
+
+func TestSecretRefresh(t *testing.T) {
+	var value string
+	var err error
+
+	secret := NewSecret(func(ctx context.Context) (string, error) {
+		return value, err
+	})
+
+	if secret.Value() != "" {
+		t.Errorf("Value before Refresh = %q, want it empty", secret.Value())
+	}
+
+	value = "v1"
+	if err := secret.Refresh(context.Background()); err != nil || secret.Value() != "v1" {
+		t.Errorf("Refresh = %v, Value = %q, want v1", err, secret.Value())
+	}
+
+	// A failed refresh keeps the previous value
+	value, err = "", errors.New("unavailable")
+	if err := secret.Refresh(context.Background()); err == nil || secret.Value() != "v1" {
+		t.Errorf("Refresh = %v, Value = %q, want an error and v1", err, secret.Value())
+	}
+
+	var unset *Secret
+	if unset.Value() != "" {
+		t.Errorf("Value of a nil secret = %q, want it empty", unset.Value())
+	}
+}
+
+// resolverFunc implements Resolver.
+type resolverFunc func(ctx context.Context, ref *url.URL) (string, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, ref *url.URL) (string, error) {
+	return f(ctx, ref)
+}
+
+func TestResolve(t *testing.T) {
+	Register("SKTEST", resolverFunc(func(ctx context.Context, ref *url.URL) (string, error) {
+		if ref.Host == "missing" {
+			return "", errors.New("not found")
+		}
+		return "value of " + ref.Host + ref.Path, nil
+	}))
+
+	tests := []struct {
+		value   string
+		want    string
+		ref     bool
+		wantErr bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "", want: ""},
+		{value: "https://example.com/orders", want: "https://example.com/orders"},
+		{value: "://nothing", want: "://nothing"},
+		{value: "sktest://orders/password", want: "value of orders/password", ref: true},
+		{value: "SKTest://orders/password", want: "value of orders/password", ref: true},
+		{value: "sktest://missing/password", ref: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if ref := IsReference(tt.value); ref != tt.ref {
+			t.Errorf("IsReference(%q) = %v, want %v", tt.value, ref, tt.ref)
+		}
+
+		got, err := Resolve(context.Background(), tt.value)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), tt.value) {
+				t.Errorf("Resolve(%q) error = %v, want one naming the reference", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSecretVersionName(t *testing.T) {
+	tests := map[string]string{
+		"sm://my-project/db-password":                                     "projects/my-project/secrets/db-password/versions/latest",
+		"sm://my-project/db-password#3":                                   "projects/my-project/secrets/db-password/versions/3",
+		"sm://projects/my-project/secrets/db-password/versions/2":         "projects/my-project/secrets/db-password/versions/2",
+		"sm://my-project":                                                 "",
+		"sm://my-project/db-password/extra":                               "",
+		"sm://projects/my-project/secrets/db-password/aliases/production": "",
+	}
+
+	for raw, want := range tests {
+		got, err := secretVersionName(mustParse(t, raw))
+		if want == "" {
+			if err == nil {
+				t.Errorf("secretVersionName(%s) = %s, want an error", raw, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("secretVersionName(%s) = %s, %v, want %s", raw, got, err, want)
+		}
+	}
+}
+
+// roundTripFunc implements http.RoundTripper.
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestSecretManager(t *testing.T) {
+	responses := map[string]struct {
+		status int
+		body   string
+	}{
+		"projects/my-project/secrets/db-password/versions/latest": {http.StatusOK, `{"payload":{"data":"` + base64.StdEncoding.EncodeToString([]byte("hunter2")) + `"}}`},
+		"projects/my-project/secrets/invalid/versions/latest":     {http.StatusOK, `{"payload":{"data":"not base64"}}`},
+		"projects/my-project/secrets/missing/versions/latest":     {http.StatusNotFound, `{"error":{"code":404}}`},
+	}
+
+	var accessed []string
+	m := &SecretManager{Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		accessed = append(accessed, req.URL.String())
+
+		name := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/"), ":access")
+		res, ok := responses[name]
+		if !ok {
+			res.status = http.StatusNotFound
+		}
+
+		w := httptest.NewRecorder()
+		w.WriteHeader(res.status)
+		w.WriteString(res.body)
+		return w.Result(), nil
+	})}}
+
+	ctx := context.Background()
+
+	secret, err := m.Resolve(ctx, mustParse(t, "sm://my-project/db-password"))
+	if err != nil || secret != "hunter2" {
+		t.Errorf("Resolve = %q, %v, want hunter2", secret, err)
+	}
+	if want := "https://secretmanager.googleapis.com/v1/projects/my-project/secrets/db-password/versions/latest:access"; len(accessed) != 1 || accessed[0] != want {
+		t.Errorf("accessed %v, want %s", accessed, want)
+	}
+
+	for _, raw := range []string{"sm://my-project/missing", "sm://my-project/invalid", "sm://my-project"} {
+		if secret, err := m.Resolve(ctx, mustParse(t, raw)); err == nil {
+			t.Errorf("Resolve(%s) = %q, want an error", raw, secret)
+		}
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "my-project"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"my-project/db-password": "hunter2\n",
+		"my-project/api-key":     "s3cr3t\r\n",
+		"outside":                "leaked",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := &FileResolver{Dir: filepath.Join(dir, "my-project")}
+
+	tests := map[string]string{
+		"sm:///db-password":       "hunter2",
+		"sm:///api-key":           "s3cr3t",
+		"sm://db-password":        "hunter2",
+		"sm:///missing":           "",
+		"sm:///../outside":        "",
+		"sm://":                   "",
+		"sm:///nested/../api-key": "",
+	}
+
+	for raw, want := range tests {
+		got, err := f.Resolve(context.Background(), mustParse(t, raw))
+		if want == "" {
+			if err == nil {
+				t.Errorf("Resolve(%s) = %q, want an error", raw, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+
+	ref, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ref
+}
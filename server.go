@@ -3,49 +3,84 @@ package surfkit
 import (
 	"context"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/tracing"
 )
 
 const defaultTimeout = 60 * time.Second
 
 func setupServer(s *Service) {
 	s.Router = mux.NewRouter()
-	s.Router.HandleFunc("/", healthEndpoint).Methods("GET")
+	s.Router.HandleFunc("/", s.healthEndpoint).Methods("GET")
 
 	s.SrvHandler = s.Router
 }
 
-func healthEndpoint(w http.ResponseWriter, r *http.Request) {
+// healthEndpoint fails once the service is shutting down, so no new traffic is routed to it.
+// It is kept for backwards compatibility, see LivenessPath and ReadinessPath for checks of
+// the service's dependencies.
+func (s *Service) healthEndpoint(w http.ResponseWriter, r *http.Request) {
+	if s.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// enableServer prepares the webserver and binds its listener. Serving is up to the caller.
 func enableServer(s *Service) error {
 
 	timeout := getTimeout(s)
 
 	s.Srv = &http.Server{
-		Handler:      s.SrvHandler,
+		Handler:      traceHTTP(s, withLogger(s, instrumentHTTP(s, recoverHTTP(s, s.SrvHandler)))),
 		Addr:         fmt.Sprintf(":%s", s.Env.Port),
 		WriteTimeout: timeout,
 		ReadTimeout:  timeout,
 	}
 
-	log.Printf("Server enabled on port %s", s.Env.Port)
-	return s.Srv.ListenAndServe()
+	if s.Listener == nil {
+		l, err := net.Listen("tcp", s.Srv.Addr)
+		if err != nil {
+			return err
+		}
+		s.Listener = l
+	}
+
+	s.Logger.Info(fmt.Sprintf("Server enabled on %s", s.Listener.Addr()), logging.Fields{"addr": s.Listener.Addr().String()})
+	return nil
 }
 
-func shutdownServer(s *Service) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer func() { cancel() }()
+// withLogger hands next a request scoped logger, linked to the request's trace.
+func withLogger(s *Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := s.Logger
+		if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.WithTrace(sc.TraceID, sc.SpanID)
+		} else if trace, spanID := logging.TraceFromRequest(r); trace != "" {
+			logger = logger.WithTrace(trace, spanID)
+		}
 
+		next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), logger)))
+	})
+}
+
+// shutdownServer stops accepting connections and waits for in-flight requests until ctx is
+// done. Requests still running then are cut off.
+func shutdownServer(ctx context.Context, s *Service) error {
 	err := s.Srv.Shutdown(ctx)
 	if err != nil {
-		log.Fatalf("Server Shutdown Failed: %+v", err)
+		s.Srv.Close()
+		return fmt.Errorf("server shutdown failed (%v)", err)
 	}
+
+	return nil
 }
 
 // getTimeout from user configuration or take defaults
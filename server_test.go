@@ -0,0 +1,105 @@
+package surfkit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/tracing"
+)
+
+func TestRequestLogsCorrelateTraces(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name          string
+		header, value string
+		tracer        bool
+		trace, span   string
+	}{
+		{"traceparent", "traceparent", "00-" + traceID + "-" + spanID + "-01", false, traceID, spanID},
+		{"cloud trace", "X-Cloud-Trace-Context", traceID + "/1;o=1", false, traceID, "0000000000000001"},
+		{"none", "", "", false, "", ""},
+		{"server span", "traceparent", "00-" + traceID + "-" + spanID + "-01", true, traceID, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			s := &Service{Router: mux.NewRouter(), Logger: logging.New(rec, logging.Debug)}
+			if tt.tracer {
+				s.Tracer = tracing.NewTracer(&tracing.MemoryExporter{})
+			}
+
+			var sc tracing.SpanContext
+			h := traceHTTP(s, withLogger(s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sc = tracing.SpanContextFromContext(r.Context())
+				logging.FromContext(r.Context()).Info("Handled")
+			})))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if len(rec.entries) != 1 {
+				t.Fatalf("logged %d entries, want 1", len(rec.entries))
+			}
+
+			// With a tracer, entries are linked to the server span continuing the trace
+			want := tt.span
+			if tt.tracer {
+				want = sc.SpanID
+				if want == spanID {
+					t.Error("no server span started")
+				}
+			}
+
+			if e := rec.entries[0]; e.Trace != tt.trace || e.SpanID != want {
+				t.Errorf("entry linked to %q/%q, want %q/%q", e.Trace, e.SpanID, tt.trace, want)
+			}
+		})
+	}
+}
+
+func TestEventLogsCorrelateTraces(t *testing.T) {
+	rec := &recorder{}
+	s := &Service{Logger: logging.New(rec, logging.Debug)}
+
+	e := events.NewCloudEvent("test", "order.placed", nil)
+	e.SetExtension(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	h := func(ctx context.Context, s *Service, e *events.CloudEvent) error {
+		logging.FromContext(ctx).Info("Handled")
+		return nil
+	}
+
+	ctx := logging.NewContext(context.Background(), s.Logger)
+	s.dispatch(ctx, time.Second, h, &e, &Delivery{Subscription: "orders", MessageID: "1"})
+
+	var handled *logging.Entry
+	for _, entry := range rec.entries {
+		if entry.Message == "Handled" {
+			handled = entry
+		}
+	}
+	if handled == nil {
+		t.Fatalf("logged %v, want the handler's entry", rec.entries)
+	}
+
+	if handled.Trace != "4bf92f3577b34da6a3ce929d0e0e4736" || handled.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("entry linked to %s/%s, want the event's trace", handled.Trace, handled.SpanID)
+	}
+	if handled.Fields["subscription"] != "orders" || handled.Fields["messageId"] != "1" || handled.Fields["eventType"] != "order.placed" {
+		t.Errorf("entry fields = %v, want the delivery's", handled.Fields)
+	}
+}
@@ -1,16 +1,26 @@
 package surfkit
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/metrics"
+	"github.com/helloink/surfkit/outbox"
+	"github.com/helloink/surfkit/tracing"
+	"github.com/helloink/surfkit/transport"
 )
 
 // A Service defines the application running
@@ -28,6 +38,18 @@ type Service struct {
 
 	Subscriptions []Subscription
 
+	// EventMiddleware wraps the handlers of all subscriptions.
+	EventMiddleware []EventMiddleware
+
+	// OnPanic is called whenever surfkit recovered from a panic in an event or HTTP handler,
+	// e.g. to report it to an error tracker. The message is nacked, the request answered with 500.
+	OnPanic func(p *Panic)
+
+	// StrictSubscriptions refuses to start if an existing subscription differs from its
+	// declaration in a way which can't be fixed, e.g. it is attached to a different topic.
+	// Otherwise such differences are only logged.
+	StrictSubscriptions bool
+
 	// Defines the services (Pubsub) output.
 	Output *Output
 
@@ -42,6 +64,14 @@ type Service struct {
 	// SrvTimeout sets the read & write timeouts of the underlying webserver
 	SrvTimeout time.Duration
 
+	// DrainTimeout limits how long the shutdown waits for in-flight requests and event
+	// handlers to finish before cancelling them. Defaults to 10 seconds.
+	DrainTimeout time.Duration
+
+	// Listener the webserver accepts connections on. If not set, the service
+	// listens on the port read from the environment.
+	Listener net.Listener
+
 	// SrvHandler allows to set the request handler. If set, make sure it
 	// eventually wraps service.Router.
 	SrvHandler http.Handler
@@ -52,36 +82,395 @@ type Service struct {
 
 	Publishers map[string]*events.Publisher
 
+	// Transport moves events between services. Defaults to Google Cloud Pubsub in the project
+	// read from PUBSUB_PROJECT_ID. Use transport.NewMemory to run without GCP.
+	Transport transport.Transport
+
 	// Env contains configuration read from the environment and is automatically set
 	Env *ServiceEnv
+
+	// Config points to a struct describing the service's own configuration, which is
+	// loaded with LoadConfig before the runloopFn is called.
+	Config interface{}
+
+	// SecretRefresh is the interval the secrets of Config are refreshed in, see
+	// RefreshSecrets. Secrets aren't refreshed if not set.
+	SecretRefresh time.Duration
+
+	// ConfigEndpoint serves the effective configuration of the service on ConfigPath, as it
+	// is logged at boot, to callers it authenticates. Secrets are redacted, but the report
+	// still reveals the service's internals. The endpoint is disabled if not set.
+	ConfigEndpoint *EndpointAuth
+
+	// Logger all messages of the service go through. Defaults to JSON in the format of
+	// Cloud Logging on stdout. Handlers get a logger scoped to the request or event
+	// they handle from logging.FromContext.
+	Logger *logging.Logger
+
+	// Metrics enables the built-in HTTP, event and publishing metrics and serves them on
+	// MetricsPath in the Prometheus text format. Register the service's own metrics with it.
+	Metrics *metrics.Registry
+
+	// Tracer records spans around HTTP and event handlers and publishes. Trace context
+	// is passed on from incoming requests and events to published events even without one.
+	Tracer *tracing.Tracer
+
+	// HealthChecks run by the readiness endpoint on ReadinessPath and, if marked as
+	// Liveness, by the liveness endpoint on LivenessPath, in addition to the built-in ones.
+	HealthChecks []HealthCheck
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// receiving is cancelled as soon as the shutdown starts, so no new messages are pulled.
+	receiving     context.Context
+	stopReceiving context.CancelFunc
+
+	// draining is set once the shutdown started, failing readiness.
+	draining int32
+
+	// inflight tracks the event handlers being run.
+	inflight tracker
+
+	// listeners tracks which pull subscriptions are listening, for readiness.
+	listeners listenerStates
+
+	// topics caches which output topics exist, for readiness.
+	topics topicStates
+
+	// ownsTransport is set if surfkit created the Transport and is in charge of closing it.
+	ownsTransport bool
+
+	// wg tracks the webserver and all listening subscriptions.
+	wg sync.WaitGroup
+
+	// errs receives the first runtime error.
+	errs chan error
+
+	// relays publish the events of outputs with an outbox, by event type.
+	relays map[string]*outbox.Relay
+
+	// instruments record the built-in metrics, if enabled.
+	instruments *serviceMetrics
 }
 
+const (
+	// outboxFlushTimeout limits how long the teardown waits for outboxes to be relayed.
+	outboxFlushTimeout = 10 * time.Second
+
+	defaultDrainTimeout = 10 * time.Second
+)
+
 // Run executes the service's run loop.
 //
 // It will first do required setup, next run the passed function
-// and eventually handle its teardown.
+// and eventually handle its teardown once SIGINT or SIGTERM is received.
+// Any error is fatal, use RunContext to handle errors yourself.
 func Run(s *Service, fn func()) {
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	err := RunContext(ctx, s, fn)
+	if err != nil {
+		s.logger().Critical(err.Error(), logging.Fields{"error": err})
+		os.Exit(1)
+	}
+}
+
+// RunContext executes the service's run loop until ctx is done.
+//
+// Other than Run, it doesn't listen for signals and returns all errors happening
+// during setup or while the service is running. A runtime error, e.g. a failing
+// subscription, shuts the service down. RunContext returns after the webserver,
+// all subscriptions and all publishers have stopped.
+func RunContext(ctx context.Context, s *Service, fn func()) error {
+	err := Start(s, fn)
+	if err != nil {
+		return err
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-s.errs:
+		s.logger().Error("Service failed", logging.Fields{"error": runErr})
+	}
+
+	err = Shutdown(s)
+	if runErr != nil {
+		return runErr
+	}
+
+	return err
+}
+
+// Start does the same setup as Run, but returns as soon as the service is up
+// instead of waiting for a signal. Use Shutdown to eventually stop the service.
+func Start(s *Service, fn func()) error {
 	var err error
 
-	log.Printf("Booting %s v%s (surfkit %s)", s.Name, s.Version, version)
+	if s.Logger == nil {
+		s.Logger = defaultLogger(s)
+	}
+
+	s.logger().Info(fmt.Sprintf("Booting %s v%s (surfkit %s)", s.Name, s.Version, version), logging.Fields{
+		"service": s.Name,
+		"version": s.Version,
+		"surfkit": version,
+	})
+
+	// The service's context is cancelled as soon as the teardown starts.
+	// It carries the logger, so every context derived from it does so as well.
+	s.ctx, s.cancel = context.WithCancel(logging.NewContext(context.Background(), s.Logger))
+	s.receiving, s.stopReceiving = context.WithCancel(s.ctx)
+	atomic.StoreInt32(&s.draining, 0)
+	s.errs = make(chan error, 1)
+
+	err = setup(s, fn)
+	if err != nil {
+		abort(s)
+		return err
+	}
+
+	logConfig(s)
+
+	// Enable Pubsub Listening
+	for _, sub := range pubsubSubscriptions(s) {
+		s.wg.Add(1)
+		go func(s *Service, sub Subscription) {
+			defer s.wg.Done()
+
+			s.listeners.set(sub.GetName(), true)
+			defer s.listeners.set(sub.GetName(), false)
+
+			err := sub.Listen(s)
+			if err != nil {
+				s.fail(fmt.Errorf("failed to listen on Pubsub (%v)", err))
+			}
+		}(s, sub)
+	}
+
+	// Relay events from outboxes
+	for _, relay := range s.relays {
+		s.wg.Add(1)
+		go func(relay *outbox.Relay) {
+			defer s.wg.Done()
+			relay.Run(s.baseContext())
+		}(relay)
+	}
+
+	// Refresh secrets
+	if s.Config != nil && s.SecretRefresh > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			refreshSecrets(s)
+		}()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		err := s.Srv.Serve(s.Listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.fail(fmt.Errorf("failed to run webserver (%v)", err))
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown gracefully stops a service booted by Start. It returns once the webserver,
+// all subscriptions and all publishers have stopped.
+//
+// First, readiness fails and pull subscriptions stop receiving new messages. Next, in-flight
+// requests and event handlers get up to DrainTimeout to finish, after which the remaining
+// ones are cancelled. Finally, outboxes and publishers are flushed and subscriptions torn down.
+func Shutdown(s *Service) error {
+	var errs []error
+	logger := s.logger()
+	start := time.Now()
+
+	logger.Info("Initiating Teardown...")
+
+	// Fail readiness and stop pulling new messages
+	atomic.StoreInt32(&s.draining, 1)
+	s.stopReceiving()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout(s))
+	defer cancel()
+
+	// Let in-flight requests, including push deliveries, finish
+	phase := time.Now()
+	err := shutdownServer(ctx, s)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	logPhase(logger, "server", phase)
+
+	// Let in-flight event handlers finish
+	phase = time.Now()
+	select {
+	case <-s.inflight.idle():
+	case <-ctx.Done():
+		logger.Warning("Drain timeout passed, cancelling event handlers", logging.Fields{"handlers": s.inflight.count()})
+	}
+	logPhase(logger, "handlers", phase)
+
+	// Cancel what's left and wait for the webserver and all listening subscriptions to return
+	phase = time.Now()
+	s.cancel()
+	s.wg.Wait()
+	logPhase(logger, "listeners", phase)
+
+	phase = time.Now()
+	errs = append(errs, s.teardown()...)
+	logPhase(logger, "teardown", phase)
+
+	logger.Info("Good bye.", logging.Fields{"duration": time.Since(start)})
+	return joinErrors(errs)
+}
+
+// logPhase logs how long a phase of the shutdown took.
+func logPhase(logger *logging.Logger, phase string, start time.Time) {
+	d := time.Since(start)
+	logger.Info(fmt.Sprintf("Shutdown: %s done in %s", phase, d), logging.Fields{"phase": phase, "duration": d})
+}
+
+// refreshSecrets refreshes the secrets of s.Config every s.SecretRefresh until the
+// service shuts down.
+func refreshSecrets(s *Service) {
+	ticker := time.NewTicker(s.SecretRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.baseContext().Done():
+			return
+		case <-ticker.C:
+			err := RefreshSecrets(s.baseContext(), s.Config)
+			if err != nil {
+				s.Logger.Warning("Failed to refresh secrets", logging.Fields{"error": err})
+			}
+		}
+	}
+}
+
+// drainTimeout from user configuration or take defaults
+func drainTimeout(s *Service) time.Duration {
+	if s.DrainTimeout == 0 {
+		return defaultDrainTimeout
+	}
+
+	return s.DrainTimeout
+}
+
+// isDraining reports whether the service is shutting down.
+func (s *Service) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// Teardown is called so the service can do cleanup work before finally going down.
+func (s *Service) Teardown() {
+	s.teardown()
+}
+
+// teardown stops all publishers, cleans up subscriptions and reports what failed.
+func (s *Service) teardown() []error {
+	var errs []error
+
+	// Relay what is left in the outboxes before the publishers stop
+	for eventType, relay := range s.relays {
+		ctx, cancel := context.WithTimeout(context.Background(), outboxFlushTimeout)
+		err := relay.Flush(ctx)
+		cancel()
+		if err != nil {
+			s.logger().Error("Failed to flush outbox", logging.Fields{"eventType": eventType, "error": err})
+			errs = append(errs, fmt.Errorf("failed to flush outbox of %s (%v)", eventType, err))
+		}
+	}
+
+	// Stop Publishers. s.Publisher is part of s.Publishers unless set by hand.
+	publishers := make([]*events.Publisher, 0, len(s.Publishers)+1)
+	for _, p := range s.Publishers {
+		publishers = append(publishers, p)
+	}
+	if s.Publisher != nil && s.Publishers[s.Publisher.Topic] != s.Publisher {
+		publishers = append(publishers, s.Publisher)
+	}
+
+	for _, p := range publishers {
+		err := p.Stop()
+		if err != nil {
+			s.logger().Error("Failed to stop publisher", logging.Fields{"topic": p.Topic, "error": err})
+			errs = append(errs, err)
+		}
+	}
+
+	// Cleanup Subscriptions
+	for _, sub := range pubsubSubscriptions(s) {
+		err := sub.Teardown(s)
+		if err != nil {
+			s.logger().Error("Failed to teardown subscription", logging.Fields{"subscription": sub.GetName(), "error": err})
+			errs = append(errs, fmt.Errorf("failed to teardown subscription %s (%v)", sub.GetName(), err))
+		}
+	}
+
+	if s.ownsTransport {
+		err := s.Transport.Close()
+		if err != nil {
+			s.logger().Error("Failed to close transport", logging.Fields{"error": err})
+			errs = append(errs, fmt.Errorf("failed to close transport (%v)", err))
+		}
+	}
+
+	return errs
+}
+
+// setup prepares everything the service needs and invokes the main service func.
+func setup(s *Service, fn func()) error {
+	var err error
 
 	// Make sure all required information is available in the environment
-	assertEnvironment(s)
+	err = assertEnvironment(s)
+	if err != nil {
+		return err
+	}
+
+	if s.Config != nil {
+		err = LoadConfig(s.Config)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Setup the router so the service can attach handlers
 	setupServer(s)
+	setupHealth(s)
+	setupMetrics(s)
+	err = setupConfigEndpoint(s)
+	if err != nil {
+		return err
+	}
+
+	// Connect to Pubsub, unless a Transport has been set
+	err = setupTransport(s)
+	if err != nil {
+		return fmt.Errorf("failed to setup transport (%v)", err)
+	}
 
 	// Setup the Pubsub subscription
 	for _, sub := range pubsubSubscriptions(s) {
 
 		// Subscription Naming is an important thing...
 		if sub.GetName() == "" {
-			log.Fatal("Every Pubsub Subscription must have a name set.")
+			return errors.New("every Pubsub Subscription must have a name set")
 		}
 
 		err = sub.Setup(s)
 		if err != nil {
-			log.Fatal("Failed to setup Pubsub: ", err)
+			return fmt.Errorf("failed to setup Pubsub (%v)", err)
 		}
 	}
 
@@ -89,70 +478,158 @@ func Run(s *Service, fn func()) {
 	s.Publishers = make(map[string]*events.Publisher)
 	if s.Output != nil {
 		eventType := s.Output.EventType
-		publisher := setupPublisher(eventType)
+		publisher, err := setupPublisher(s, s.Output)
+		if err != nil {
+			return err
+		}
 		s.Publisher = publisher
 		s.Publishers[eventType] = publisher
 	}
 	if s.Outputs != nil {
 		for _, o := range s.Outputs {
-			s.Publishers[o.EventType] = setupPublisher(o.EventType)
+			publisher, err := setupPublisher(s, o)
+			if err != nil {
+				return err
+			}
+			s.Publishers[o.EventType] = publisher
+		}
+	}
+
+	// Setup outbox relays
+	s.relays = make(map[string]*outbox.Relay)
+	for _, o := range serviceOutputs(s) {
+		if o.Outbox != nil {
+			relay := outbox.NewRelay(o.Outbox, s.Publishers[o.EventType].Transport)
+			relay.Logger = s.Logger.With(logging.Fields{"eventType": o.EventType})
+			s.relays[o.EventType] = relay
 		}
 	}
 
 	// Invoke main service func
 	fn()
 
-	// Signal handling so we can gracefully shutdown service
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	// Any service will eventually rest on a webserver. Any empty service,
+	// meaning no pubsub or handler have been set, will only serve the /health endpoint.
+	err = enableServer(s)
+	if err != nil {
+		return fmt.Errorf("failed to boot webserver (%v)", err)
+	}
 
-	// Enable Pubsub Listening
-	for _, sub := range pubsubSubscriptions(s) {
-		go func(s *Service, sub Subscription) {
-			err := sub.Listen(s)
-			if err != nil {
-				log.Fatal("Failed to listen on Pubsub: ", err)
-			}
-		}(s, sub)
+	return nil
+}
+
+// abort releases whatever a failed setup left behind.
+func abort(s *Service) {
+	s.cancel()
+
+	if s.Listener != nil {
+		s.Listener.Close()
 	}
 
-	// Any service will eventually rest on a webserver. Any empty service,
-	// meaning no pubsub or handler have been set, will only serve the /health endpoint.
+	if s.ownsTransport {
+		s.Transport.Close()
+	}
+}
+
+// fail reports a runtime error. Only the first one is kept.
+func (s *Service) fail(err error) {
+	select {
+	case s.errs <- err:
+	default:
+		s.logger().Error(err.Error(), logging.Fields{"error": err})
+	}
+}
+
+// logger returns the service's Logger, or logging.Default before the service started.
+func (s *Service) logger() *logging.Logger {
+	if s.Logger == nil {
+		return logging.Default
+	}
+
+	return s.Logger
+}
+
+// defaultLogger writes JSON to stdout, qualifying traces with the service's project.
+func defaultLogger(s *Service) *logging.Logger {
+	projectID := os.Getenv("PUBSUB_PROJECT_ID")
+	if s.Env != nil && s.Env.ProjectID != "" {
+		projectID = s.Env.ProjectID
+	}
+
+	return logging.New(&logging.JSONSink{W: os.Stdout, ProjectID: projectID}, logging.Info)
+}
+
+// signalContext returns a context which is cancelled on SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+
 	go func() {
-		err := enableServer(s)
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatal("Failed to boot webserver: ", err)
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
 		}
+		signal.Stop(done)
 	}()
 
-	<-done
-	log.Println("Initiating Teardown...")
+	return ctx, cancel
+}
 
-	shutdownServer(s)
-	s.Teardown()
+// joinErrors combines errs into a single error or returns nil if there are none.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
 
-	log.Println("Good bye.")
-}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
 
-// Teardown is called so the service can do cleanup work before finally going down.
-func (s *Service) Teardown() {
+	return errors.New(strings.Join(msgs, "; "))
+}
 
-	// Stop Publishers
-	if s.Publisher != nil {
-		s.Publisher.Stop()
+// receiveContext is used to receive messages. It is cancelled as soon as the shutdown starts.
+func (s *Service) receiveContext() context.Context {
+	if s.receiving == nil {
+		return s.baseContext()
 	}
-	for _, p := range s.Publishers {
-		p.Stop()
+
+	return s.receiving
+}
+
+// baseContext is the root of all contexts handed out by the service.
+// It is cancelled once the service shut down, after in-flight handlers had time to finish.
+func (s *Service) baseContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
 	}
 
-	// Cleanup Subscriptions
-	for _, sub := range pubsubSubscriptions(s) {
-		err := sub.Teardown(s)
-		if err != nil {
-			log.Println("Failed to teardown subscription:", err)
+	return s.ctx
+}
+
+// handlerContext derives a context from parent for a single EventHandler invocation.
+// It expires after timeout and is cancelled when the service shuts down.
+func (s *Service) handlerContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	done := s.baseContext().Done()
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-stop:
 		}
-	}
+	}()
 
+	return ctx, func() {
+		close(stop)
+		cancel()
+	}
 }
 
 func convertEventTypeToTopic(eventType string) string {
@@ -168,16 +645,72 @@ func pubsubSubscriptions(s *Service) []Subscription {
 	return s.Subscriptions
 }
 
-func setupPublisher(eventType string) *events.Publisher {
+func setupPublisher(s *Service, o *Output) (*events.Publisher, error) {
 	publisher := &events.Publisher{
-		ProjectID: Env("PUBSUB_PROJECT_ID"),
-		Topic:     eventType,
+		ProjectID:   s.Env.ProjectID,
+		Topic:       o.EventType,
+		Transport:   s.Transport,
+		SpecVersion: o.SpecVersion,
+		ContentMode: o.ContentMode,
+		Logger:      s.Logger,
 	}
 
+	if o.URL != "" {
+		publisher.Transport = &events.HTTPSender{
+			URL:        o.URL,
+			NewRequest: NewAuthenticateableRequest,
+		}
+	}
+
+	publisher.Transport = s.instruments.sender(publisher.Transport, o.EventType)
+
 	err := publisher.Setup()
 	if err != nil {
-		log.Fatal("Failed to setup Publisher: ", err)
+		return nil, fmt.Errorf("failed to setup Publisher for %s (%v)", o.EventType, err)
+	}
+
+	return publisher, nil
+}
+
+// setupTransport connects to Pubsub if the service needs a Transport but none has been set.
+func setupTransport(s *Service) error {
+	if s.Transport != nil || !usesTransport(s) {
+		return nil
+	}
+
+	t, err := transport.NewPubsub(context.Background(), s.Env.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	s.Transport = t
+	s.ownsTransport = true
+
+	return nil
+}
+
+// usesTransport reports whether the service has any Pubsub inputs or outputs.
+func usesTransport(s *Service) bool {
+	for _, sub := range pubsubSubscriptions(s) {
+		if _, ok := sub.(*HTTPEventSubscription); !ok {
+			return true
+		}
+	}
+
+	for _, o := range serviceOutputs(s) {
+		if o.URL == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceOutputs as configured via the Surfkit interface.
+func serviceOutputs(s *Service) []*Output {
+	if s.Output != nil {
+		return append([]*Output{s.Output}, s.Outputs...)
 	}
 
-	return publisher
+	return s.Outputs
 }
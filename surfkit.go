@@ -1,44 +1,171 @@
 package surfkit
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/outbox"
+	"github.com/helloink/surfkit/transport"
 )
 
 const version = "1.10.0"
 
 // Output defines the single channel on which the service produces output, given it is a Pubsub output.
-// Eventually this should also cover HTTP Endpoints.
+// If URL is set, the output delivers to that HTTP endpoint instead.
 type Output struct {
 	EventType string
+
+	// URL of a webhook the events are posted to using the CloudEvents HTTP binding,
+	// instead of being published to Pubsub. Requests to https URLs are authenticated,
+	// see NewAuthenticateableRequest.
+	URL string
+
+	// SpecVersion of the CloudEvents sent to this output. Defaults to events.SpecVersion10.
+	SpecVersion string
+
+	// ContentMode of the CloudEvents sent to this output. Defaults to events.StructuredMode.
+	ContentMode events.ContentMode
+
+	// Outbox enables publishing events of this output as part of a database transaction,
+	// see PublishEventTx. Surfkit relays the events from the outbox in the background.
+	Outbox outbox.Store
 }
 
 // PublishEvent sends the provided payload, wrapped in a CloudEvent, to all subscribers of the topic.
-// It uses the topic as defined by service.Output
+// It uses the topic as defined by service.Output and blocks until the event is published, but
+// no longer than the service's SrvTimeout. Use PublishEventContext to control the deadline.
 func PublishEvent(s *Service, payload interface{}) error {
-	return publish(s, s.Publisher, s.Output.EventType, payload)
+	ctx, cancel := context.WithTimeout(context.Background(), getTimeout(s))
+	defer cancel()
+
+	_, err := PublishEventContext(ctx, s, payload)
+	return err
 }
 
-// PublishEvent sends the provided payload, wrapped in a CloudEvent, to all subscribers of the given
+// PublishEventTo sends the provided payload, wrapped in a CloudEvent, to all subscribers of the given
 // topic. The topic must be either the topic defined by service.Output or one of the topics defined
-// by service.Outputs.
+// by service.Outputs. It blocks until the event is published, but no longer than the service's
+// SrvTimeout. Use PublishEventToContext to control the deadline.
 func PublishEventTo(s *Service, eventType string, payload interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), getTimeout(s))
+	defer cancel()
+
+	_, err := PublishEventToContext(ctx, s, eventType, payload)
+	return err
+}
+
+// PublishEventContext works like PublishEvent, but stops waiting once ctx is done.
+// It returns the message ID assigned by the server.
+func PublishEventContext(ctx context.Context, s *Service, payload interface{}) (string, error) {
+	if s.Output == nil {
+		return "", errors.New("no output defined")
+	}
+
+	return PublishEventToContext(ctx, s, s.Output.EventType, payload)
+}
+
+// PublishEventToContext works like PublishEventTo, but stops waiting once ctx is done.
+// It returns the message ID assigned by the server.
+func PublishEventToContext(ctx context.Context, s *Service, eventType string, payload interface{}) (string, error) {
 	publisher, ok := s.Publishers[eventType]
 	if !ok {
-		return fmt.Errorf("unknown publisher: %s", eventType)
+		return "", fmt.Errorf("unknown publisher: %s", eventType)
+	}
+
+	ctx, e, span := s.newTracedEvent(ctx, eventType, payload)
+	defer span.End()
+
+	id, err := publisher.Publish(ctx, e)
+	span.SetError(err)
+	if err != nil {
+		return "", fmt.Errorf("failed to send cloud event (%v)", err)
 	}
-	return publish(s, publisher, eventType, payload)
+
+	return id, nil
 }
 
-func publish(s *Service, p *events.Publisher, eventType string, payload interface{}) error {
-	eventSource := fmt.Sprintf("%s.%s", s.Name, s.Version)
-	ce := events.NewCloudEvent(eventSource, eventType, payload)
+// PublishEventAsync sends the provided payload, wrapped in a CloudEvent, to the output of the
+// given event type without waiting for it to be published. Use the returned result to retrieve
+// the outcome. Failures are reported on shutdown as well.
+func PublishEventAsync(ctx context.Context, s *Service, eventType string, payload interface{}) transport.PublishResult {
+	publisher, ok := s.Publishers[eventType]
+	if !ok {
+		r := transport.NewResult()
+		r.Set("", fmt.Errorf("unknown publisher: %s", eventType))
+		return r
+	}
+
+	ctx, e, span := s.newTracedEvent(ctx, eventType, payload)
+	r := publisher.PublishAsync(ctx, e)
+
+	// The span covers the publish until its outcome is known
+	go func() {
+		<-r.Ready()
+		_, err := r.Get(context.Background())
+		span.SetError(err)
+		span.End()
+	}()
 
-	err := p.Send(ce)
+	return r
+}
+
+// PublishEventTx adds the provided payload, wrapped in a CloudEvent, to the outbox of the output
+// of the given event type as part of the transaction tx. Once tx is committed, the event is
+// published in the background. If tx is rolled back, the event is never published.
+// Commit tx with CommitTx to publish the event right away instead of on the relay's next check.
+func PublishEventTx(ctx context.Context, s *Service, tx outbox.Execer, eventType string, payload interface{}) error {
+	publisher, ok := s.Publishers[eventType]
+	if !ok {
+		return fmt.Errorf("unknown publisher: %s", eventType)
+	}
+
+	relay, ok := s.relays[eventType]
+	if !ok {
+		return fmt.Errorf("output %s has no outbox", eventType)
+	}
+
+	ctx, e, span := s.newTracedEvent(ctx, eventType, payload)
+	defer span.End()
+
+	m, err := publisher.Encode(e)
 	if err != nil {
-		return fmt.Errorf("failed to send cloud event (%v)", err)
+		return fmt.Errorf("failed to encode cloud event (%v)", err)
+	}
+
+	err = relay.Store.Add(ctx, tx, &outbox.Record{
+		Topic:       publisher.Topic,
+		Data:        m.Data,
+		Attributes:  m.Attributes,
+		OrderingKey: m.OrderingKey,
+	})
+	span.SetError(err)
+
+	// Outside of a transaction, the record is committed already
+	if _, ok := tx.(*sql.Tx); !ok && err == nil {
+		relay.Notify()
+	}
+
+	return err
+}
+
+// CommitTx commits tx and makes the outboxes publish the events PublishEventTx added
+// to it right away.
+func CommitTx(s *Service, tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, relay := range s.relays {
+		relay.Notify()
 	}
 
 	return nil
 }
+
+func newCloudEvent(s *Service, eventType string, payload interface{}) events.CloudEvent {
+	eventSource := fmt.Sprintf("%s.%s", s.Name, s.Version)
+	return events.NewCloudEvent(eventSource, eventType, payload)
+}
@@ -0,0 +1,175 @@
+// Package surfkittest provides a harness to run surfkit services in-process, for testing.
+//
+// A service started by the harness listens on a random local port, uses an in-memory
+// broker instead of Pubsub and doesn't read anything from the environment:
+//
+//	h, err := surfkittest.Start(&s, func() {})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer h.Close()
+//
+//	res, err := http.Get(h.URL + "/")
+//	...
+//	acked, err := h.Inject("my-subscription", events.NewCloudEvent("test", "my.event", payload))
+//	...
+//	published, err := h.Published("my.output")
+package surfkittest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/transport"
+)
+
+// InjectTimeout limits how long Inject waits for a subscription to handle an event.
+var InjectTimeout = 30 * time.Second
+
+// A Harness runs a single surfkit Service.
+type Harness struct {
+
+	// URL is the base URL of the service's webserver, e.g. http://127.0.0.1:49152
+	URL string
+
+	// Service under test.
+	Service *surfkit.Service
+
+	// Broker all events are sent through.
+	Broker *transport.Memory
+
+	ownsBroker bool
+}
+
+// Start boots s just like surfkit.Run would, using fn as its runloop function,
+// and returns once the service is up.
+//
+// If s has a *transport.Memory Transport set, it is used as the broker. This allows to
+// wire several services together. Otherwise a fresh broker is created for s.
+func Start(s *surfkit.Service, fn func()) (*Harness, error) {
+	h := &Harness{Service: s}
+
+	switch t := s.Transport.(type) {
+	case nil:
+		h.Broker = transport.NewMemory()
+		h.ownsBroker = true
+		s.Transport = h.Broker
+	case *transport.Memory:
+		h.Broker = t
+	default:
+		return nil, fmt.Errorf("unsupported transport %T, surfkittest requires *transport.Memory", t)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen (%v)", err)
+	}
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	h.URL = fmt.Sprintf("http://%s", l.Addr())
+
+	s.Listener = l
+	s.Env = &surfkit.ServiceEnv{
+		Port: port,
+		Host: h.URL,
+	}
+
+	err = surfkit.Start(s, fn)
+	if err != nil {
+		if h.ownsBroker {
+			h.Broker.Close()
+		}
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Close shuts the service down.
+func (h *Harness) Close() error {
+	err := surfkit.Shutdown(h.Service)
+
+	if h.ownsBroker {
+		h.Broker.Close()
+	}
+
+	return err
+}
+
+// Inject delivers e in structured mode to the named subscription, bypassing its topic,
+// and reports whether the service acknowledged it.
+func (h *Harness) Inject(subscription string, e events.CloudEvent) (bool, error) {
+	return h.InjectMode(subscription, e, events.StructuredMode)
+}
+
+// InjectMode works like Inject, using the given content mode.
+//
+// Events for an HTTPEventSubscription are posted to its path instead.
+func (h *Harness) InjectMode(subscription string, e events.CloudEvent, mode events.ContentMode) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), InjectTimeout)
+	defer cancel()
+
+	if sub, ok := h.subscription(subscription).(*surfkit.HTTPEventSubscription); ok {
+		return h.post(ctx, sub.Path, e, mode)
+	}
+
+	m, err := events.EncodeMessage(e, mode)
+	if err != nil {
+		return false, err
+	}
+
+	return h.Broker.Deliver(ctx, subscription, m)
+}
+
+func (h *Harness) post(ctx context.Context, path string, e events.CloudEvent, mode events.ContentMode) (bool, error) {
+	req, err := events.NewHTTPRequest(h.URL+path, e, mode)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode <= 299, nil
+}
+
+// subscription returns the service's subscription with the given name or nil.
+func (h *Harness) subscription(name string) surfkit.Subscription {
+	subs := h.Service.Subscriptions
+	if h.Service.Subscription != nil {
+		subs = append([]surfkit.Subscription{h.Service.Subscription}, subs...)
+	}
+
+	for _, sub := range subs {
+		if sub.GetName() == name {
+			return sub
+		}
+	}
+
+	return nil
+}
+
+// Published returns all CloudEvents the service sent to the output of the given
+// event type, e.g. by using surfkit.PublishEvent or surfkit.PublishEventTo.
+// Outputs delivering to a webhook URL are not covered.
+func (h *Harness) Published(eventType string) ([]events.CloudEvent, error) {
+	var evs []events.CloudEvent
+
+	for _, m := range h.Broker.Published(eventType) {
+		e, err := events.DecodeMessage(m.Data, m.Attributes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message %s (%v)", m.ID, err)
+		}
+		evs = append(evs, *e)
+	}
+
+	return evs, nil
+}
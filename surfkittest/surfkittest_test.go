@@ -0,0 +1,274 @@
+package surfkittest_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/helloink/surfkit"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/surfkittest"
+	"github.com/helloink/surfkit/transport"
+)
+
+var quiet = logging.New(&logging.TextSink{W: ioutil.Discard}, logging.Critical)
+
+type order struct {
+	ID string `json:"id"`
+}
+
+// outcomeHandler acks, nacks or poisons events depending on their data.
+func outcomeHandler(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+	var o order
+	if err := e.DataTo(&o); err != nil {
+		return surfkit.Poison(err)
+	}
+
+	switch o.ID {
+	case "nack":
+		return surfkit.ErrNack
+	case "poison":
+		return surfkit.Poison(errors.New("unprocessable"))
+	default:
+		return nil
+	}
+}
+
+func newService(sub surfkit.Subscription) *surfkit.Service {
+	return &surfkit.Service{
+		Name:          "orders",
+		Version:       "1.0.0",
+		Logger:        quiet,
+		Subscriptions: []surfkit.Subscription{sub},
+	}
+}
+
+func TestInjectOutcomes(t *testing.T) {
+	subs := map[string]func() surfkit.Subscription{
+		"pull": func() surfkit.Subscription {
+			return &surfkit.PullSubscription{Name: "orders", Topic: "orders.placed", Handler: outcomeHandler}
+		},
+		"push": func() surfkit.Subscription {
+			return &surfkit.PushSubscription{Name: "orders", Topic: "orders.placed", Handler: outcomeHandler}
+		},
+		"http": func() surfkit.Subscription {
+			return &surfkit.HTTPEventSubscription{Name: "orders", Handler: outcomeHandler}
+		},
+	}
+
+	tests := []struct {
+		id    string
+		acked bool
+	}{
+		{"ack", true},
+		{"nack", false},
+		{"poison", true},
+	}
+
+	for mode, sub := range subs {
+		t.Run(mode, func(t *testing.T) {
+			h, err := surfkittest.Start(newService(sub()), func() {})
+			if err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+			defer h.Close()
+
+			for _, tt := range tests {
+				e := events.NewCloudEvent("test", "orders.placed", order{ID: tt.id})
+
+				acked, err := h.Inject("orders", e)
+				if err != nil {
+					t.Fatalf("Inject(%s) failed: %v", tt.id, err)
+				}
+				if acked != tt.acked {
+					t.Errorf("Inject(%s) acked = %v, want %v", tt.id, acked, tt.acked)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectBinaryMode(t *testing.T) {
+	var got order
+	s := newService(&surfkit.PullSubscription{
+		Name:  "orders",
+		Topic: "orders.placed",
+		Handler: func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			return e.DataTo(&got)
+		},
+	})
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	acked, err := h.InjectMode("orders", events.NewCloudEvent("test", "orders.placed", order{ID: "42"}), events.BinaryMode)
+	if err != nil || !acked {
+		t.Fatalf("InjectMode = %v, %v, want acked", acked, err)
+	}
+	if got.ID != "42" {
+		t.Errorf("handler got %+v, want ID 42", got)
+	}
+}
+
+func TestInjectUnknownSubscription(t *testing.T) {
+	h, err := surfkittest.Start(newService(&surfkit.PullSubscription{Name: "orders", Topic: "orders.placed", Handler: outcomeHandler}), func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	_, err = h.Inject("unknown", events.NewCloudEvent("test", "orders.placed", order{}))
+	if err == nil {
+		t.Error("Inject to an unknown subscription succeeded")
+	}
+}
+
+func TestPublished(t *testing.T) {
+	s := newService(&surfkit.PullSubscription{
+		Name:  "orders",
+		Topic: "orders.placed",
+		Handler: func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			var o order
+			if err := e.DataTo(&o); err != nil {
+				return err
+			}
+			_, err := surfkit.PublishEventToContext(ctx, s, "orders.confirmed", o)
+			return err
+		},
+	})
+	s.Outputs = []*surfkit.Output{{EventType: "orders.confirmed"}, {EventType: "orders.cancelled"}}
+
+	h, err := surfkittest.Start(s, func() {})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Close()
+
+	acked, err := h.Inject("orders", events.NewCloudEvent("test", "orders.placed", order{ID: "42"}))
+	if err != nil || !acked {
+		t.Fatalf("Inject = %v, %v, want acked", acked, err)
+	}
+
+	published, err := h.Published("orders.confirmed")
+	if err != nil {
+		t.Fatalf("Published failed: %v", err)
+	}
+	if len(published) != 1 {
+		t.Fatalf("got %d published events, want 1", len(published))
+	}
+
+	var o order
+	if err := published[0].DataTo(&o); err != nil || o.ID != "42" {
+		t.Errorf("published data = %+v, %v, want ID 42", o, err)
+	}
+	if published[0].Type != "orders.confirmed" {
+		t.Errorf("published type = %s, want orders.confirmed", published[0].Type)
+	}
+
+	published, err = h.Published("orders.cancelled")
+	if err != nil || len(published) != 0 {
+		t.Errorf("Published(orders.cancelled) = %v, %v, want none", published, err)
+	}
+}
+
+func TestStartAndClose(t *testing.T) {
+	s := newService(&surfkit.PullSubscription{Name: "orders", Topic: "orders.placed", Handler: outcomeHandler})
+
+	h, err := surfkittest.Start(s, func() {
+		s.Router.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	for path, want := range map[string]int{"/": http.StatusOK, "/ping": http.StatusNoContent} {
+		resp, err := http.Get(h.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	client := &http.Client{Timeout: time.Second}
+	if resp, err := client.Get(h.URL + "/"); err == nil {
+		resp.Body.Close()
+		t.Error("service still serving after Close")
+	}
+}
+
+func TestStartSharedBroker(t *testing.T) {
+	broker := transport.NewMemory()
+	defer broker.Close()
+
+	producer := &surfkit.Service{
+		Name:      "producer",
+		Logger:    quiet,
+		Transport: broker,
+		Outputs:   []*surfkit.Output{{EventType: "orders.placed"}},
+	}
+
+	received := make(chan string, 1)
+	consumer := newService(&surfkit.PullSubscription{
+		Name:  "orders",
+		Topic: "orders.placed",
+		Handler: func(ctx context.Context, s *surfkit.Service, e *events.CloudEvent) error {
+			var o order
+			e.DataTo(&o)
+			received <- o.ID
+			return nil
+		},
+	})
+	consumer.Transport = broker
+
+	for _, s := range []*surfkit.Service{consumer, producer} {
+		h, err := surfkittest.Start(s, func() {})
+		if err != nil {
+			t.Fatalf("Start(%s) failed: %v", s.Name, err)
+		}
+		defer h.Close()
+	}
+
+	err := surfkit.PublishEventTo(producer, "orders.placed", order{ID: "42"})
+	if err != nil {
+		t.Fatalf("PublishEventTo failed: %v", err)
+	}
+
+	select {
+	case id := <-received:
+		if id != "42" {
+			t.Errorf("consumer got %s, want 42", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer received nothing")
+	}
+}
+
+func TestStartUnsupportedTransport(t *testing.T) {
+	s := newService(&surfkit.PullSubscription{Name: "orders", Topic: "orders.placed", Handler: outcomeHandler})
+	s.Transport = unsupported{}
+
+	if _, err := surfkittest.Start(s, func() {}); err == nil {
+		t.Error("Start with a non-memory transport succeeded")
+	}
+}
+
+type unsupported struct {
+	transport.Transport
+}
@@ -0,0 +1,106 @@
+package surfkit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/tracing"
+)
+
+// traceHTTP continues the trace of incoming requests and wraps them in a server span.
+func traceHTTP(s *Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := tracing.Extract(r.Header); ok {
+			ctx = tracing.ContextWithSpanContext(ctx, sc)
+		}
+
+		if s.Tracer == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		route := routeTemplate(s, r)
+		ctx, span := s.Tracer.Start(ctx, fmt.Sprintf("%s %s", r.Method, route), tracing.Server)
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("responded with %d", rec.status))
+		}
+	})
+}
+
+// routeTemplate returns the template of the route of s.Router matching r.
+func routeTemplate(s *Service, r *http.Request) string {
+	var match mux.RouteMatch
+	if s.Router.Match(r, &match) && match.MatchErr == nil && match.Route != nil {
+		if tpl, err := match.Route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+
+	return unmatchedRoute
+}
+
+// startEventSpan continues the trace the event carries, if any, with a consumer span.
+func (s *Service) startEventSpan(ctx context.Context, e *events.CloudEvent, d *Delivery) (context.Context, *tracing.Span) {
+	if sc, ok := eventSpanContext(e); ok {
+		ctx = tracing.ContextWithSpanContext(ctx, sc)
+	}
+
+	ctx, span := s.Tracer.Start(ctx, fmt.Sprintf("%s process", d.Subscription), tracing.Consumer)
+	span.SetAttribute("subscription", d.Subscription)
+	span.SetAttribute("message.id", d.MessageID)
+	span.SetAttribute("event.id", e.ID)
+	span.SetAttribute("event.type", e.Type)
+
+	return ctx, span
+}
+
+// newTracedEvent wraps payload in a CloudEvent carrying the trace context of a producer
+// span started within ctx. The caller ends the span.
+func (s *Service) newTracedEvent(ctx context.Context, eventType string, payload interface{}) (context.Context, events.CloudEvent, *tracing.Span) {
+	ctx, span := s.Tracer.Start(ctx, fmt.Sprintf("%s publish", eventType), tracing.Producer)
+
+	e := newCloudEvent(s, eventType, payload)
+	span.SetAttribute("event.id", e.ID)
+	span.SetAttribute("event.type", e.Type)
+
+	sc := tracing.SpanContextFromContext(ctx)
+	if sc.IsValid() {
+		e.SetExtension(tracing.TraceparentHeader, sc.Traceparent())
+		if sc.TraceState != "" {
+			e.SetExtension(tracing.TracestateHeader, sc.TraceState)
+		}
+	}
+
+	return ctx, e, span
+}
+
+// eventSpanContext reads the trace context of the CloudEvents distributed tracing extension.
+// See https://github.com/cloudevents/spec/blob/v1.0/extensions/distributed-tracing.md
+func eventSpanContext(e *events.CloudEvent) (tracing.SpanContext, bool) {
+	traceparent, ok := e.ExtensionString(tracing.TraceparentHeader)
+	if !ok {
+		return tracing.SpanContext{}, false
+	}
+
+	sc, err := tracing.ParseTraceparent(traceparent)
+	if err != nil {
+		return tracing.SpanContext{}, false
+	}
+
+	sc.TraceState, _ = e.ExtensionString(tracing.TracestateHeader)
+	return sc, true
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes every span as a single line of JSON, e.g. to follow traces locally.
+type StdoutExporter struct {
+
+	// W the spans are written to. Defaults to os.Stdout.
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+// Export writes s as JSON.
+func (e *StdoutExporter) Export(s *Span) {
+	m := map[string]interface{}{
+		"name":      s.Name,
+		"kind":      s.Kind.String(),
+		"traceId":   s.Context.TraceID,
+		"spanId":    s.Context.SpanID,
+		"startTime": s.StartTime.Format(time.RFC3339Nano),
+		"endTime":   s.EndTime.Format(time.RFC3339Nano),
+		"duration":  s.EndTime.Sub(s.StartTime).String(),
+	}
+
+	if s.ParentSpanID != "" {
+		m["parentSpanId"] = s.ParentSpanID
+	}
+	if len(s.Attributes) > 0 {
+		m["attributes"] = s.Attributes
+	}
+	if s.Err != nil {
+		m["error"] = s.Err.Error()
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"name":%q,"error":"failed to marshal span"}`, s.Name))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.W
+	if w == nil {
+		w = os.Stdout
+	}
+	w.Write(append(b, '\n'))
+}
+
+// MemoryExporter keeps all spans in memory, for tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Export keeps s.
+func (e *MemoryExporter) Export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns all spans exported so far, in the order they ended.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset forgets all spans.
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = nil
+}
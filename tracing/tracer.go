@@ -0,0 +1,157 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind describes the relationship of a span to its parent and children.
+type SpanKind int
+
+const (
+	// Internal spans are operations within a process.
+	Internal SpanKind = iota
+
+	// Server spans handle incoming requests.
+	Server
+
+	// Client spans make outgoing requests.
+	Client
+
+	// Producer spans send events.
+	Producer
+
+	// Consumer spans handle received events.
+	Consumer
+)
+
+// String returns the name of k.
+func (k SpanKind) String() string {
+	switch k {
+	case Server:
+		return "server"
+	case Client:
+		return "client"
+	case Producer:
+		return "producer"
+	case Consumer:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
+
+// An Exporter receives every sampled span once it ended, e.g. to send it to a tracing backend.
+// Implementations must be safe for concurrent use.
+type Exporter interface {
+	Export(s *Span)
+}
+
+// A Tracer starts spans and hands them to its exporter once they end. A nil *Tracer records
+// nothing, but its spans can still be used.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a Tracer exporting spans to e.
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Start a span as child of the span or remote span context carried by ctx, or as root of a
+// new trace. The returned context carries the span. Make sure to End it.
+//
+// Without tracer, ctx is returned as is, together with a nil span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	p := SpanContextFromContext(ctx)
+
+	s := &Span{
+		Name:      name,
+		Kind:      kind,
+		StartTime: time.Now(),
+		tracer:    t,
+	}
+
+	if p.IsValid() {
+		s.Context = SpanContext{TraceID: p.TraceID, SpanID: newID(8), Sampled: p.Sampled, TraceState: p.TraceState}
+		s.ParentSpanID = p.SpanID
+	} else {
+		s.Context = SpanContext{TraceID: newID(16), SpanID: newID(8), Sampled: true}
+	}
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// A Span is a single operation within a trace. Its methods may be called on a nil *Span,
+// which does nothing.
+type Span struct {
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID string
+	StartTime    time.Time
+	EndTime      time.Time
+
+	// Attributes describing the operation, e.g. the subscription an event was received on.
+	Attributes map[string]interface{}
+
+	// Err the operation failed with, if any.
+	Err error
+
+	tracer *Tracer
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttribute records an attribute of the operation.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the operation as failed, unless err is nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Err = err
+}
+
+// End the span and export it, if it is sampled. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
@@ -0,0 +1,196 @@
+// Package tracing propagates W3C trace context across HTTP requests and events and records
+// spans around handlers. See https://www.w3.org/TR/trace-context/
+//
+// Trace context travels in the traceparent and tracestate headers of HTTP requests and,
+// following the CloudEvents distributed tracing extension, in the attributes of the same
+// name of events. Surfkit reads and writes both on its own. Spans are only recorded if the
+// service has a Tracer:
+//
+//	s := surfkit.Service{
+//		Name:   "my-service",
+//		Tracer: tracing.NewTracer(&tracing.StdoutExporter{}),
+//	}
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Names of the headers and CloudEvent attributes trace context travels in.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// cloudTraceHeader is set by Google's load balancers, which predate W3C trace context.
+const cloudTraceHeader = "X-Cloud-Trace-Context"
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+
+	// TraceID as 32 lowercase hex characters.
+	TraceID string
+
+	// SpanID as 16 lowercase hex characters.
+	SpanID string
+
+	// Sampled reports whether the trace is recorded.
+	Sampled bool
+
+	// TraceState carries vendor specific data, passed on as is.
+	TraceState string
+}
+
+// IsValid reports whether sc has a trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+// Traceparent formats sc as value of a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads the value of a traceparent header.
+func ParseTraceparent(h string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", h)
+	}
+
+	// Future versions may append fields, version 00 must not.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", h)
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", h)
+	}
+	if traceID == strings.Repeat("0", 32) || spanID == strings.Repeat("0", 16) {
+		return SpanContext{}, errors.New("traceparent with zero trace or span ID")
+	}
+
+	f, _ := strconv.ParseUint(flags, 16, 8)
+
+	return SpanContext{TraceID: traceID, SpanID: spanID, Sampled: f&1 == 1}, nil
+}
+
+// isHex reports whether s consists of n lowercase hex characters.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Extract reads the trace context from the headers of an incoming request. Without a
+// traceparent header, it falls back to the X-Cloud-Trace-Context header of Google's
+// load balancers.
+func Extract(h http.Header) (SpanContext, bool) {
+	if v := h.Get(TraceparentHeader); v != "" {
+		sc, err := ParseTraceparent(v)
+		if err != nil {
+			return SpanContext{}, false
+		}
+
+		sc.TraceState = h.Get(TracestateHeader)
+		return sc, true
+	}
+
+	// TRACE_ID/SPAN_ID;o=TRACE_TRUE, with a decimal span ID
+	if v := h.Get(cloudTraceHeader); v != "" {
+		parts := strings.SplitN(v, ";", 2)
+		ids := strings.SplitN(parts[0], "/", 2)
+		if len(ids) != 2 || !isHex(strings.ToLower(ids[0]), 32) {
+			return SpanContext{}, false
+		}
+
+		spanID, err := strconv.ParseUint(ids[1], 10, 64)
+		if err != nil || spanID == 0 {
+			return SpanContext{}, false
+		}
+
+		return SpanContext{
+			TraceID: strings.ToLower(ids[0]),
+			SpanID:  fmt.Sprintf("%016x", spanID),
+			Sampled: len(parts) == 2 && parts[1] == "o=1",
+		}, true
+	}
+
+	return SpanContext{}, false
+}
+
+// Inject writes the trace context of ctx into the headers of an outgoing request.
+func Inject(ctx context.Context, h http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+
+	h.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// parent is what a context carries: either a span of this process or a remote span context.
+type parent struct {
+	span   *Span
+	remote SpanContext
+}
+
+type parentKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx whose spans continue the remote trace of sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, parentKey{}, parent{remote: sc})
+}
+
+// ContextWithSpan returns a copy of ctx that carries s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, parentKey{}, parent{span: s})
+}
+
+// SpanFromContext returns the span stored in ctx or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	p, _ := ctx.Value(parentKey{}).(parent)
+	return p.span
+}
+
+// SpanContextFromContext returns the context of the span stored in ctx or, if there is
+// none, the remote span context. It is invalid if ctx carries neither.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	p, _ := ctx.Value(parentKey{}).(parent)
+	if p.span != nil {
+		return p.span.Context
+	}
+
+	return p.remote
+}
+
+// newID returns n random bytes as hex.
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  SpanContext
+		fails bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true}, false},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", SpanContext{TraceID: traceID, SpanID: spanID}, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-03", SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true}, false},
+		{"surrounding space", " 00-" + traceID + "-" + spanID + "-01 ", SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true}, false},
+		{"future version with extra fields", "01-" + traceID + "-" + spanID + "-01-what-ever", SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true}, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", SpanContext{}, true},
+		{"extra fields on version 00", "00-" + traceID + "-" + spanID + "-01-extra", SpanContext{}, true},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", SpanContext{}, true},
+		{"zero span ID", "00-" + traceID + "-0000000000000000-01", SpanContext{}, true},
+		{"uppercase trace ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", SpanContext{}, true},
+		{"uppercase span ID", "00-" + traceID + "-00F067AA0BA902B7-01", SpanContext{}, true},
+		{"short trace ID", "00-4bf92f3577b34da6-" + spanID + "-01", SpanContext{}, true},
+		{"short span ID", "00-" + traceID + "-00f067aa-01", SpanContext{}, true},
+		{"bad flags", "00-" + traceID + "-" + spanID + "-1", SpanContext{}, true},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", SpanContext{}, true},
+		{"missing fields", "00-" + traceID + "-" + spanID, SpanContext{}, true},
+		{"empty", "", SpanContext{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.fails {
+				t.Fatalf("ParseTraceparent(%q) = %v, want failure %v", tt.value, err, tt.fails)
+			}
+			if sc != tt.want {
+				t.Errorf("ParseTraceparent(%q) = %+v, want %+v", tt.value, sc, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		sc := SpanContext{TraceID: traceID, SpanID: spanID, Sampled: sampled}
+
+		parsed, err := ParseTraceparent(sc.Traceparent())
+		if err != nil || parsed != sc {
+			t.Errorf("ParseTraceparent(%s) = %+v, %v, want %+v", sc.Traceparent(), parsed, err, sc)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    SpanContext
+		ok      bool
+	}{
+		{
+			"traceparent",
+			map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-01", "tracestate": "congo=t61rcWkgMzE"},
+			SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true, TraceState: "congo=t61rcWkgMzE"},
+			true,
+		},
+		{
+			"traceparent wins",
+			map[string]string{"traceparent": "00-" + traceID + "-" + spanID + "-00", "X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			SpanContext{TraceID: traceID, SpanID: spanID},
+			true,
+		},
+		{
+			"invalid traceparent",
+			map[string]string{"traceparent": "ff-" + traceID + "-" + spanID + "-01", "X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			SpanContext{},
+			false,
+		},
+		{
+			"cloud trace sampled",
+			map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			SpanContext{TraceID: "105445aa7843bc8bf206b12000100000", SpanID: "0000000000000001", Sampled: true},
+			true,
+		},
+		{
+			"cloud trace decimal span ID",
+			map[string]string{"X-Cloud-Trace-Context": "105445AA7843BC8BF206B12000100000/18446744073709551615;o=0"},
+			SpanContext{TraceID: "105445aa7843bc8bf206b12000100000", SpanID: "ffffffffffffffff"},
+			true,
+		},
+		{
+			"cloud trace without options",
+			map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/255"},
+			SpanContext{TraceID: "105445aa7843bc8bf206b12000100000", SpanID: "00000000000000ff"},
+			true,
+		},
+		{"cloud trace hex span ID", map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/ff;o=1"}, SpanContext{}, false},
+		{"cloud trace zero span ID", map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/0;o=1"}, SpanContext{}, false},
+		{"cloud trace without span ID", map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000;o=1"}, SpanContext{}, false},
+		{"cloud trace short trace ID", map[string]string{"X-Cloud-Trace-Context": "105445aa/1;o=1"}, SpanContext{}, false},
+		{"none", map[string]string{}, SpanContext{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+
+			sc, ok := Extract(h)
+			if ok != tt.ok || sc != tt.want {
+				t.Errorf("Extract = %+v, %v, want %+v, %v", sc, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	h := http.Header{}
+	Inject(context.Background(), h)
+	if len(h) != 0 {
+		t.Errorf("Inject without trace context set %v", h)
+	}
+
+	remote := SpanContext{TraceID: traceID, SpanID: spanID, Sampled: true, TraceState: "congo=t61rcWkgMzE"}
+	exporter := &MemoryExporter{}
+
+	ctx, span := NewTracer(exporter).Start(ContextWithSpanContext(context.Background(), remote), "handle", Server)
+	Inject(ctx, h)
+
+	sc, ok := Extract(h)
+	if !ok || sc != span.Context {
+		t.Errorf("Extract of injected headers = %+v, %v, want %+v", sc, ok, span.Context)
+	}
+	if span.Context.TraceID != traceID || span.ParentSpanID != spanID || span.Context.SpanID == spanID || span.Context.TraceState != remote.TraceState {
+		t.Errorf("span %+v doesn't continue %+v", span.Context, remote)
+	}
+
+	span.End()
+	span.End()
+	if spans := exporter.Spans(); len(spans) != 1 || spans[0] != span {
+		t.Errorf("exported %v, want the span once", spans)
+	}
+}
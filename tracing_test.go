@@ -0,0 +1,75 @@
+package surfkit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/helloink/surfkit/events"
+	"github.com/helloink/surfkit/tracing"
+)
+
+func TestEventTraceContextRoundTrip(t *testing.T) {
+	s := &Service{Name: "orders", Version: "1.0.0", Tracer: tracing.NewTracer(&tracing.MemoryExporter{})}
+
+	remote := tracing.SpanContext{
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Sampled:    true,
+		TraceState: "congo=t61rcWkgMzE",
+	}
+	ctx := tracing.ContextWithSpanContext(context.Background(), remote)
+
+	_, e, span := s.newTracedEvent(ctx, "order.placed", map[string]int{"id": 1})
+	span.End()
+
+	if span.Context.TraceID != remote.TraceID || span.ParentSpanID != remote.SpanID {
+		t.Fatalf("producer span %+v doesn't continue %+v", span.Context, remote)
+	}
+
+	for _, mode := range []events.ContentMode{events.StructuredMode, events.BinaryMode} {
+		m, err := events.EncodeMessage(e, mode)
+		if err != nil {
+			t.Fatalf("EncodeMessage failed: %v", err)
+		}
+
+		decoded, err := events.DecodeMessage(m.Data, m.Attributes)
+		if err != nil {
+			t.Fatalf("DecodeMessage failed: %v", err)
+		}
+
+		sc, ok := eventSpanContext(decoded)
+		if !ok || sc != span.Context {
+			t.Errorf("trace context of event in mode %v = %+v, %v, want %+v", mode, sc, ok, span.Context)
+		}
+	}
+}
+
+func TestEventSpanContextInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing":          "",
+		"version ff":       "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"zero trace ID":    "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"uppercase hex":    "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"version 00 extra": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+
+	for name, traceparent := range tests {
+		e := events.NewCloudEvent("test", "order.placed", nil)
+		if traceparent != "" {
+			e.SetExtension(tracing.TraceparentHeader, traceparent)
+		}
+
+		if sc, ok := eventSpanContext(&e); ok {
+			t.Errorf("%s: eventSpanContext = %+v, want none", name, sc)
+		}
+	}
+
+	// Without tracer, events carry no trace context
+	_, e, span := (&Service{Name: "orders"}).newTracedEvent(context.Background(), "order.placed", nil)
+	if span != nil {
+		t.Error("span started without tracer")
+	}
+	if _, ok := e.ExtensionString(tracing.TraceparentHeader); ok {
+		t.Error("event carries trace context without tracer")
+	}
+}
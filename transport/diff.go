@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fields of a SubscriptionConfig as reported by Diff.
+const (
+	FieldTopic            = "topic"
+	FieldAckDeadline      = "ackDeadline"
+	FieldPushConfig       = "pushConfig"
+	FieldExpirationPolicy = "expirationPolicy"
+	FieldDeadLetterPolicy = "deadLetterPolicy"
+	FieldRetryPolicy      = "retryPolicy"
+	FieldLabels           = "labels"
+	FieldFilter           = "filter"
+)
+
+// Pubsub defaults for settings left empty.
+const (
+	defaultAckDeadline      = 10 * time.Second
+	defaultExpirationPolicy = 31 * 24 * time.Hour
+	defaultMinimumBackoff   = 10 * time.Second
+	defaultMaximumBackoff   = 600 * time.Second
+)
+
+// A Change is a difference between an existing subscription and its declaration.
+type Change struct {
+
+	// Field that differs.
+	Field string
+
+	// From is the existing, To the declared value.
+	From, To string
+
+	// Fixed reports whether UpdateSubscription is able to apply the change.
+	// The topic and the filter of a subscription can't be changed.
+	Fixed bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s from %s to %s", c.Field, c.From, c.To)
+}
+
+// Diff lists where the existing subscription differs from the declared one.
+// Settings left empty in the declaration fall back to Pubsub's defaults.
+func Diff(existing, declared SubscriptionConfig) []Change {
+	var changes []Change
+
+	add := func(field, from, to string, fixed bool) {
+		if from != to {
+			changes = append(changes, Change{Field: field, From: from, To: to, Fixed: fixed})
+		}
+	}
+
+	add(FieldTopic, existing.Topic, declared.Topic, false)
+	add(FieldAckDeadline, formatAckDeadline(existing.AckDeadline), formatAckDeadline(declared.AckDeadline), true)
+	add(FieldPushConfig, formatPushConfig(existing), formatPushConfig(declared), true)
+	add(FieldExpirationPolicy, formatExpirationPolicy(existing.ExpirationPolicy), formatExpirationPolicy(declared.ExpirationPolicy), true)
+	add(FieldDeadLetterPolicy, formatDeadLetterPolicy(existing.DeadLetterPolicy), formatDeadLetterPolicy(declared.DeadLetterPolicy), true)
+	add(FieldRetryPolicy, formatRetryPolicy(existing.RetryPolicy), formatRetryPolicy(declared.RetryPolicy), true)
+
+	if !(len(existing.Labels) == 0 && len(declared.Labels) == 0) && !reflect.DeepEqual(existing.Labels, declared.Labels) {
+		add(FieldLabels, formatLabels(existing.Labels), formatLabels(declared.Labels), true)
+	}
+
+	add(FieldFilter, quote(existing.Filter), quote(declared.Filter), false)
+
+	return changes
+}
+
+func formatAckDeadline(d time.Duration) string {
+	if d == 0 {
+		d = defaultAckDeadline
+	}
+
+	return d.String()
+}
+
+func formatPushConfig(cfg SubscriptionConfig) string {
+	if cfg.PushEndpoint == "" {
+		return "pull"
+	}
+
+	if cfg.PushServiceAccount == "" {
+		return fmt.Sprintf("push to %s", cfg.PushEndpoint)
+	}
+
+	audience := cfg.PushAudience
+	if audience == "" {
+		audience = cfg.PushEndpoint
+	}
+
+	return fmt.Sprintf("push to %s as %s for %s", cfg.PushEndpoint, cfg.PushServiceAccount, audience)
+}
+
+func formatExpirationPolicy(d time.Duration) string {
+	if d < 0 {
+		return "never"
+	}
+	if d == 0 {
+		d = defaultExpirationPolicy
+	}
+
+	return d.String()
+}
+
+func formatDeadLetterPolicy(p *DeadLetterPolicy) string {
+	if p == nil {
+		return "none"
+	}
+
+	return fmt.Sprintf("%s after %d attempts", p.Topic, maxDeliveryAttempts(p))
+}
+
+func formatRetryPolicy(p *RetryPolicy) string {
+	if p == nil {
+		return "none"
+	}
+
+	min, max := retryBackoffs(p)
+	return fmt.Sprintf("%s to %s", min, max)
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
+
+// retryBackoffs returns the minimum and maximum backoff of p, falling back to Pubsub's defaults.
+func retryBackoffs(p *RetryPolicy) (time.Duration, time.Duration) {
+	min, max := p.MinimumBackoff, p.MaximumBackoff
+	if min == 0 {
+		min = defaultMinimumBackoff
+	}
+	if max == 0 {
+		max = defaultMaximumBackoff
+	}
+
+	return min, max
+}
+
+// expirationPolicy returns the TTL to send for d, falling back to Pubsub's default.
+// An empty TTL never expires.
+func expirationPolicy(d time.Duration) string {
+	if d < 0 {
+		return ""
+	}
+	if d == 0 {
+		d = defaultExpirationPolicy
+	}
+
+	return formatDuration(d)
+}
+
+func maxDeliveryAttempts(p *DeadLetterPolicy) int {
+	if p.MaxDeliveryAttempts == 0 {
+		return DefaultMaxDeliveryAttempts
+	}
+
+	return p.MaxDeliveryAttempts
+}
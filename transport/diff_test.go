@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	base := SubscriptionConfig{Topic: "orders"}
+
+	tests := []struct {
+		name     string
+		existing SubscriptionConfig
+		declared SubscriptionConfig
+		want     []Change
+	}{
+		{"equal", base, base, nil},
+		{
+			"defaults",
+			SubscriptionConfig{
+				Topic:            "orders",
+				AckDeadline:      10 * time.Second,
+				ExpirationPolicy: 31 * 24 * time.Hour,
+				RetryPolicy:      &RetryPolicy{MinimumBackoff: 10 * time.Second, MaximumBackoff: 600 * time.Second},
+				DeadLetterPolicy: &DeadLetterPolicy{Topic: "dead-letters", MaxDeliveryAttempts: 5},
+				Labels:           map[string]string{},
+			},
+			SubscriptionConfig{
+				Topic:            "orders",
+				RetryPolicy:      &RetryPolicy{},
+				DeadLetterPolicy: &DeadLetterPolicy{Topic: "dead-letters"},
+			},
+			nil,
+		},
+		{
+			"ack deadline",
+			base,
+			SubscriptionConfig{Topic: "orders", AckDeadline: time.Minute},
+			[]Change{{FieldAckDeadline, "10s", "1m0s", true}},
+		},
+		{
+			"push config",
+			base,
+			SubscriptionConfig{Topic: "orders", PushEndpoint: "https://example.com/push", PushServiceAccount: "pusher@example.com"},
+			[]Change{{FieldPushConfig, "pull", "push to https://example.com/push as pusher@example.com for https://example.com/push", true}},
+		},
+		{
+			"expiration policy",
+			SubscriptionConfig{Topic: "orders", ExpirationPolicy: NeverExpire},
+			base,
+			[]Change{{FieldExpirationPolicy, "never", "744h0m0s", true}},
+		},
+		{
+			"dead letter and retry policy",
+			base,
+			SubscriptionConfig{
+				Topic:            "orders",
+				DeadLetterPolicy: &DeadLetterPolicy{Topic: "dead-letters", MaxDeliveryAttempts: 10},
+				RetryPolicy:      &RetryPolicy{MinimumBackoff: time.Second},
+			},
+			[]Change{
+				{FieldDeadLetterPolicy, "none", "dead-letters after 10 attempts", true},
+				{FieldRetryPolicy, "none", "1s to 10m0s", true},
+			},
+		},
+		{
+			"labels",
+			SubscriptionConfig{Topic: "orders", Labels: map[string]string{"team": "shop"}},
+			SubscriptionConfig{Topic: "orders", Labels: map[string]string{"team": "shop", "env": "prod"}},
+			[]Change{{FieldLabels, "{team=shop}", "{env=prod,team=shop}", true}},
+		},
+		{
+			"topic and filter",
+			SubscriptionConfig{Topic: "orders"},
+			SubscriptionConfig{Topic: "invoices", Filter: `attributes:region`},
+			[]Change{
+				{FieldTopic, "orders", "invoices", false},
+				{FieldFilter, `""`, `"attributes:region"`, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff(tt.existing, tt.declared); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestSubscription(t *testing.T) {
+	a := &pubsubAdmin{projectID: "my-project"}
+
+	tests := []struct {
+		name string
+		cfg  SubscriptionConfig
+		want string
+	}{
+		{
+			"defaults",
+			SubscriptionConfig{Topic: "orders"},
+			`{"topic":"projects/my-project/topics/orders","expirationPolicy":{"ttl":"2678400s"}}`,
+		},
+		{
+			"never expire",
+			SubscriptionConfig{Topic: "orders", ExpirationPolicy: NeverExpire},
+			`{"topic":"projects/my-project/topics/orders","expirationPolicy":{}}`,
+		},
+		{
+			"all settings",
+			SubscriptionConfig{
+				Topic:              "orders",
+				AckDeadline:        time.Minute,
+				PushEndpoint:       "https://example.com/push",
+				PushServiceAccount: "pusher@example.com",
+				ExpirationPolicy:   24 * time.Hour,
+				DeadLetterPolicy:   &DeadLetterPolicy{Topic: "dead-letters", MaxDeliveryAttempts: 5},
+				RetryPolicy:        &RetryPolicy{MinimumBackoff: 500 * time.Millisecond, MaximumBackoff: time.Minute},
+				Labels:             map[string]string{"team": "shop"},
+				Filter:             `attributes:region`,
+			},
+			`{"topic":"projects/my-project/topics/orders",` +
+				`"pushConfig":{"pushEndpoint":"https://example.com/push","oidcToken":{"serviceAccountEmail":"pusher@example.com"}},` +
+				`"ackDeadlineSeconds":60,"expirationPolicy":{"ttl":"86400s"},` +
+				`"deadLetterPolicy":{"deadLetterTopic":"projects/my-project/topics/dead-letters","maxDeliveryAttempts":5},` +
+				`"retryPolicy":{"minimumBackoff":"0.5s","maximumBackoff":"60s"},` +
+				`"labels":{"team":"shop"},"filter":"attributes:region"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := a.restSubscription(tt.cfg)
+
+			body, err := json.Marshal(sub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("restSubscription = %s, want %s", body, tt.want)
+			}
+
+			// Reading it back doesn't show any difference
+			if changes := Diff(*a.subscriptionConfig(sub), tt.cfg); len(changes) > 0 {
+				t.Errorf("subscription read back differs in %v", changes)
+			}
+		})
+	}
+
+	// Subscriptions without expiration policy never expire
+	cfg := a.subscriptionConfig(&restSubscription{Topic: "projects/my-project/topics/orders"})
+	if cfg.ExpirationPolicy != NeverExpire || cfg.Topic != "orders" {
+		t.Errorf("subscriptionConfig = %+v, want a subscription of orders which never expires", cfg)
+	}
+}
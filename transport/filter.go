@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A filter decides whether a message with the given attributes is delivered to a subscription.
+type filter func(attributes map[string]string) bool
+
+// parseFilter compiles a Pubsub subscription filter. The supported syntax covers
+// `attributes.key = "value"`, `attributes.key != "value"`, `attributes:key`,
+// `hasPrefix(attributes.key, "prefix")`, NOT, AND, OR and parentheses.
+// See https://cloud.google.com/pubsub/docs/filtering
+func parseFilter(s string) (filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return func(map[string]string) bool { return true }, nil
+	}
+
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	f, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid filter, unexpected %q", p.tokens[p.pos])
+	}
+
+	return f, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *filterParser) expect(t string) error {
+	if got := p.next(); got != t {
+		return fmt.Errorf("invalid filter, expected %q but got %q", t, got)
+	}
+	return nil
+}
+
+// expr parses terms joined by either AND or OR. Mixing both requires parentheses.
+func (p *filterParser) expr() (filter, error) {
+	f, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	op := ""
+	for p.peek() == "AND" || p.peek() == "OR" {
+		if op != "" && p.peek() != op {
+			return nil, fmt.Errorf("invalid filter, AND and OR must be grouped with parentheses")
+		}
+		op = p.next()
+
+		left := f
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "AND" {
+			f = func(a map[string]string) bool { return left(a) && right(a) }
+		} else {
+			f = func(a map[string]string) bool { return left(a) || right(a) }
+		}
+	}
+
+	return f, nil
+}
+
+func (p *filterParser) term() (filter, error) {
+	switch p.peek() {
+	case "NOT", "-":
+		p.next()
+		f, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		return func(a map[string]string) bool { return !f(a) }, nil
+
+	case "(":
+		p.next()
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+
+	case "hasPrefix":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		key, err := p.attribute(".")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		prefix, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return func(a map[string]string) bool {
+			v, ok := a[key]
+			return ok && strings.HasPrefix(v, prefix)
+		}, p.expect(")")
+	}
+
+	if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] == ":" {
+		key, err := p.attribute(":")
+		if err != nil {
+			return nil, err
+		}
+		return func(a map[string]string) bool {
+			_, ok := a[key]
+			return ok
+		}, nil
+	}
+
+	key, err := p.attribute(".")
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	if op != "=" && op != "!=" {
+		return nil, fmt.Errorf("invalid filter, expected = or != but got %q", op)
+	}
+
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if op == "=" {
+		return func(a map[string]string) bool {
+			v, ok := a[key]
+			return ok && v == value
+		}, nil
+	}
+
+	return func(a map[string]string) bool {
+		v, ok := a[key]
+		return !ok || v != value
+	}, nil
+}
+
+// attribute parses `attributes` followed by sep and the attribute's key.
+func (p *filterParser) attribute(sep string) (string, error) {
+	if err := p.expect("attributes"); err != nil {
+		return "", err
+	}
+	if err := p.expect(sep); err != nil {
+		return "", err
+	}
+
+	key := p.next()
+	if strings.HasPrefix(key, `"`) {
+		return strconv.Unquote(key)
+	}
+	if key == "" || !isFilterIdent(key) {
+		return "", fmt.Errorf("invalid filter, bad attribute key %q", key)
+	}
+
+	return key, nil
+}
+
+func (p *filterParser) value() (string, error) {
+	t := p.next()
+	if !strings.HasPrefix(t, `"`) {
+		return "", fmt.Errorf("invalid filter, expected a string but got %q", t)
+	}
+
+	return strconv.Unquote(t)
+}
+
+func tokenizeFilter(s string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '!':
+			if i+1 >= len(s) || s[i+1] != '=' {
+				return nil, fmt.Errorf("invalid filter, unexpected ! at %d", i)
+			}
+			tokens = append(tokens, "!=")
+			i += 2
+
+		case strings.IndexByte("()=:.,-", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("invalid filter, unterminated string at %d", i)
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+
+		default:
+			j := i
+			for j < len(s) && isFilterIdentChar(rune(s[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid filter, unexpected %q at %d", c, i)
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+func isFilterIdent(s string) bool {
+	for _, r := range s {
+		if !isFilterIdentChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isFilterIdentChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
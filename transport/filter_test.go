@@ -0,0 +1,82 @@
+package transport
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	attributes := map[string]string{
+		"region": "eu",
+		"kind":   "order.created",
+		"a key":  "spaced",
+		"empty":  "",
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{``, true},
+		{`  `, true},
+		{`attributes.region = "eu"`, true},
+		{`attributes.region = "us"`, false},
+		{`attributes.missing = ""`, false},
+		{`attributes.empty = ""`, true},
+		{`attributes.region != "us"`, true},
+		{`attributes.region != "eu"`, false},
+		{`attributes.missing != "eu"`, true},
+		{`attributes:region`, true},
+		{`attributes:missing`, false},
+		{`attributes."a key" = "spaced"`, true},
+		{`attributes:"a key"`, true},
+		{`attributes.kind = "order.\"created\""`, false},
+		{`hasPrefix(attributes.kind, "order.")`, true},
+		{`hasPrefix(attributes.kind, "user.")`, false},
+		{`hasPrefix(attributes.missing, "")`, false},
+		{`NOT attributes:missing`, true},
+		{`-attributes:region`, false},
+		{`NOT NOT attributes:region`, true},
+		{`attributes:region AND attributes.kind = "order.created"`, true},
+		{`attributes:region AND attributes:missing`, false},
+		{`attributes:missing OR attributes:region`, true},
+		{`attributes:missing OR attributes:other OR attributes:none`, false},
+		{`attributes:region AND (attributes:missing OR hasPrefix(attributes.kind, "order"))`, true},
+		{`(attributes:missing OR attributes:other) AND attributes:region`, false},
+		{`NOT (attributes:region AND attributes:missing)`, true},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("parseFilter(%s) failed: %v", tt.filter, err)
+			continue
+		}
+		if got := f(attributes); got != tt.want {
+			t.Errorf("filter %s = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	tests := []string{
+		`attributes.region`,
+		`attributes.region = eu`,
+		`attributes.region == "eu"`,
+		`attributes.region ! "eu"`,
+		`attributes.region = "eu`,
+		`region = "eu"`,
+		`attributes. = "eu"`,
+		`attributes:region AND attributes:kind OR attributes:empty`,
+		`(attributes:region`,
+		`attributes:region)`,
+		`hasPrefix(attributes.kind "order")`,
+		`hasPrefix(attributes:kind, "order")`,
+		`attributes:region attributes:kind`,
+		`attributes.region = "eu" AND`,
+		`attributes.region ~ "eu"`,
+	}
+
+	for _, filter := range tests {
+		if _, err := parseFilter(filter); err == nil {
+			t.Errorf("parseFilter(%s) succeeded, want an error", filter)
+		}
+	}
+}
@@ -0,0 +1,653 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemoryAckDeadline = 10 * time.Second
+
+	// defaultMaxOutstandingMessages matches the default of the Pubsub client.
+	defaultMaxOutstandingMessages = 1000
+
+	defaultPublishedLimit = 1000
+
+	// Pubsub backs off from push endpoints failing to take messages.
+	minPushBackoff = 100 * time.Millisecond
+	maxPushBackoff = 60 * time.Second
+)
+
+// Memory is an in-process Transport. It keeps topics and subscriptions in memory and
+// mimics Pubsub's delivery semantics: messages are delivered at least once, nacked messages
+// and messages not acked within the ack deadline are redelivered, honouring retry and
+// dead letter policies.
+//
+// Push subscriptions are served by posting Pubsub push envelopes to their endpoint until
+// the subscription is deleted or the Memory closed. Like Pubsub, it backs off from endpoints
+// failing to take messages. A single Memory can be shared by several services to wire them
+// together in one process.
+type Memory struct {
+
+	// HTTPClient is used to deliver messages to push endpoints. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// PushToken creates the OIDC token push requests of subscriptions with a push service
+	// account are authenticated with, e.g. by using oidc.Sign. The audience defaults to the
+	// push endpoint. Requests are sent without a token if PushToken is not set.
+	PushToken func(serviceAccount, audience string) (string, error)
+
+	// MaxOutstandingMessages limits how many messages of a subscription are handled at once,
+	// by each Receive call or by push deliveries. Defaults to 1000.
+	MaxOutstandingMessages int
+
+	// PublishedLimit is how many of the most recently published messages of a topic are kept
+	// for Published. Defaults to 1000.
+	PublishedLimit int
+
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	subs   map[string]*memorySubscription
+	nextID int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+type memoryTopic struct {
+	subs      map[string]*memorySubscription
+	published []Message
+}
+
+type memorySubscription struct {
+	name    string
+	cfg     SubscriptionConfig
+	filter  filter
+	pending []*memoryMessage
+	notify  chan struct{}
+	deleted chan struct{}
+}
+
+type memoryMessage struct {
+	msg      Message
+	attempts int
+	lease    int
+	leased   bool
+	timer    *time.Timer
+
+	// settled receives the outcome of a message handed in via Deliver.
+	settled chan bool
+}
+
+// NewMemory returns an empty in-memory broker.
+func NewMemory() *Memory {
+	return &Memory{
+		topics: make(map[string]*memoryTopic),
+		subs:   make(map[string]*memorySubscription),
+		closed: make(chan struct{}),
+	}
+}
+
+// EnsureTopic creates the topic unless it exists already.
+func (b *Memory) EnsureTopic(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ensureTopic(topic)
+	return nil
+}
+
+// TopicExists reports whether topic has been created.
+func (b *Memory) TopicExists(ctx context.Context, topic string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.topics[topic]
+	return ok, nil
+}
+
+// Publish hands m to every subscription attached to topic. The topic must exist.
+func (b *Memory) Publish(ctx context.Context, topic string, m *Message) PublishResult {
+	r := NewResult()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[topic]
+	if !ok {
+		r.Set("", fmt.Errorf("topic %s not found", topic))
+		return r
+	}
+
+	r.Set(b.publish(t, m.Data, m.Attributes, m.OrderingKey), nil)
+	return r
+}
+
+// publish must be called with b.mu held.
+func (b *Memory) publish(t *memoryTopic, data []byte, attributes map[string]string, orderingKey string) string {
+	b.nextID++
+	msg := Message{
+		ID:          strconv.FormatInt(b.nextID, 10),
+		Data:        data,
+		Attributes:  copyAttributes(attributes),
+		PublishTime: time.Now().UTC(),
+		OrderingKey: orderingKey,
+	}
+
+	t.published = append(t.published, msg)
+
+	// Trimmed once twice the limit is reached, so not every publish copies the messages
+	limit := b.publishedLimit()
+	if len(t.published) >= 2*limit {
+		t.published = append([]Message(nil), t.published[len(t.published)-limit:]...)
+	}
+
+	for _, sub := range t.subs {
+		if !sub.filter(msg.Attributes) {
+			continue
+		}
+		sub.pending = append(sub.pending, &memoryMessage{msg: msg})
+		sub.signal()
+	}
+
+	return msg.ID
+}
+
+// Deliver hands m to the named subscription only and blocks until it is acked, nacked or
+// its ack deadline expired. It reports whether m has been acked. Other than published
+// messages, m is never redelivered.
+func (b *Memory) Deliver(ctx context.Context, subscription string, m *Message) (bool, error) {
+	b.mu.Lock()
+	sub, ok := b.subs[subscription]
+	if !ok {
+		b.mu.Unlock()
+		return false, fmt.Errorf("subscription %s not found", subscription)
+	}
+
+	b.nextID++
+	mm := &memoryMessage{
+		msg: Message{
+			ID:          strconv.FormatInt(b.nextID, 10),
+			Data:        m.Data,
+			Attributes:  copyAttributes(m.Attributes),
+			PublishTime: time.Now().UTC(),
+			OrderingKey: m.OrderingKey,
+		},
+		settled: make(chan bool, 1),
+	}
+
+	sub.pending = append(sub.pending, mm)
+	sub.signal()
+	b.mu.Unlock()
+
+	select {
+	case ack := <-mm.settled:
+		return ack, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// Flush is a noop, messages are delivered as part of Publish.
+func (b *Memory) Flush(topic string) {}
+
+// Published returns the messages published to topic so far, up to the PublishedLimit
+// most recent ones.
+func (b *Memory) Published(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[topic]
+	if !ok {
+		return nil
+	}
+
+	published := t.published
+	if limit := b.publishedLimit(); len(published) > limit {
+		published = published[len(published)-limit:]
+	}
+
+	msgs := make([]Message, len(published))
+	copy(msgs, published)
+	return msgs
+}
+
+// EnsureSubscription creates the subscription unless it exists already.
+// Other than Pubsub, a missing topic is created on the fly.
+func (b *Memory) EnsureSubscription(ctx context.Context, name string, cfg SubscriptionConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[name]; ok {
+		return nil
+	}
+
+	if cfg.AckDeadline == 0 {
+		cfg.AckDeadline = defaultMemoryAckDeadline
+	}
+
+	f, err := parseFilter(cfg.Filter)
+	if err != nil {
+		return err
+	}
+
+	sub := &memorySubscription{
+		name:    name,
+		cfg:     cfg,
+		filter:  f,
+		notify:  make(chan struct{}),
+		deleted: make(chan struct{}),
+	}
+
+	b.subs[name] = sub
+	b.ensureTopic(cfg.Topic).subs[name] = sub
+
+	if cfg.PushEndpoint != "" {
+		b.wg.Add(1)
+		go b.push(sub)
+	}
+
+	return nil
+}
+
+// Subscription returns the config of an existing subscription or nil if it doesn't exist.
+func (b *Memory) Subscription(ctx context.Context, name string) (*SubscriptionConfig, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subs[name]
+	if !ok {
+		return nil, nil
+	}
+
+	cfg := sub.cfg
+	return &cfg, nil
+}
+
+// UpdateSubscription changes the given fields of an existing subscription.
+// Other than Pubsub, it can't turn push into pull subscriptions and vice versa.
+func (b *Memory) UpdateSubscription(ctx context.Context, name string, cfg SubscriptionConfig, fields []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subs[name]
+	if !ok {
+		return fmt.Errorf("subscription %s not found", name)
+	}
+
+	updated := sub.cfg
+	for _, field := range fields {
+		switch field {
+		case FieldAckDeadline:
+			updated.AckDeadline = cfg.AckDeadline
+			if updated.AckDeadline == 0 {
+				updated.AckDeadline = defaultMemoryAckDeadline
+			}
+		case FieldPushConfig:
+			if (cfg.PushEndpoint == "") != (sub.cfg.PushEndpoint == "") {
+				return fmt.Errorf("can't switch subscription %s between push and pull", name)
+			}
+			updated.PushEndpoint = cfg.PushEndpoint
+			updated.PushServiceAccount = cfg.PushServiceAccount
+			updated.PushAudience = cfg.PushAudience
+		case FieldExpirationPolicy:
+			updated.ExpirationPolicy = cfg.ExpirationPolicy
+		case FieldDeadLetterPolicy:
+			updated.DeadLetterPolicy = cfg.DeadLetterPolicy
+		case FieldRetryPolicy:
+			updated.RetryPolicy = cfg.RetryPolicy
+		case FieldLabels:
+			updated.Labels = cfg.Labels
+		default:
+			return fmt.Errorf("can't update %s of subscription %s", field, name)
+		}
+	}
+
+	sub.cfg = updated
+	return nil
+}
+
+// DeleteSubscription removes the subscription and drops all of its messages.
+func (b *Memory) DeleteSubscription(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub, ok := b.subs[name]
+	if !ok {
+		return fmt.Errorf("subscription %s not found", name)
+	}
+
+	delete(b.subs, name)
+	delete(b.topics[sub.cfg.Topic].subs, name)
+	close(sub.deleted)
+
+	return nil
+}
+
+// Receive calls fn for each message arriving on the subscription until ctx is done.
+// At most MaxOutstandingMessages calls of fn run at once.
+func (b *Memory) Receive(ctx context.Context, subscription string, fn func(ctx context.Context, m *Message)) error {
+	b.mu.Lock()
+	sub, ok := b.subs[subscription]
+	b.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("subscription %s not found", subscription)
+	}
+
+	b.dispatch(ctx, sub, fn)
+	return nil
+}
+
+// dispatch calls fn for each message arriving on sub, with at most MaxOutstandingMessages
+// calls running at once, until ctx is done, sub is deleted or b closed. It returns once
+// all calls of fn returned.
+func (b *Memory) dispatch(ctx context.Context, sub *memorySubscription, fn func(ctx context.Context, m *Message)) {
+	max := b.MaxOutstandingMessages
+	if max <= 0 {
+		max = defaultMaxOutstandingMessages
+	}
+	outstanding := make(chan struct{}, max)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case outstanding <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		m, ok := b.next(ctx, sub)
+		if !ok {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-outstanding }()
+
+			fn(ctx, m)
+		}()
+	}
+}
+
+// Close stops all push deliveries and receivers.
+func (b *Memory) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+	})
+
+	b.wg.Wait()
+	return nil
+}
+
+// next blocks until a message is available on sub and leases it.
+func (b *Memory) next(ctx context.Context, sub *memorySubscription) (*Message, bool) {
+	for {
+		b.mu.Lock()
+		if len(sub.pending) > 0 {
+			mm := sub.pending[0]
+			sub.pending = sub.pending[1:]
+			m := b.lease(sub, mm)
+			b.mu.Unlock()
+			return m, true
+		}
+		notify := sub.notify
+		b.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, false
+		case <-sub.deleted:
+			return nil, false
+		case <-b.closed:
+			return nil, false
+		}
+	}
+}
+
+// lease hands out mm until it is settled or its ack deadline expires. Must be called with b.mu held.
+func (b *Memory) lease(sub *memorySubscription, mm *memoryMessage) *Message {
+	mm.attempts++
+	mm.lease++
+	mm.leased = true
+
+	lease := mm.lease
+	mm.timer = time.AfterFunc(sub.cfg.AckDeadline, func() {
+		b.settle(sub, mm, lease, false)
+	})
+
+	msg := mm.msg
+	msg.DeliveryAttempt = mm.attempts
+
+	return NewReceivedMessage(msg, func(ack bool) {
+		b.settle(sub, mm, lease, ack)
+	})
+}
+
+// settle acks or nacks a leased message. Settling an expired lease has no effect.
+func (b *Memory) settle(sub *memorySubscription, mm *memoryMessage, lease int, ack bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !mm.leased || mm.lease != lease {
+		return
+	}
+
+	mm.leased = false
+	mm.timer.Stop()
+
+	if mm.settled != nil {
+		mm.settled <- ack
+		return
+	}
+
+	if ack {
+		return
+	}
+
+	if p := sub.cfg.DeadLetterPolicy; p != nil && mm.attempts >= maxDeliveryAttempts(p) {
+		b.deadLetter(sub, mm)
+		return
+	}
+
+	policy := sub.cfg.RetryPolicy
+	if policy == nil && sub.cfg.PushEndpoint != "" {
+		policy = &RetryPolicy{MinimumBackoff: minPushBackoff, MaximumBackoff: maxPushBackoff}
+	}
+
+	if policy == nil {
+		sub.pending = append(sub.pending, mm)
+		sub.signal()
+		return
+	}
+
+	time.AfterFunc(backoff(policy, mm.attempts), func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		select {
+		case <-sub.deleted:
+		default:
+			sub.pending = append(sub.pending, mm)
+			sub.signal()
+		}
+	})
+}
+
+// deadLetter forwards mm to the dead letter topic of sub, adding the attributes Pubsub adds.
+// Must be called with b.mu held.
+func (b *Memory) deadLetter(sub *memorySubscription, mm *memoryMessage) {
+	attrs := copyAttributes(mm.msg.Attributes)
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	attrs[DeadLetterSourceSubscriptionAttribute] = sub.name
+	attrs[DeadLetterDeliveryCountAttribute] = strconv.Itoa(mm.attempts)
+
+	t := b.ensureTopic(sub.cfg.DeadLetterPolicy.Topic)
+	b.publish(t, mm.msg.Data, attrs, mm.msg.OrderingKey)
+}
+
+// push delivers messages of a push subscription to its endpoint.
+func (b *Memory) push(sub *memorySubscription) {
+	defer b.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-sub.deleted:
+		case <-b.closed:
+		}
+		cancel()
+	}()
+
+	b.dispatch(ctx, sub, func(ctx context.Context, m *Message) {
+		err := b.post(ctx, sub, m)
+		if err != nil {
+			m.Nack()
+			return
+		}
+		m.Ack()
+	})
+}
+
+// memoryPushEnvelope mirrors the body Pubsub sends to push endpoints.
+type memoryPushEnvelope struct {
+	Subscription string `json:"subscription"`
+	Message      struct {
+		MessageID   string            `json:"messageId"`
+		Data        []byte            `json:"data"`
+		Attributes  map[string]string `json:"attributes,omitempty"`
+		PublishTime time.Time         `json:"publishTime"`
+		OrderingKey string            `json:"orderingKey,omitempty"`
+	} `json:"message"`
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
+}
+
+func (b *Memory) post(ctx context.Context, sub *memorySubscription, m *Message) error {
+	b.mu.Lock()
+	cfg := sub.cfg
+	b.mu.Unlock()
+
+	var env memoryPushEnvelope
+	env.Subscription = fmt.Sprintf("projects/memory/subscriptions/%s", sub.name)
+	env.Message.MessageID = m.ID
+	env.Message.Data = m.Data
+	env.Message.Attributes = m.Attributes
+	env.Message.PublishTime = m.PublishTime
+	env.Message.OrderingKey = m.OrderingKey
+	env.DeliveryAttempt = m.DeliveryAttempt
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.PushEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if cfg.PushServiceAccount != "" && b.PushToken != nil {
+		audience := cfg.PushAudience
+		if audience == "" {
+			audience = cfg.PushEndpoint
+		}
+
+		token, err := b.PushToken(cfg.PushServiceAccount, audience)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.AckDeadline)
+	defer cancel()
+
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("push endpoint responded with %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (b *Memory) publishedLimit() int {
+	if b.PublishedLimit <= 0 {
+		return defaultPublishedLimit
+	}
+
+	return b.PublishedLimit
+}
+
+// ensureTopic must be called with b.mu held.
+func (b *Memory) ensureTopic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{subs: make(map[string]*memorySubscription)}
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+// signal wakes up everyone waiting for new messages. Must be called with b.mu held.
+func (s *memorySubscription) signal() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// backoff before the given delivery attempt of a nacked message is redelivered.
+// It doubles with every attempt, starting at the minimum and capped at the maximum.
+func backoff(p *RetryPolicy, attempts int) time.Duration {
+	min, max := retryBackoffs(p)
+
+	d := min
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+func copyAttributes(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+
+	return c
+}
@@ -0,0 +1,405 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deliveries receives the messages of subscription until the returned func is called.
+func deliveries(t *testing.T, b *Memory, subscription string, fn func(m *Message)) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		err := b.Receive(ctx, subscription, func(_ context.Context, m *Message) { fn(m) })
+		if err != nil {
+			t.Errorf("Receive failed: %v", err)
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// delivery is a message as it was received.
+type delivery struct {
+	id      string
+	attempt int
+	at      time.Time
+}
+
+func publish(t *testing.T, b *Memory, topic string, m *Message) string {
+	t.Helper()
+
+	id, err := b.Publish(context.Background(), topic, m).Get(context.Background())
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	return id
+}
+
+// collect receives messages of subscription, settling them with settle, until n arrived.
+func collect(t *testing.T, b *Memory, subscription string, n int, settle func(m *Message)) []delivery {
+	t.Helper()
+
+	var mu sync.Mutex
+	var got []delivery
+	all := make(chan struct{})
+
+	stop := deliveries(t, b, subscription, func(m *Message) {
+		mu.Lock()
+		got = append(got, delivery{m.ID, m.DeliveryAttempt, time.Now()})
+		if len(got) == n {
+			close(all)
+		}
+		mu.Unlock()
+
+		settle(m)
+	})
+	defer stop()
+
+	select {
+	case <-all:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("received %d messages, want %d", len(got), n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]delivery(nil), got...)
+}
+
+func TestMemoryRedelivery(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	ctx := context.Background()
+	if err := b.EnsureSubscription(ctx, "orders", SubscriptionConfig{Topic: "orders", AckDeadline: 20 * time.Millisecond}); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	id := publish(t, b, "orders", &Message{Data: []byte("1")})
+
+	// The first delivery is nacked, the second one expires, the third one is acked
+	got := collect(t, b, "orders", 3, func(m *Message) {
+		switch m.DeliveryAttempt {
+		case 1:
+			m.Nack()
+		case 3:
+			m.Ack()
+		}
+	})
+
+	for i, d := range got {
+		if d.id != id || d.attempt != i+1 {
+			t.Errorf("delivery %d = message %s attempt %d, want %s attempt %d", i, d.id, d.attempt, id, i+1)
+		}
+	}
+	if expired := got[2].at.Sub(got[1].at); expired < 20*time.Millisecond {
+		t.Errorf("redelivered %v after the ack deadline started, want at least 20ms", expired)
+	}
+
+	// Acked messages aren't redelivered, settling an expired lease has no effect
+	redelivered := make(chan *Message, 1)
+	stop := deliveries(t, b, "orders", func(m *Message) { redelivered <- m })
+	defer stop()
+
+	select {
+	case m := <-redelivered:
+		t.Errorf("acked message %s redelivered", m.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryRetryPolicy(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	cfg := SubscriptionConfig{
+		Topic:       "orders",
+		RetryPolicy: &RetryPolicy{MinimumBackoff: 20 * time.Millisecond, MaximumBackoff: 30 * time.Millisecond},
+	}
+	if err := b.EnsureSubscription(context.Background(), "orders", cfg); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	publish(t, b, "orders", &Message{Data: []byte("1")})
+
+	got := collect(t, b, "orders", 4, func(m *Message) {
+		if m.DeliveryAttempt < 4 {
+			m.Nack()
+		} else {
+			m.Ack()
+		}
+	})
+
+	// Backing off 20ms, then 40ms capped at 30ms
+	for i, min := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond} {
+		if d := got[i+1].at.Sub(got[i].at); d < min {
+			t.Errorf("attempt %d redelivered after %v, want at least %v", i+2, d, min)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := &RetryPolicy{MinimumBackoff: time.Second, MaximumBackoff: 5 * time.Second}
+
+	tests := map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	}
+
+	for attempts, want := range tests {
+		if got := backoff(p, attempts); got != want {
+			t.Errorf("backoff after %d attempts = %v, want %v", attempts, got, want)
+		}
+	}
+
+	if got := backoff(&RetryPolicy{}, 1); got != defaultMinimumBackoff {
+		t.Errorf("default backoff = %v, want %v", got, defaultMinimumBackoff)
+	}
+}
+
+func TestMemoryDeadLetter(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	ctx := context.Background()
+	cfg := SubscriptionConfig{
+		Topic:            "orders",
+		DeadLetterPolicy: &DeadLetterPolicy{Topic: "dead-letters", MaxDeliveryAttempts: 2},
+	}
+	if err := b.EnsureSubscription(ctx, "orders", cfg); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	publish(t, b, "orders", &Message{Data: []byte("1"), Attributes: map[string]string{"kind": "order"}})
+
+	collect(t, b, "orders", 2, func(m *Message) { m.Nack() })
+
+	var dead []Message
+	for start := time.Now(); len(dead) == 0 && time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		dead = b.Published("dead-letters")
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(dead))
+	}
+
+	want := map[string]string{
+		"kind":                                "order",
+		DeadLetterSourceSubscriptionAttribute: "orders",
+		DeadLetterDeliveryCountAttribute:      "2",
+	}
+	for k, v := range want {
+		if dead[0].Attributes[k] != v {
+			t.Errorf("dead letter attribute %s = %q, want %q", k, dead[0].Attributes[k], v)
+		}
+	}
+
+	redelivered := make(chan *Message, 1)
+	stop := deliveries(t, b, "orders", func(m *Message) { redelivered <- m })
+	defer stop()
+
+	select {
+	case m := <-redelivered:
+		t.Errorf("dead lettered message %s redelivered", m.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryFilterAndDeliver(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	ctx := context.Background()
+	if err := b.EnsureSubscription(ctx, "eu", SubscriptionConfig{Topic: "orders", Filter: `attributes.region = "eu"`}); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	publish(t, b, "orders", &Message{Data: []byte("us"), Attributes: map[string]string{"region": "us"}})
+	eu := publish(t, b, "orders", &Message{Data: []byte("eu"), Attributes: map[string]string{"region": "eu"}})
+
+	got := collect(t, b, "eu", 1, func(m *Message) { m.Ack() })
+	if got[0].id != eu {
+		t.Errorf("received message %s, want %s", got[0].id, eu)
+	}
+
+	// Deliver bypasses the topic and reports the outcome
+	stop := deliveries(t, b, "eu", func(m *Message) {
+		if string(m.Data) == "ack" {
+			m.Ack()
+		} else {
+			m.Nack()
+		}
+	})
+	defer stop()
+
+	for data, want := range map[string]bool{"ack": true, "nack": false} {
+		acked, err := b.Deliver(ctx, "eu", &Message{Data: []byte(data)})
+		if err != nil || acked != want {
+			t.Errorf("Deliver(%s) = %v, %v, want %v", data, acked, err, want)
+		}
+	}
+
+	if _, err := b.Deliver(ctx, "missing", &Message{}); err == nil {
+		t.Error("Deliver to a missing subscription succeeded")
+	}
+}
+
+func TestMemoryMaxOutstandingMessages(t *testing.T) {
+	b := NewMemory()
+	b.MaxOutstandingMessages = 2
+	defer b.Close()
+
+	if err := b.EnsureSubscription(context.Background(), "orders", SubscriptionConfig{Topic: "orders"}); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		publish(t, b, "orders", &Message{})
+	}
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+
+	collect(t, b, "orders", 6, func(m *Message) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		m.Ack()
+	})
+
+	if peak != 2 {
+		t.Errorf("%d messages handled at once, want 2", peak)
+	}
+}
+
+func TestMemoryPublishedLimit(t *testing.T) {
+	b := NewMemory()
+	b.PublishedLimit = 3
+	defer b.Close()
+
+	if err := b.EnsureTopic(context.Background(), "orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		publish(t, b, "orders", &Message{})
+	}
+
+	published := b.Published("orders")
+	if len(published) != 3 || published[0].ID != "8" || published[2].ID != "10" {
+		t.Errorf("Published = %v, want the messages 8 to 10", published)
+	}
+
+	if n := len(b.topics["orders"].published); n >= 6 {
+		t.Errorf("%d published messages kept, want less than twice the limit", n)
+	}
+}
+
+func TestMemoryPush(t *testing.T) {
+	type push struct {
+		env   memoryPushEnvelope
+		token string
+		at    time.Time
+	}
+
+	pushes := make(chan push, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p push
+		if err := json.NewDecoder(r.Body).Decode(&p.env); err != nil {
+			t.Errorf("invalid push envelope: %v", err)
+		}
+		p.token = r.Header.Get("Authorization")
+		p.at = time.Now()
+		pushes <- p
+
+		// Only the second attempt succeeds
+		if p.env.DeliveryAttempt < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	b := NewMemory()
+	b.PushToken = func(serviceAccount, audience string) (string, error) {
+		return serviceAccount + " for " + audience, nil
+	}
+	defer b.Close()
+
+	ctx := context.Background()
+	cfg := SubscriptionConfig{
+		Topic:              "orders",
+		PushEndpoint:       srv.URL + "/push",
+		PushServiceAccount: "pusher@example.com",
+	}
+	if err := b.EnsureSubscription(ctx, "orders", cfg); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	id := publish(t, b, "orders", &Message{Data: []byte(`{"id":1}`), Attributes: map[string]string{"kind": "order"}})
+
+	var got []push
+	for len(got) < 2 {
+		select {
+		case p := <-pushes:
+			got = append(got, p)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d pushes, want 2", len(got))
+		}
+	}
+
+	for i, p := range got {
+		if p.env.Subscription != "projects/memory/subscriptions/orders" || p.env.Message.MessageID != id || string(p.env.Message.Data) != `{"id":1}` || p.env.Message.Attributes["kind"] != "order" {
+			t.Errorf("push %d = %+v", i, p.env)
+		}
+		if want := "Bearer pusher@example.com for " + srv.URL + "/push"; p.token != want {
+			t.Errorf("push %d authorized with %q, want %q", i, p.token, want)
+		}
+		if p.env.DeliveryAttempt != i+1 {
+			t.Errorf("push %d is delivery attempt %d", i, p.env.DeliveryAttempt)
+		}
+	}
+
+	if d := got[1].at.Sub(got[0].at); d < minPushBackoff {
+		t.Errorf("failed push retried after %v, want at least %v", d, minPushBackoff)
+	}
+
+	// Deleting the subscription stops the deliveries
+	if err := b.DeleteSubscription(ctx, "orders"); err != nil {
+		t.Fatalf("DeleteSubscription failed: %v", err)
+	}
+	publish(t, b, "orders", &Message{})
+
+	select {
+	case p := <-pushes:
+		t.Errorf("pushed %s after the subscription was deleted", p.env.Message.MessageID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Pubsub is a Transport backed by Google Cloud Pubsub.
+type Pubsub struct {
+	client *pubsub.Client
+	admin  *pubsubAdmin
+
+	mu     sync.Mutex
+	topics map[string]*pubsubTopic
+}
+
+// pubsubTopic guards a topic against being stopped while messages are published to it.
+type pubsubTopic struct {
+	*pubsub.Topic
+
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// NewPubsub connects to Pubsub in the given project.
+func NewPubsub(ctx context.Context, projectID string) (*Pubsub, error) {
+	client, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup pubsub (%v)", err)
+	}
+
+	admin, err := newPubsubAdmin(ctx, projectID)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to setup pubsub (%v)", err)
+	}
+
+	return &Pubsub{
+		client: client,
+		admin:  admin,
+		topics: make(map[string]*pubsubTopic),
+	}, nil
+}
+
+// EnsureTopic creates the topic unless it exists already.
+func (p *Pubsub) EnsureTopic(ctx context.Context, topic string) error {
+	ok, err := p.client.Topic(topic).Exists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to verify topic (%v)", err)
+	}
+
+	if !ok {
+		_, err = p.client.CreateTopic(ctx, topic)
+		if err != nil {
+			return fmt.Errorf("failed to create topic (%v)", err)
+		}
+	}
+
+	return nil
+}
+
+// TopicExists reports whether topic exists.
+func (p *Pubsub) TopicExists(ctx context.Context, topic string) (bool, error) {
+	ok, err := p.client.Topic(topic).Exists(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify topic (%v)", err)
+	}
+
+	return ok, nil
+}
+
+// Publish sends m to topic. Messages with an ordering key fail, the client library in
+// use can't publish them in order.
+func (p *Pubsub) Publish(ctx context.Context, topic string, m *Message) PublishResult {
+	if m.OrderingKey != "" {
+		r := NewResult()
+		r.Set("", fmt.Errorf("can't publish to %s with ordering key %s, message ordering is not supported", topic, m.OrderingKey))
+		return r
+	}
+
+	for {
+		t := p.topic(topic)
+
+		t.mu.RLock()
+		if t.stopped {
+			// Flushed since, the next call of topic returns a fresh one
+			t.mu.RUnlock()
+			continue
+		}
+
+		r := t.Publish(ctx, &pubsub.Message{
+			Data:       m.Data,
+			Attributes: m.Attributes,
+		})
+		t.mu.RUnlock()
+
+		return r
+	}
+}
+
+// Flush blocks until all messages published to topic have been sent.
+func (p *Pubsub) Flush(topic string) {
+	p.mu.Lock()
+	t, ok := p.topics[topic]
+	delete(p.topics, topic)
+	p.mu.Unlock()
+
+	// A stopped topic can't publish anymore, the next Publish will use a fresh one.
+	if ok {
+		t.stop()
+	}
+}
+
+// EnsureSubscription creates the subscription unless it exists already.
+func (p *Pubsub) EnsureSubscription(ctx context.Context, name string, cfg SubscriptionConfig) error {
+	existing, err := p.admin.get(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to check subscription %s (%v)", name, err)
+	}
+
+	if existing != nil {
+		return nil
+	}
+
+	err = p.admin.create(ctx, name, p.admin.restSubscription(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to create subscription %s on %s (%v)", name, cfg.Topic, err)
+	}
+
+	return nil
+}
+
+// Subscription returns the config of an existing subscription or nil if it doesn't exist.
+func (p *Pubsub) Subscription(ctx context.Context, name string) (*SubscriptionConfig, error) {
+	sub, err := p.admin.get(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription %s (%v)", name, err)
+	}
+
+	if sub == nil {
+		return nil, nil
+	}
+
+	return p.admin.subscriptionConfig(sub), nil
+}
+
+// UpdateSubscription changes the given fields of an existing subscription.
+func (p *Pubsub) UpdateSubscription(ctx context.Context, name string, cfg SubscriptionConfig, fields []string) error {
+	err := p.admin.update(ctx, name, p.admin.restSubscription(cfg), fields)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription %s (%v)", name, err)
+	}
+
+	return nil
+}
+
+// DeleteSubscription removes the subscription.
+func (p *Pubsub) DeleteSubscription(ctx context.Context, name string) error {
+	return p.client.Subscription(name).Delete(ctx)
+}
+
+// Receive calls fn for each message arriving on the subscription until ctx is done.
+// The messages have no OrderingKey and DeliveryAttempt, the client library in use
+// doesn't report them.
+func (p *Pubsub) Receive(ctx context.Context, subscription string, fn func(ctx context.Context, m *Message)) error {
+	sub := p.client.Subscription(subscription)
+
+	return sub.Receive(ctx, func(ctx context.Context, pm *pubsub.Message) {
+		m := NewReceivedMessage(Message{
+			ID:          pm.ID,
+			Data:        pm.Data,
+			Attributes:  pm.Attributes,
+			PublishTime: pm.PublishTime,
+		}, func(ack bool) {
+			if ack {
+				pm.Ack()
+			} else {
+				pm.Nack()
+			}
+		})
+
+		fn(ctx, m)
+	})
+}
+
+// Close stops all topics and closes the underlying client.
+func (p *Pubsub) Close() error {
+	p.mu.Lock()
+	topics := p.topics
+	p.topics = make(map[string]*pubsubTopic)
+	p.mu.Unlock()
+
+	for _, t := range topics {
+		t.stop()
+	}
+
+	return p.client.Close()
+}
+
+func (p *Pubsub) topic(name string) *pubsubTopic {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.topics[name]
+	if !ok {
+		t = &pubsubTopic{Topic: p.client.Topic(name)}
+		p.topics[name] = t
+	}
+
+	return t
+}
+
+// stop sends the pending messages and stops t, once no Publish call is using it anymore.
+func (t *pubsubTopic) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	t.Stop()
+}
@@ -0,0 +1,291 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// pubsubAdmin manages subscriptions via the Pubsub REST API. Other than the client
+// library in use, it covers every subscription setting, e.g. dead letter and retry policies.
+type pubsubAdmin struct {
+	projectID string
+	baseURL   string
+	client    *http.Client
+}
+
+// restSubscription is a subscription as represented by the REST API.
+// See https://cloud.google.com/pubsub/docs/reference/rest/v1/projects.subscriptions
+type restSubscription struct {
+	Topic              string                `json:"topic"`
+	PushConfig         *restPushConfig       `json:"pushConfig,omitempty"`
+	AckDeadlineSeconds int                   `json:"ackDeadlineSeconds,omitempty"`
+	ExpirationPolicy   *restExpirationPolicy `json:"expirationPolicy,omitempty"`
+	DeadLetterPolicy   *restDeadLetterPolicy `json:"deadLetterPolicy,omitempty"`
+	RetryPolicy        *restRetryPolicy      `json:"retryPolicy,omitempty"`
+	Labels             map[string]string     `json:"labels,omitempty"`
+	Filter             string                `json:"filter,omitempty"`
+}
+
+type restPushConfig struct {
+	PushEndpoint string         `json:"pushEndpoint,omitempty"`
+	OIDCToken    *restOIDCToken `json:"oidcToken,omitempty"`
+}
+
+type restOIDCToken struct {
+	ServiceAccountEmail string `json:"serviceAccountEmail"`
+	Audience            string `json:"audience,omitempty"`
+}
+
+type restExpirationPolicy struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+type restDeadLetterPolicy struct {
+	DeadLetterTopic     string `json:"deadLetterTopic"`
+	MaxDeliveryAttempts int    `json:"maxDeliveryAttempts,omitempty"`
+}
+
+type restRetryPolicy struct {
+	MinimumBackoff string `json:"minimumBackoff,omitempty"`
+	MaximumBackoff string `json:"maximumBackoff,omitempty"`
+}
+
+// newPubsubAdmin talks to the emulator if PUBSUB_EMULATOR_HOST is set, just like the
+// client library, and to Google with the default credentials otherwise.
+func newPubsubAdmin(ctx context.Context, projectID string) (*pubsubAdmin, error) {
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		return &pubsubAdmin{
+			projectID: projectID,
+			baseURL:   fmt.Sprintf("http://%s/v1/", host),
+			client:    http.DefaultClient,
+		}, nil
+	}
+
+	client, err := google.DefaultClient(ctx, pubsubScope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pubsubAdmin{
+		projectID: projectID,
+		baseURL:   "https://pubsub.googleapis.com/v1/",
+		client:    client,
+	}, nil
+}
+
+// get returns the named subscription or nil if it doesn't exist.
+func (a *pubsubAdmin) get(ctx context.Context, name string) (*restSubscription, error) {
+	var sub restSubscription
+
+	status, err := a.do(ctx, http.MethodGet, a.subscriptionPath(name), nil, &sub)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+// create the named subscription.
+func (a *pubsubAdmin) create(ctx context.Context, name string, sub *restSubscription) error {
+	_, err := a.do(ctx, http.MethodPut, a.subscriptionPath(name), sub, nil)
+	return err
+}
+
+// update the given fields of the named subscription.
+func (a *pubsubAdmin) update(ctx context.Context, name string, sub *restSubscription, fields []string) error {
+	masks := make([]string, len(fields))
+	for i, field := range fields {
+		mask, ok := updateMasks[field]
+		if !ok {
+			return fmt.Errorf("can't update %s of subscription %s", field, name)
+		}
+		masks[i] = mask
+	}
+
+	body := struct {
+		Subscription *restSubscription `json:"subscription"`
+		UpdateMask   string            `json:"updateMask"`
+	}{sub, strings.Join(masks, ",")}
+
+	_, err := a.do(ctx, http.MethodPatch, a.subscriptionPath(name), body, nil)
+	return err
+}
+
+// updateMasks maps the fields reported by Diff to the REST API's field masks.
+var updateMasks = map[string]string{
+	FieldAckDeadline:      "ackDeadlineSeconds",
+	FieldPushConfig:       "pushConfig",
+	FieldExpirationPolicy: "expirationPolicy",
+	FieldDeadLetterPolicy: "deadLetterPolicy",
+	FieldRetryPolicy:      "retryPolicy",
+	FieldLabels:           "labels",
+}
+
+func (a *pubsubAdmin) do(ctx context.Context, method, path string, in, out interface{}) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, a.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s %s responded with %d: %s", method, path, resp.StatusCode, b)
+	}
+
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(b, out)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (a *pubsubAdmin) subscriptionPath(name string) string {
+	return fmt.Sprintf("projects/%s/subscriptions/%s", a.projectID, name)
+}
+
+func (a *pubsubAdmin) topicPath(name string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", a.projectID, name)
+}
+
+// restSubscription turns cfg into its REST representation.
+func (a *pubsubAdmin) restSubscription(cfg SubscriptionConfig) *restSubscription {
+	sub := &restSubscription{
+		Topic:              a.topicPath(cfg.Topic),
+		AckDeadlineSeconds: int(cfg.AckDeadline / time.Second),
+	}
+
+	if cfg.PushEndpoint != "" {
+		sub.PushConfig = &restPushConfig{PushEndpoint: cfg.PushEndpoint}
+
+		if cfg.PushServiceAccount != "" {
+			sub.PushConfig.OIDCToken = &restOIDCToken{
+				ServiceAccountEmail: cfg.PushServiceAccount,
+				Audience:            cfg.PushAudience,
+			}
+		}
+	}
+
+	// Experimental. Left out, an update would keep the subscription forever.
+	sub.ExpirationPolicy = &restExpirationPolicy{TTL: expirationPolicy(cfg.ExpirationPolicy)}
+
+	if cfg.DeadLetterPolicy != nil {
+		sub.DeadLetterPolicy = &restDeadLetterPolicy{
+			DeadLetterTopic:     a.topicPath(cfg.DeadLetterPolicy.Topic),
+			MaxDeliveryAttempts: cfg.DeadLetterPolicy.MaxDeliveryAttempts,
+		}
+	}
+
+	if cfg.RetryPolicy != nil {
+		sub.RetryPolicy = &restRetryPolicy{
+			MinimumBackoff: formatDuration(cfg.RetryPolicy.MinimumBackoff),
+			MaximumBackoff: formatDuration(cfg.RetryPolicy.MaximumBackoff),
+		}
+	}
+
+	if len(cfg.Labels) > 0 {
+		sub.Labels = cfg.Labels
+	}
+	sub.Filter = cfg.Filter
+
+	return sub
+}
+
+// subscriptionConfig turns the REST representation of a subscription into a SubscriptionConfig.
+func (a *pubsubAdmin) subscriptionConfig(sub *restSubscription) *SubscriptionConfig {
+	cfg := &SubscriptionConfig{
+		Topic:       a.topicName(sub.Topic),
+		AckDeadline: time.Duration(sub.AckDeadlineSeconds) * time.Second,
+		Labels:      sub.Labels,
+		Filter:      sub.Filter,
+	}
+
+	if pc := sub.PushConfig; pc != nil {
+		cfg.PushEndpoint = pc.PushEndpoint
+		if pc.OIDCToken != nil {
+			cfg.PushServiceAccount = pc.OIDCToken.ServiceAccountEmail
+			cfg.PushAudience = pc.OIDCToken.Audience
+		}
+	}
+
+	// Subscriptions without TTL never expire
+	cfg.ExpirationPolicy = NeverExpire
+	if ep := sub.ExpirationPolicy; ep != nil && ep.TTL != "" {
+		cfg.ExpirationPolicy = parseDuration(ep.TTL)
+	}
+
+	if dp := sub.DeadLetterPolicy; dp != nil {
+		cfg.DeadLetterPolicy = &DeadLetterPolicy{
+			Topic:               a.topicName(dp.DeadLetterTopic),
+			MaxDeliveryAttempts: dp.MaxDeliveryAttempts,
+		}
+	}
+
+	if rp := sub.RetryPolicy; rp != nil {
+		cfg.RetryPolicy = &RetryPolicy{
+			MinimumBackoff: parseDuration(rp.MinimumBackoff),
+			MaximumBackoff: parseDuration(rp.MaximumBackoff),
+		}
+	}
+
+	return cfg
+}
+
+// topicName strips the project from topics of this project.
+// Topics of other projects keep their full path.
+func (a *pubsubAdmin) topicName(path string) string {
+	return strings.TrimPrefix(path, a.topicPath(""))
+}
+
+// parseDuration parses durations as returned by the REST API, e.g. "10s" or "0.5s".
+func parseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0
+	}
+
+	return d
+}
+
+// formatDuration as expected by the REST API, e.g. "10s". Zero is left out.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
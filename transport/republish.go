@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Republish moves the messages waiting on subscription to topic, e.g. to recover messages
+// from a dead letter topic after an incident. It stops once no message arrived for idle or
+// ctx is done and returns how many messages were republished.
+//
+// Given a source, only the dead letters of the subscription named source are republished.
+// Those of other subscriptions sharing the dead letter topic are nacked, not removed.
+// The attributes Pubsub adds when forwarding a message to a dead letter topic are removed.
+func Republish(ctx context.Context, t Transport, subscription, source, topic string, idle time.Duration) (int, error) {
+	receiveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	activity := make(chan struct{}, 1)
+	go func() {
+		timer := time.NewTimer(idle)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-activity:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(idle)
+			case <-timer.C:
+				cancel()
+				return
+			case <-receiveCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var count int
+	var failure error
+
+	err := t.Receive(receiveCtx, subscription, func(_ context.Context, m *Message) {
+		if source != "" && m.Attributes[DeadLetterSourceSubscriptionAttribute] != source {
+			m.Nack()
+			return
+		}
+
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
+
+		attrs := copyAttributes(m.Attributes)
+		delete(attrs, DeadLetterSourceSubscriptionAttribute)
+		delete(attrs, DeadLetterDeliveryCountAttribute)
+
+		_, err := t.Publish(ctx, topic, &Message{
+			Data:        m.Data,
+			Attributes:  attrs,
+			OrderingKey: m.OrderingKey,
+		}).Get(ctx)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			m.Nack()
+			if failure == nil {
+				failure = fmt.Errorf("failed to republish message %s (%v)", m.ID, err)
+			}
+			cancel()
+			return
+		}
+
+		m.Ack()
+		count++
+	})
+
+	t.Flush(topic)
+
+	if err != nil {
+		return count, err
+	}
+
+	return count, failure
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nackUntil nacks every message of subscription until done reports true.
+func nackUntil(t *testing.T, b *Memory, subscription string, done func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		for !done() && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	err := b.Receive(ctx, subscription, func(_ context.Context, m *Message) {
+		m.Nack()
+	})
+	if err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if !done() {
+		t.Fatalf("%s: gave up waiting", subscription)
+	}
+}
+
+func TestRepublishSharedDeadLetterTopic(t *testing.T) {
+	b := NewMemory()
+	defer b.Close()
+
+	ctx := context.Background()
+	policy := &DeadLetterPolicy{Topic: "dead-letters"}
+
+	for _, name := range []string{"orders", "invoices"} {
+		if err := b.EnsureSubscription(ctx, name, SubscriptionConfig{Topic: name, DeadLetterPolicy: policy}); err != nil {
+			t.Fatalf("EnsureSubscription failed: %v", err)
+		}
+
+		// Dead letter subscriptions as created by surfkit
+		err := b.EnsureSubscription(ctx, name+"-dead-letters", SubscriptionConfig{
+			Topic:  "dead-letters",
+			Filter: `attributes.CloudPubSubDeadLetterSourceSubscription = "` + name + `"`,
+		})
+		if err != nil {
+			t.Fatalf("EnsureSubscription failed: %v", err)
+		}
+	}
+
+	// A dead letter subscription created before they were filtered
+	if err := b.EnsureSubscription(ctx, "unfiltered", SubscriptionConfig{Topic: "dead-letters"}); err != nil {
+		t.Fatalf("EnsureSubscription failed: %v", err)
+	}
+
+	for _, name := range []string{"orders", "invoices"} {
+		if _, err := b.Publish(ctx, name, &Message{Data: []byte(name)}).Get(ctx); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+
+		dead := len(b.Published("dead-letters")) + 1
+		nackUntil(t, b, name, func() bool { return len(b.Published("dead-letters")) == dead })
+	}
+
+	n, err := Republish(ctx, b, "orders-dead-letters", "orders", "orders", 50*time.Millisecond)
+	if err != nil || n != 1 {
+		t.Errorf("Republish = %d, %v, want 1 message", n, err)
+	}
+
+	n, err = Republish(ctx, b, "unfiltered", "invoices", "invoices", 50*time.Millisecond)
+	if err != nil || n != 1 {
+		t.Errorf("Republish of the unfiltered subscription = %d, %v, want 1 message", n, err)
+	}
+
+	for _, name := range []string{"orders", "invoices"} {
+		published := b.Published(name)
+		if len(published) != 2 {
+			t.Fatalf("%s: %d messages published, want the original and the republished one", name, len(published))
+		}
+
+		m := published[1]
+		if string(m.Data) != name {
+			t.Errorf("%s: republished %q", name, m.Data)
+		}
+		if _, ok := m.Attributes[DeadLetterSourceSubscriptionAttribute]; ok {
+			t.Errorf("%s: republished message kept the dead letter attributes", name)
+		}
+	}
+
+	// The dead letter of orders is left on the unfiltered subscription
+	n, err = Republish(ctx, b, "unfiltered", "orders", "orders", 50*time.Millisecond)
+	if err != nil || n != 1 {
+		t.Errorf("Republish of the remaining dead letter = %d, %v, want 1 message", n, err)
+	}
+}
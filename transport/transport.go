@@ -0,0 +1,240 @@
+// Package transport decouples surfkit from the messaging system events travel on.
+//
+// Two implementations are provided: Pubsub, talking to Google Cloud Pubsub, and Memory,
+// an in-process broker which allows services to run and be tested without GCP.
+package transport
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Message as sent to and received from a Transport.
+type Message struct {
+
+	// ID identifies this message. It is assigned by the Transport on publish.
+	ID string
+
+	// Data is the actual payload of the message.
+	Data []byte
+
+	// Attributes represents the key-value pairs the message is labelled with.
+	Attributes map[string]string
+
+	// PublishTime is set by the Transport for received messages.
+	PublishTime time.Time
+
+	// OrderingKey of the message, if any. Transports which can't honour it refuse to
+	// publish the message.
+	OrderingKey string
+
+	// DeliveryAttempt counts how often a received message has been delivered.
+	// It is 0 if the Transport doesn't know.
+	DeliveryAttempt int
+
+	done     func(ack bool)
+	finished int32
+}
+
+// NewReceivedMessage returns a copy of m which calls done as soon as it is acked or nacked.
+// It is meant to be used by Transport implementations.
+func NewReceivedMessage(m Message, done func(ack bool)) *Message {
+	return &Message{
+		ID:              m.ID,
+		Data:            m.Data,
+		Attributes:      m.Attributes,
+		PublishTime:     m.PublishTime,
+		OrderingKey:     m.OrderingKey,
+		DeliveryAttempt: m.DeliveryAttempt,
+		done:            done,
+	}
+}
+
+// Ack acknowledges the message. Only the first call to Ack or Nack has an effect.
+func (m *Message) Ack() {
+	m.finish(true)
+}
+
+// Nack asks for the message to be redelivered. Only the first call to Ack or Nack has an effect.
+func (m *Message) Nack() {
+	m.finish(false)
+}
+
+func (m *Message) finish(ack bool) {
+	if !atomic.CompareAndSwapInt32(&m.finished, 0, 1) {
+		return
+	}
+
+	if m.done != nil {
+		m.done(ack)
+	}
+}
+
+// A PublishResult holds the outcome of a single Publish call.
+type PublishResult interface {
+
+	// Ready returns a channel that is closed when the result is ready.
+	Ready() <-chan struct{}
+
+	// Get blocks until the message is published or ctx is done and returns the
+	// server assigned message ID.
+	Get(ctx context.Context) (serverID string, err error)
+}
+
+// SubscriptionConfig describes a subscription attached to a topic.
+type SubscriptionConfig struct {
+
+	// Topic the subscription receives messages from.
+	Topic string
+
+	// How long to wait for an ack before a message is redelivered.
+	AckDeadline time.Duration
+
+	// PushEndpoint turns the subscription into a push subscription if set.
+	PushEndpoint string
+
+	// PushServiceAccount authenticates push requests with an OIDC token issued to this
+	// service account, if set.
+	PushServiceAccount string
+
+	// PushAudience of the OIDC token. Defaults to PushEndpoint.
+	PushAudience string
+
+	// ExpirationPolicy deletes the subscription after a period of inactivity.
+	// Defaults to 31 days, NeverExpire keeps the subscription forever.
+	ExpirationPolicy time.Duration
+
+	// DeadLetterPolicy forwards messages which repeatedly failed to be delivered, if set.
+	DeadLetterPolicy *DeadLetterPolicy
+
+	// RetryPolicy delays the redelivery of nacked messages, if set. Otherwise they are
+	// redelivered right away.
+	RetryPolicy *RetryPolicy
+
+	// Labels attached to the subscription.
+	Labels map[string]string
+
+	// Filter only delivers messages whose attributes match, if set.
+	// See https://cloud.google.com/pubsub/docs/filtering
+	Filter string
+}
+
+// A DeadLetterPolicy forwards a message to Topic once it failed to be delivered
+// MaxDeliveryAttempts times, instead of redelivering it forever.
+type DeadLetterPolicy struct {
+
+	// Topic the failed messages are forwarded to.
+	Topic string
+
+	// MaxDeliveryAttempts before a message is forwarded. Pubsub accepts 5 to 100, defaults to 5.
+	MaxDeliveryAttempts int
+}
+
+// A RetryPolicy backs off exponentially, starting with MinimumBackoff, from redelivering
+// nacked messages. Pubsub defaults to 10 seconds minimum and 600 seconds maximum.
+type RetryPolicy struct {
+	MinimumBackoff time.Duration
+	MaximumBackoff time.Duration
+}
+
+// NeverExpire is an ExpirationPolicy which never deletes the subscription.
+const NeverExpire time.Duration = -1
+
+// DefaultMaxDeliveryAttempts is used by dead letter policies without MaxDeliveryAttempts.
+const DefaultMaxDeliveryAttempts = 5
+
+// Attributes Pubsub adds to messages forwarded to a dead letter topic.
+const (
+	DeadLetterSourceSubscriptionAttribute = "CloudPubSubDeadLetterSourceSubscription"
+	DeadLetterDeliveryCountAttribute      = "CloudPubSubDeadLetterSourceDeliveryCount"
+)
+
+// A Sender publishes messages to topics.
+type Sender interface {
+
+	// EnsureTopic creates the topic unless it exists already.
+	EnsureTopic(ctx context.Context, topic string) error
+
+	// Publish sends m to topic. It does not block, use the returned
+	// PublishResult to wait for the outcome.
+	Publish(ctx context.Context, topic string, m *Message) PublishResult
+
+	// Flush blocks until all messages published to topic have been sent.
+	Flush(topic string)
+}
+
+// A TopicChecker reports whether a topic exists. Senders implement it so services can check
+// their outputs are reachable.
+type TopicChecker interface {
+	TopicExists(ctx context.Context, topic string) (bool, error)
+}
+
+// A Transport moves messages from topics to subscriptions.
+type Transport interface {
+	Sender
+
+	// EnsureSubscription creates the subscription unless it exists already.
+	EnsureSubscription(ctx context.Context, name string, cfg SubscriptionConfig) error
+
+	// Subscription returns the config of an existing subscription or nil if it doesn't exist.
+	Subscription(ctx context.Context, name string) (*SubscriptionConfig, error)
+
+	// UpdateSubscription changes the given fields of an existing subscription to the values
+	// of cfg. See Diff for the field names.
+	UpdateSubscription(ctx context.Context, name string, cfg SubscriptionConfig, fields []string) error
+
+	// DeleteSubscription removes the subscription.
+	DeleteSubscription(ctx context.Context, name string) error
+
+	// Receive calls fn for each message arriving on the subscription until ctx is done.
+	// fn may be called concurrently and must either Ack or Nack the message.
+	Receive(ctx context.Context, subscription string, fn func(ctx context.Context, m *Message)) error
+
+	// Close releases all resources held by the Transport.
+	Close() error
+}
+
+// Result is a PublishResult which is resolved by calling Set.
+type Result struct {
+	ready    chan struct{}
+	once     sync.Once
+	serverID string
+	err      error
+}
+
+// NewResult returns an unresolved Result.
+func NewResult() *Result {
+	return &Result{ready: make(chan struct{})}
+}
+
+// Set resolves the Result. Only the first call has an effect.
+func (r *Result) Set(serverID string, err error) {
+	r.once.Do(func() {
+		r.serverID = serverID
+		r.err = err
+		close(r.ready)
+	})
+}
+
+// Ready returns a channel that is closed when the result is ready.
+func (r *Result) Ready() <-chan struct{} {
+	return r.ready
+}
+
+// Get blocks until the Result is resolved or ctx is done.
+func (r *Result) Get(ctx context.Context) (string, error) {
+	select {
+	case <-r.ready:
+		return r.serverID, r.err
+	default:
+	}
+
+	select {
+	case <-r.ready:
+		return r.serverID, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
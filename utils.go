@@ -1,17 +1,18 @@
 package surfkit
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
+	"github.com/helloink/surfkit/logging"
+	"github.com/helloink/surfkit/tracing"
 )
 
 // NewAuthenticateableRequest prepares a Request object to be executed
@@ -19,11 +20,13 @@ import (
 //
 // Different strategies are applied, order as presented, to retrieve a bearer token:
 //
-// If ENV['BEARER_TOKEN'] is available in the current environment it will be used.
+// If ENV['BEARER_TOKEN'] is available in the current environment it will be used. It may
+// refer to a secret, or be read from the file ENV['BEARER_TOKEN_FILE'] points to, see ReadEnv.
 //
 // If a local metadata service is available,
 // it retrieves a Bearer token from it and attaches this token to the Head of the newly
-// created request object.
+// created request object. Without a token from the metadata service, the request is sent
+// unauthenticated.
 //
 // Availability of a local metadata service, meaning the requirement to authenticate the request,
 // is determined by the request url. It is expected that secure HTTP indicates this requirement.
@@ -34,6 +37,8 @@ import (
 //
 // For details on how the backoff works
 // check https://cloud.google.com/storage/docs/exponential-backoff
+//
+// An error is returned if BEARER_TOKEN is set, but can't be resolved.
 func NewAuthenticateableRequest(method, url string, body io.Reader) (*http.Request, error) {
 
 	if !strings.HasPrefix(url, "https") {
@@ -41,17 +46,36 @@ func NewAuthenticateableRequest(method, url string, body io.Reader) (*http.Reque
 	}
 
 	authToken, err := readBearerToken(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bearer token (%v)", err)
+	}
 
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	if authToken != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", authToken))
+	}
 	return req, nil
 
 }
 
+// NewAuthenticateableRequestContext works like NewAuthenticateableRequest, but binds the
+// request to ctx and passes on the trace context of ctx in the traceparent header.
+func NewAuthenticateableRequestContext(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := NewAuthenticateableRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	tracing.Inject(ctx, req.Header)
+
+	return req, nil
+}
+
 // DoAuthenticateableRequest creates an authenticatable http Request and executes it.
 // See NewAuthenticateableRequest for more details
 func DoAuthenticateableRequest(method, url string, body io.Reader) (*http.Response, error) {
@@ -66,16 +90,44 @@ func DoAuthenticateableRequest(method, url string, body io.Reader) (*http.Respon
 	return client.Do(req)
 }
 
-// readBearerToken from environment or metadata service
-func readBearerToken(url string) (string, error) {
-	var authToken string
-	var err error
+// DoAuthenticateableRequestContext works like DoAuthenticateableRequest, but binds the
+// request to ctx and passes on its trace context.
+func DoAuthenticateableRequestContext(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := NewAuthenticateableRequestContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
 
-	token, ok := os.LookupEnv("BEARER_TOKEN")
+	client := &http.Client{}
+	return client.Do(req)
+}
+
+// readBearerToken from environment or metadata service. Only a BEARER_TOKEN which
+// can't be resolved is an error, without a token from the metadata service an empty
+// token is returned.
+func readBearerToken(url string) (string, error) {
+	token, ok, err := lookupEnv(context.Background(), "BEARER_TOKEN")
+	if err != nil {
+		return "", err
+	}
 	if ok {
 		return token, nil
 	}
 
+	token, err = readMetadataToken(url)
+	if err != nil {
+		logging.Default.Warning("AuthenticatedRequest: Sending request without authentication", logging.Fields{"error": err})
+		return "", nil
+	}
+
+	return token, nil
+}
+
+// readMetadataToken retrieves an ID token for url from the metadata service
+func readMetadataToken(url string) (string, error) {
+	var authToken string
+	var err error
+
 	maxbackoff := time.Now().Add(16 * time.Second)
 	backoffIter := 1
 
@@ -92,11 +144,11 @@ func readBearerToken(url string) (string, error) {
 
 			// Metadata errors. There is a metadata service but it seems to have problems
 			case *metadata.Error:
-				log.Printf("AuthenticatedRequest: Failed to query metadata. Status: %d, Message: %s (%+v)", t.Code, t.Message, err)
+				logging.Default.Warning("AuthenticatedRequest: Failed to query metadata", logging.Fields{"status": t.Code, "error": err})
 
 			// Connection errors. Likely there is no metadata service so we skip authentication.
 			case net.Error:
-				log.Printf("AuthenticatedRequest: Failed to query metadata. Connection problem (%+v)", err)
+				logging.Default.Warning("AuthenticatedRequest: Failed to query metadata. Connection problem", logging.Fields{"error": err})
 
 			// All other errors
 			default:
@@ -111,7 +163,7 @@ func readBearerToken(url string) (string, error) {
 		waitTime := (backoffIter * 1000) + rand.New(rand.NewSource(time.Now().UnixNano())).Intn(500)
 		backoffIter = backoffIter * 2
 
-		log.Printf("AuthenticatedRequest: Backing off for %d", waitTime)
+		logging.Default.Info(fmt.Sprintf("AuthenticatedRequest: Backing off for %dms", waitTime), logging.Fields{"backoff": time.Duration(waitTime) * time.Millisecond})
 		time.Sleep(time.Duration(waitTime) * time.Millisecond)
 	}
 
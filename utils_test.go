@@ -0,0 +1,60 @@
+package surfkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewAuthenticateableRequest(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || !strings.HasSuffix(r.URL.Path, "/instance/service-accounts/default/identity") || r.URL.Query().Get("audience") != "https://orders.example.com" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("id-token"))
+	}))
+	defer metadata.Close()
+
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+
+	tests := []struct {
+		name    string
+		url     string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{name: "http", url: "http://orders.example.com", token: "s3cr3t", want: ""},
+		{name: "bearer token", url: "https://orders.example.com", token: "s3cr3t", want: "Bearer s3cr3t"},
+		{name: "metadata", url: "https://orders.example.com", want: "Bearer id-token"},
+		{name: "no token from metadata", url: "https://other.example.com", want: ""},
+		{name: "unresolvable bearer token", url: "https://orders.example.com", token: "sm://my-project", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BEARER_TOKEN", tt.token)
+			if tt.token == "" {
+				os.Unsetenv("BEARER_TOKEN")
+			}
+
+			req, err := NewAuthenticateableRequest("GET", tt.url, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("NewAuthenticateableRequest succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewAuthenticateableRequest failed: %v", err)
+			}
+
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}